@@ -10,8 +10,12 @@ type Waiter struct {
 	counter     int
 	counterLock sync.Mutex
 
-	message map[int](chan string)
-	lock    sync.RWMutex
+	message     map[int](chan string)
+	drained     []chan struct{}
+	interrupted chan struct{}
+	done        chan struct{}
+	closed      bool
+	lock        sync.RWMutex
 }
 
 // Next gets a new ID for an ack message.
@@ -45,6 +49,11 @@ func (w *Waiter) Set(id int, msg chan string) {
 func (w *Waiter) Delete(id int) {
 	w.lock.Lock()
 	delete(w.message, id)
+
+	if len(w.message) == 0 {
+		w.notifyDrained()
+	}
+
 	w.lock.Unlock()
 }
 
@@ -63,3 +72,83 @@ func (w *Waiter) Size() int {
 	w.lock.RUnlock()
 	return s
 }
+
+// Drained returns a channel that is closed once there are no pending acks.
+func (w *Waiter) Drained() <-chan struct{} {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	c := make(chan struct{})
+
+	if len(w.message) == 0 {
+		close(c)
+		return c
+	}
+
+	w.drained = append(w.drained, c)
+	return c
+}
+
+// Done returns a channel that is closed when the waiter is closed.
+func (w *Waiter) Done() <-chan struct{} {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.done == nil {
+		w.done = make(chan struct{})
+	}
+
+	return w.done
+}
+
+// Interrupted returns a channel that is closed the next time Interrupt is called.
+func (w *Waiter) Interrupted() <-chan struct{} {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.interrupted == nil {
+		w.interrupted = make(chan struct{})
+	}
+
+	return w.interrupted
+}
+
+// Interrupt everyone waiting on Interrupted, when acks are no longer expected to arrive.
+func (w *Waiter) Interrupt() {
+	w.lock.Lock()
+
+	if w.interrupted != nil {
+		close(w.interrupted)
+		w.interrupted = nil
+	}
+
+	w.lock.Unlock()
+}
+
+// Close the waiter, releasing everyone waiting on Done and removing pending acks.
+func (w *Waiter) Close() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return
+	}
+
+	w.closed = true
+
+	if w.done == nil {
+		w.done = make(chan struct{})
+	}
+
+	close(w.done)
+	w.message = nil
+	w.notifyDrained()
+}
+
+func (w *Waiter) notifyDrained() {
+	for _, c := range w.drained {
+		close(c)
+	}
+
+	w.drained = nil
+}
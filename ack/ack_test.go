@@ -90,3 +90,80 @@ func TestWaiterLimit(t *testing.T) {
 		t.Errorf("Expected next position to be 2, got %v instead", next)
 	}
 }
+
+func TestWaiterDrained(t *testing.T) {
+	var w = Waiter{}
+
+	select {
+	case <-w.Drained():
+	default:
+		t.Error("Expected empty waiter to be drained")
+	}
+
+	w.Set(w.Next(), make(chan string, 1))
+	drained := w.Drained()
+
+	select {
+	case <-drained:
+		t.Error("Expected waiter with pending acks not to be drained")
+	default:
+	}
+
+	w.Delete(1)
+
+	select {
+	case <-drained:
+	default:
+		t.Error("Expected waiter to be drained after deleting the last ack")
+	}
+}
+
+func TestWaiterClose(t *testing.T) {
+	var w = Waiter{}
+	w.Set(w.Next(), make(chan string, 1))
+
+	done := w.Done()
+	drained := w.Drained()
+
+	w.Close()
+	w.Close()
+
+	select {
+	case <-done:
+	default:
+		t.Error("Expected done channel to be closed")
+	}
+
+	select {
+	case <-drained:
+	default:
+		t.Error("Expected waiter to be drained after closing")
+	}
+
+	if s := w.Size(); s != 0 {
+		t.Errorf("Expected size to be 0, got %v instead", s)
+	}
+}
+
+func TestWaiterInterrupt(t *testing.T) {
+	var w = Waiter{}
+	interrupted := w.Interrupted()
+
+	if again := w.Interrupted(); again != interrupted {
+		t.Error("Expected the same channel until the waiter is interrupted")
+	}
+
+	w.Interrupt()
+
+	select {
+	case <-interrupted:
+	default:
+		t.Error("Expected interrupted channel to be closed")
+	}
+
+	select {
+	case <-w.Interrupted():
+		t.Error("Expected a new channel after the waiter is interrupted")
+	default:
+	}
+}
@@ -0,0 +1,186 @@
+package gosocketio
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/wedeploy/gosocketio/transport"
+	"github.com/wedeploy/gosocketio/transport/fault"
+)
+
+func TestAckRetries(t *testing.T) {
+	m := newMockServer(t)
+	defer m.Close()
+
+	c := New(m.URL(), &Options{
+		AckTimeout: 50 * time.Millisecond,
+		AckRetries: 2,
+	})
+
+	defer c.Close()
+
+	if err := c.Open(context.Background()); err != nil {
+		t.Fatalf("Expected no error opening connection, got %v instead", err)
+	}
+
+	conn := <-m.conns
+	ackErr := make(chan error, 1)
+	var v string
+
+	go func() {
+		ackErr <- c.Ack(context.Background(), "book", "JFK", &v)
+	}()
+
+	// the same message is sent again once the first attempt times out
+	m.expect(t, `421["book","JFK"]`)
+	m.expect(t, `421["book","JFK"]`)
+
+	_ = conn.WriteMessage(ws.TextMessage, []byte(`431["Hilton"]`))
+
+	if err := <-ackErr; err != nil {
+		t.Errorf("Expected no error receiving ack, got %v instead", err)
+	}
+
+	if v != "Hilton" {
+		t.Errorf("Expected ack value to be Hilton, got %v instead", v)
+	}
+}
+
+func TestAckRetriesLostMessage(t *testing.T) {
+	p := transport.NewPipe()
+	defer p.Close()
+
+	ft := fault.New(p)
+	c, peer := openPipe(t, p, &Options{
+		Transport:  ft,
+		AckTimeout: 50 * time.Millisecond,
+		AckRetries: 1,
+	}, `0{"sid":"abc","upgrades":[],"pingInterval":25000,"pingTimeout":20000}`)
+
+	defer c.Close()
+
+	// the first attempt never reaches the server
+	ft.Outgoing(fault.Event("book")).Drop()
+
+	ackErr := make(chan error, 1)
+	var v string
+
+	go func() {
+		ackErr <- c.Ack(context.Background(), "book", "JFK", &v)
+	}()
+
+	expectFrame(t, peer, `421["book","JFK"]`)
+	_ = peer.WriteFrame(transport.Frame{Data: []byte(`431["Hilton"]`)})
+
+	if err := <-ackErr; err != nil || v != "Hilton" {
+		t.Errorf("Expected ack value to be Hilton, got %v (%v) instead", v, err)
+	}
+}
+
+func TestAckTimeout(t *testing.T) {
+	c, m := connectMock(t)
+	defer m.Close()
+	defer c.Close()
+
+	var v string
+
+	err := c.AckWithOptions(context.Background(), "book", "JFK", &v, &AckOptions{
+		Timeout: 10 * time.Millisecond,
+		Retries: 1,
+		Backoff: 10 * time.Millisecond,
+	})
+
+	if err != ErrAckTimeout {
+		t.Errorf("Expected error to be %v, got %v instead", ErrAckTimeout, err)
+	}
+
+	m.expect(t, `421["book","JFK"]`)
+	m.expect(t, `421["book","JFK"]`)
+}
+
+func TestAckConnectionLost(t *testing.T) {
+	for _, reconnect := range []bool{true, false} {
+		m := newMockServer(t)
+
+		c := New(m.URL(), &Options{
+			Reconnect:      reconnect,
+			ReconnectDelay: time.Hour,
+		})
+
+		if err := c.Open(context.Background()); err != nil {
+			t.Fatalf("Expected no error opening connection, got %v instead", err)
+		}
+
+		conn := <-m.conns
+		ackErr := make(chan error, 1)
+		var v string
+
+		go func() {
+			ackErr <- c.Ack(context.Background(), "book", "JFK", &v)
+		}()
+
+		m.expect(t, `421["book","JFK"]`)
+		_ = conn.Close()
+
+		select {
+		case err := <-ackErr:
+			if err != ErrConnectionLost {
+				t.Errorf("Expected error to be %v with reconnect %v, got %v instead", ErrConnectionLost, reconnect, err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Expected pending ack to fail once the connection drops with reconnect %v", reconnect)
+		}
+
+		c.Close()
+		m.Close()
+	}
+}
+
+func TestAckRetriesSendBuffer(t *testing.T) {
+	m := newMockServer(t)
+	defer m.Close()
+
+	c := New(m.URL(), &Options{
+		SendBuffer: 10,
+	})
+
+	defer c.Close()
+
+	ns, err := c.Of("/shell")
+
+	if err != nil {
+		t.Fatalf("Expected no error declaring namespace, got %v instead", err)
+	}
+
+	if err := c.Open(context.Background()); err != nil {
+		t.Fatalf("Expected no error opening connection, got %v instead", err)
+	}
+
+	conn := <-m.conns
+	m.expect(t, "40/shell")
+
+	err = ns.AckWithOptions(context.Background(), "book", "JFK", nil, &AckOptions{
+		Timeout: 10 * time.Millisecond,
+		Retries: 2,
+	})
+
+	if err != ErrAckTimeout {
+		t.Errorf("Expected error to be %v, got %v instead", ErrAckTimeout, err)
+	}
+
+	// the attempts are queued only once while the namespace isn't connected
+	if b := ns.Buffered(); b != 1 {
+		t.Errorf("Expected 1 buffered message, got %v instead", b)
+	}
+
+	if err := ns.Emit("stdin", "ls"); err != nil {
+		t.Errorf("Expected no error emitting, got %v instead", err)
+	}
+
+	_ = conn.WriteMessage(ws.TextMessage, []byte(`40/shell`))
+
+	m.expect(t, `42/shell,1["book","JFK"]`)
+	m.expect(t, `42/shell,["stdin","ls"]`)
+}
@@ -0,0 +1,89 @@
+package gosocketio
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/wedeploy/gosocketio/websocket"
+)
+
+// ErrSendBufferFull is returned when a message can't be queued because the send buffer is full.
+var ErrSendBufferFull = errors.New("socket.io send buffer is full")
+
+// Overflow policy for when the send buffer is full.
+type Overflow int
+
+const (
+	// OverflowReject refuses new messages with ErrSendBufferFull.
+	OverflowReject Overflow = iota
+
+	// OverflowDropOldest discards the oldest queued message to make room for the new one.
+	OverflowDropOldest
+)
+
+// sendBuffer queues encoded messages while a namespace isn't connected.
+type sendBuffer struct {
+	size     int
+	overflow Overflow
+	queue    []websocket.Frame
+}
+
+func newSendBuffer(size int, overflow Overflow) *sendBuffer {
+	if size <= 0 {
+		return nil
+	}
+
+	return &sendBuffer{
+		size:     size,
+		overflow: overflow,
+	}
+}
+
+func (b *sendBuffer) push(frame websocket.Frame) error {
+	if len(b.queue) < b.size {
+		b.queue = append(b.queue, frame)
+		return nil
+	}
+
+	if b.overflow != OverflowDropOldest {
+		return ErrSendBufferFull
+	}
+
+	b.queue = append(b.queue[1:], frame)
+	return nil
+}
+
+// drain removes and returns the queued messages, oldest first.
+func (b *sendBuffer) drain() []websocket.Frame {
+	queue := b.queue
+	b.queue = nil
+	return queue
+}
+
+// restore messages that couldn't be written, ahead of the queued ones, so they are written first.
+func (b *sendBuffer) restore(frames []websocket.Frame) {
+	b.queue = append(append([]websocket.Frame{}, frames...), b.queue...)
+}
+
+func (b *sendBuffer) len() int {
+	if b == nil {
+		return 0
+	}
+
+	return len(b.queue)
+}
+
+// contains tells whether the message is still queued.
+func (b *sendBuffer) contains(frame websocket.Frame) bool {
+	if b == nil {
+		return false
+	}
+
+	for _, f := range b.queue {
+		if f.Binary == frame.Binary && bytes.Equal(f.Data, frame.Data) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,260 @@
+package gosocketio
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/wedeploy/gosocketio/transport"
+	"github.com/wedeploy/gosocketio/transport/fault"
+	"github.com/wedeploy/gosocketio/websocket"
+)
+
+func TestSendBufferDisabled(t *testing.T) {
+	if b := newSendBuffer(0, OverflowReject); b != nil {
+		t.Errorf("Expected no buffer, got %v instead", b)
+	}
+}
+
+func TestSendBufferReject(t *testing.T) {
+	b := newSendBuffer(2, OverflowReject)
+
+	for _, c := range []string{"a", "b"} {
+		if err := b.push(textFrame(c)); err != nil {
+			t.Errorf("Expected no error, got %v instead", err)
+		}
+	}
+
+	if err := b.push(textFrame("c")); err != ErrSendBufferFull {
+		t.Errorf("Expected error to be %v, got %v instead", ErrSendBufferFull, err)
+	}
+
+	if got, want := b.drain(), []websocket.Frame{textFrame("a"), textFrame("b")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected queue to be %v, got %v instead", want, got)
+	}
+
+	if l := b.len(); l != 0 {
+		t.Errorf("Expected buffer to be empty, got %v messages instead", l)
+	}
+}
+
+func TestSendBufferDropOldest(t *testing.T) {
+	b := newSendBuffer(2, OverflowDropOldest)
+
+	for _, c := range []string{"a", "b", "c"} {
+		if err := b.push(textFrame(c)); err != nil {
+			t.Errorf("Expected no error, got %v instead", err)
+		}
+	}
+
+	if got, want := b.drain(), []websocket.Frame{textFrame("b"), textFrame("c")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected queue to be %v, got %v instead", want, got)
+	}
+}
+
+func TestClientSendBuffer(t *testing.T) {
+	m := newMockServer(t)
+	defer m.Close()
+
+	c := New(m.URL(), &Options{
+		SendBuffer: 2,
+	})
+
+	ns, err := c.Of("/shell")
+
+	if err != nil {
+		t.Fatalf("Expected no error declaring namespace, got %v instead", err)
+	}
+
+	for _, cmd := range []string{"ls", "pwd"} {
+		if err := ns.Emit("stdin", cmd); err != nil {
+			t.Errorf("Expected no error emitting, got %v instead", err)
+		}
+	}
+
+	if err := ns.Emit("stdin", "whoami"); err != ErrSendBufferFull {
+		t.Errorf("Expected error to be %v, got %v instead", ErrSendBufferFull, err)
+	}
+
+	if err := c.Emit("hello", 1); err != nil {
+		t.Errorf("Expected no error emitting, got %v instead", err)
+	}
+
+	if err := c.Open(context.Background()); err != nil {
+		t.Fatalf("Expected no error opening connection, got %v instead", err)
+	}
+
+	conn := <-m.conns
+
+	// the default namespace buffer is flushed once the session is established,
+	// without holding back the connection packet of the other namespace
+	received := map[string]bool{}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case got := <-m.received:
+			received[got] = true
+		case <-time.After(time.Second):
+		}
+	}
+
+	if !received[`42["hello",1]`] || !received["40/shell"] {
+		t.Errorf("Expected server to receive the buffered message and the namespace connection, got %v instead", received)
+	}
+
+	if b := ns.Buffered(); b != 2 {
+		t.Errorf("Expected 2 buffered messages, got %v instead", b)
+	}
+
+	_ = conn.WriteMessage(ws.TextMessage, []byte(`40/shell`))
+
+	m.expect(t, `42/shell,["stdin","ls"]`)
+	m.expect(t, `42/shell,["stdin","pwd"]`)
+
+	if err := ns.Emit("stdin", "exit"); err != nil {
+		t.Errorf("Expected no error emitting, got %v instead", err)
+	}
+
+	m.expect(t, `42/shell,["stdin","exit"]`)
+
+	c.Close()
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- ns.Emit("stdin", "ls")
+	}()
+
+	select {
+	case err := <-done:
+		if err != ErrClientClosed {
+			t.Errorf("Expected error to be %v, got %v instead", ErrClientClosed, err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected emit on a closed client to return")
+	}
+}
+
+func TestClientSendBufferFlushFails(t *testing.T) {
+	const handshake = `0{"sid":"abc","upgrades":[],"pingInterval":25000,"pingTimeout":20000}`
+
+	p := transport.NewPipe()
+	defer p.Close()
+
+	ft := fault.New(p)
+	c, peer := openPipe(t, p, &Options{
+		Transport:      ft,
+		SendBuffer:     10,
+		Reconnect:      true,
+		ReconnectDelay: time.Millisecond,
+	}, handshake)
+
+	defer c.Close()
+
+	ns, err := c.Of("/shell")
+
+	if err != nil {
+		t.Fatalf("Expected no error declaring namespace, got %v instead", err)
+	}
+
+	expectFrame(t, peer, "40/shell")
+
+	for _, cmd := range []string{"ls", "pwd", "whoami"} {
+		if err := ns.Emit("stdin", cmd); err != nil {
+			t.Errorf("Expected no error emitting, got %v instead", err)
+		}
+	}
+
+	// the connection is cut while flushing the second message
+	ft.Outgoing(fault.Event("stdin")).After(1).Cut()
+	_ = peer.WriteFrame(transport.Frame{Data: []byte(`40/shell`)})
+
+	expectFrame(t, peer, `42/shell,["stdin","ls"]`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	peer, err = p.Accept(ctx)
+
+	if err != nil {
+		t.Fatalf("Expected reconnection, got %v instead", err)
+	}
+
+	_ = peer.WriteFrame(transport.Frame{Data: []byte(handshake)})
+
+	// both namespaces are joined again, in no particular order
+	joined := map[string]bool{}
+
+	for i := 0; i < 2; i++ {
+		if f, err := peer.ReadFrame(); err == nil {
+			joined[string(f.Data)] = true
+		}
+	}
+
+	if !joined["40"] || !joined["40/shell"] {
+		t.Errorf("Expected namespaces to be joined again, got %v instead", joined)
+	}
+
+	_ = peer.WriteFrame(transport.Frame{Data: []byte(`40{"sid":"def"}`)})
+
+	if b := ns.Buffered(); b != 2 {
+		t.Errorf("Expected the 2 unsent messages to be buffered, got %v instead", b)
+	}
+
+	_ = peer.WriteFrame(transport.Frame{Data: []byte(`40/shell`)})
+
+	// the unsent messages are flushed in order once the namespace is connected again
+	expectFrame(t, peer, `42/shell,["stdin","pwd"]`)
+	expectFrame(t, peer, `42/shell,["stdin","whoami"]`)
+}
+
+func TestClientSendBufferFlushDoesNotBlock(t *testing.T) {
+	p := transport.NewPipe()
+	defer p.Close()
+
+	ft := fault.New(p)
+	c, peer := openPipe(t, p, &Options{
+		Transport:  ft,
+		SendBuffer: 10,
+	}, `0{"sid":"abc","upgrades":[],"pingInterval":25000,"pingTimeout":20000}`)
+
+	defer c.Close()
+
+	ns, err := c.Of("/shell")
+
+	if err != nil {
+		t.Fatalf("Expected no error declaring namespace, got %v instead", err)
+	}
+
+	expectFrame(t, peer, "40/shell")
+
+	news := make(chan string, 1)
+
+	if err := ns.On("news", func(s string) {
+		news <- s
+	}); err != nil {
+		t.Fatalf("Expected no error adding listener, got %v instead", err)
+	}
+
+	if err := ns.Emit("stdin", "ls"); err != nil {
+		t.Errorf("Expected no error emitting, got %v instead", err)
+	}
+
+	// the flushed message takes long to be written, but messages are still read meanwhile
+	ft.Outgoing(fault.Event("stdin")).Delay(time.Second)
+	_ = peer.WriteFrame(transport.Frame{Data: []byte(`40/shell`)})
+	_ = peer.WriteFrame(transport.Frame{Data: []byte(`42/shell,["news","hi"]`)})
+
+	select {
+	case got := <-news:
+		if got != "hi" {
+			t.Errorf("Expected news to be hi, got %v instead", got)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Error("Expected messages to be read while the send buffer is flushed")
+	}
+
+	expectFrame(t, peer, `42/shell,["stdin","ls"]`)
+}
@@ -2,6 +2,7 @@ package gosocketio
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"strings"
@@ -9,7 +10,13 @@ import (
 	"time"
 
 	"github.com/wedeploy/gosocketio/ack"
+	"github.com/wedeploy/gosocketio/clock"
+	"github.com/wedeploy/gosocketio/codec"
+	"github.com/wedeploy/gosocketio/engineio"
 	"github.com/wedeploy/gosocketio/internal/protocol"
+	"github.com/wedeploy/gosocketio/outbox"
+	sio "github.com/wedeploy/gosocketio/protocol"
+	"github.com/wedeploy/gosocketio/transport"
 	"github.com/wedeploy/gosocketio/websocket"
 )
 
@@ -27,106 +34,300 @@ const (
 	defaultNamespace = ""
 )
 
+var (
+	// ErrClientClosed is returned when using a client that is closed or shutting down.
+	ErrClientClosed = errors.New("socket.io client is closed")
+
+	// ErrNamespaceDisconnected is returned when a namespace is disconnected while joining it.
+	ErrNamespaceDisconnected = errors.New("socket.io namespace disconnected")
+
+	// ErrAlreadyOpen is returned when opening a client more than once.
+	ErrAlreadyOpen = errors.New("socket.io client is already open")
+
+	// ErrServerDisconnect is used when the server terminates the connection or disconnects a namespace.
+	ErrServerDisconnect = errors.New("socket.io server disconnected")
+
+	// ErrAckTimeout is returned when the server doesn't acknowledge a message in time.
+	ErrAckTimeout = errors.New("socket.io ack timeout")
+
+	// ErrConnectionLost is returned for pending acks when the connection drops.
+	ErrConnectionLost = errors.New("socket.io connection lost")
+
+	// ErrInvalidNamespace is returned when joining a namespace whose name doesn't start with '/' or has a comma.
+	ErrInvalidNamespace = errors.New("socket.io invalid namespace")
+)
+
 // Connect dials and waits for the "connection" event.
 // It blocks for the timeout duration. If the connection is not established in time,
 // it closes the connection and returns an error.
 func Connect(u url.URL, tr *websocket.Transport) (c *Client, err error) {
-	c, err = dial(u, tr)
+	c = New(u, &Options{
+		Transport: tr,
+		Clock:     tr.Clock,
+	})
 
-	if err != nil {
+	ctx, cancel := clock.WithTimeout(context.Background(), c.opts.Clock, tr.PingTimeout)
+	defer cancel()
+
+	switch err = c.Open(ctx); {
+	case err == context.DeadlineExceeded:
+		return nil, fmt.Errorf("socket.io connection timeout (%v)", tr.PingTimeout)
+	case err != nil:
 		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), tr.PingTimeout)
-	defer cancel()
+	return c, nil
+}
 
-	handshake := make(chan struct{}, 1)
-	ec := make(chan error, 1)
+// DialOnly connects to the host and establishes the engine.io session.
+// It doesn't wait for socket.io connection handshake.
+// You probably want to use Connect instead. Only exposed for debugging.
+func DialOnly(u url.URL, tr *websocket.Transport) (c *Client, err error) {
+	var opts Options
 
-	if err := c.On(OnConnection, func() {
-		handshake <- struct{}{}
-	}); err != nil {
-		cancel()
-		return nil, err
+	if tr != nil {
+		opts.Transport = tr
 	}
 
-	if err := c.On(OnError, func(err error) {
-		ec <- err
-	}); err != nil {
-		cancel()
-		return nil, err
-	}
+	c = New(u, &opts)
 
-	select {
-	case <-handshake:
-		c.Off(OnConnection)
-		c.Off(OnError)
-	case e := <-ec:
-		c = nil
-		err = e
-		c.Off(OnConnection)
-		c.Off(OnError)
-	case <-ctx.Done():
-		c = nil
-		err = fmt.Errorf("socket.io connection timeout (%v)", tr.PingTimeout)
+	if err := c.dial(context.Background()); err != nil {
+		return nil, err
 	}
 
-	cancel()
-	return c, err
+	return c, nil
 }
 
-// DialOnly connects to the host and initializes the socket.io protocol.
-// It doesn't wait for socket.io connection handshake.
-// You probably want to use Connect instead. Only exposed for debugging.
-func DialOnly(u url.URL, tr *websocket.Transport) (c *Client, err error) {
-	return dial(u, tr)
+// Options for the client.
+type Options struct {
+	// Transport used to connect to the server, such as WebSocket or an in-memory transport.Pipe.
+	// If nil, websocket.NewTransport() is used.
+	Transport transport.Transport
+
+	// SendBuffer is the number of messages queued per namespace while it isn't connected.
+	// Queued messages are written in order once the namespace is connected.
+	// If zero, messages are written right away, regardless of the namespace state.
+	SendBuffer int
+
+	// SendBufferOverflow is the policy for when the send buffer is full.
+	SendBufferOverflow Overflow
+
+	// WriteQueueSize is the number of messages waiting to be written to the connection.
+	// Control packets, such as pongs, namespace connections, and ack responses, skip ahead and aren't limited.
+	// If zero, WriteQueueSize is used.
+	WriteQueueSize int
+
+	// WriteQueueFull is the policy for when the write queue is full.
+	WriteQueueFull QueueFull
+
+	// WriteQueueTimeout limits how long to wait for room in the write queue with QueueBlock.
+	// If zero, it waits until there is room or the client is closed.
+	WriteQueueTimeout time.Duration
+
+	// EngineIO protocol revision: 3 for socket.io v2 servers, or 4 for socket.io v3 and later.
+	// If zero, 3 is used.
+	EngineIO int
+
+	// Upgrade starts the session with HTTP long-polling and upgrades it to WebSocket, like the JavaScript client.
+	// Use it for servers or proxies that don't accept WebSocket connections without a session.
+	Upgrade bool
+
+	// Reconnect automatically when the connection is lost.
+	// Namespaces that were joined are joined again, recovering their sessions
+	// if the server supports connection state recovery (socket.io v4.6 and later, with EngineIO 4).
+	Reconnect bool
+
+	// ReconnectAttempts before giving up and closing the client. If zero, there is no limit.
+	ReconnectAttempts int
+
+	// ReconnectDelay before the first reconnection attempt. It doubles after each failed attempt.
+	// If zero, ReconnectDelay is used.
+	ReconnectDelay time.Duration
+
+	// ReconnectDelayMax caps the delay between reconnection attempts.
+	// If zero, ReconnectDelayMax is used.
+	ReconnectDelayMax time.Duration
+
+	// AckTimeout is the default time for the server to acknowledge a message. If zero, there is no timeout.
+	AckTimeout time.Duration
+
+	// AckRetries is the default number of times a message is sent again when its ack times out.
+	AckRetries int
+
+	// AckBackoff is the default delay before the first retry. It doubles after each retry.
+	AckBackoff time.Duration
+
+	// Codec for the payloads of packets, such as the arguments of events. If nil, codec.JSON is used.
+	// With codec.MsgPack, whole packets are sent as binary frames, as expected by socket.io-msgpack-parser.
+	Codec codec.Codec
+
+	// Outbox stores the messages sent with EmitDurable until the server acknowledges them.
+	// The client doesn't close it.
+	Outbox *outbox.Outbox
+
+	// Clock for the heartbeat, timeouts, and reconnection delays. If nil, clock.Real is used.
+	// Tests can use a clock.Fake to control them.
+	Clock clock.Clock
 }
 
-func dial(u url.URL, tr *websocket.Transport) (c *Client, err error) {
-	c = &Client{}
-	c.init()
+// New creates a client without connecting to the server.
+// Register listeners and declare namespaces with Of before calling Open,
+// so no message sent by the server on connection is missed.
+func New(u url.URL, opts *Options) *Client {
+	c := &Client{}
+
+	if opts != nil {
+		c.opts = *opts
+	}
+
+	if c.opts.Transport == nil {
+		c.opts.Transport = websocket.NewTransport()
+	}
 
-	var query = u.Query()
-	query.Add("EIO", "3")
-	query.Add("transport", "websocket")
-	u.RawQuery = query.Encode()
+	if c.opts.EngineIO == 0 {
+		c.opts.EngineIO = 3
+	}
+
+	if c.opts.ReconnectDelay == 0 {
+		c.opts.ReconnectDelay = ReconnectDelay
+	}
+
+	if c.opts.Codec == nil {
+		c.opts.Codec = codec.JSON
+	}
+
+	if c.opts.Clock == nil {
+		c.opts.Clock = clock.Real
+	}
+
+	c.parser = protocol.NewParser(c.opts.Codec)
+
+	if c.opts.WriteQueueSize == 0 {
+		c.opts.WriteQueueSize = WriteQueueSize
+	}
+
+	if c.opts.ReconnectDelayMax == 0 {
+		c.opts.ReconnectDelayMax = ReconnectDelayMax
+	}
+
+	c.init()
 
 	if !strings.HasSuffix(u.Path, "/socket.io") || !strings.HasSuffix(u.Path, "/socket.io/") {
 		u.Path = u.Path + "/socket.io/"
 	}
 
+	c.url = u
+	return c
+}
+
+// Open connects to the server and waits for the socket.io session to be established.
+// Namespaces declared with Of before calling Open are joined once the session is established.
+// If the context expires or the connection fails, the client is closed.
+// A client can only be opened once.
+func (c *Client) Open(ctx context.Context) error {
+	if err := c.dial(ctx); err != nil {
+		return err
+	}
+
+	def := c.def()
+	def.locker.RLock()
+	attempt := def.attempt
+	def.locker.RUnlock()
+
+	select {
+	case <-attempt.done:
+		if attempt.err != nil {
+			c.close(attempt.err)
+			return attempt.err
+		}
+
+		return nil
+	case <-ctx.Done():
+		c.close(ctx.Err())
+		return ctx.Err()
+	}
+}
+
+func (c *Client) dial(ctx context.Context) (err error) {
+	c.stateLocker.Lock()
+
+	switch c.state {
+	case StateDisconnected:
+	case StateClosing, StateClosed:
+		c.stateLocker.Unlock()
+		return ErrClientClosed
+	default:
+		c.stateLocker.Unlock()
+		return ErrAlreadyOpen
+	}
+
+	c.stateLocker.Unlock()
+
+	if err := c.connect(ctx); err != nil {
+		c.close(err)
+		return err
+	}
+
+	return nil
+}
+
+// connect dials the server and starts the loops for the new connection once the engine.io session is established.
+func (c *Client) connect(ctx context.Context) error {
+	c.setState(StateDialing, nil)
+	conn, err := engineio.Dial(ctx, c.url, &engineio.Options{
+		Transport: c.opts.Transport,
+		EngineIO:  c.opts.EngineIO,
+		Upgrade:   c.opts.Upgrade,
+		Clock:     c.opts.Clock,
+		Dialed: func() {
+			c.setState(StateConnecting, nil)
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	connCtx, connCancel := context.WithCancel(c.ctx)
+
 	c.connLocker.Lock()
-	c.conn, err = tr.Connect(u.String())
+	c.conn = conn
+	c.connCancel = connCancel
 	c.connLocker.Unlock()
 
-	if err != nil {
-		return nil, err
+	// the client might have been closed while dialing
+	if c.ctx.Err() != nil {
+		conn.Close()
+		return ErrClientClosed
 	}
 
-	go c.inLoop()
-	go c.outLoop()
+	go c.inLoop(connCtx, conn)
+	go c.outLoop(connCtx, conn)
 
-	return c, nil
+	return nil
 }
 
 // Header of engine.io to send and receive packets
-type Header struct {
-	Sid          string   `json:"sid"`
-	Upgrades     []string `json:"upgrades"`
-	PingInterval int      `json:"pingInterval"`
-	PingTimeout  int      `json:"pingTimeout"`
-}
+type Header = engineio.Header
 
 // Client to handle socket.io connections
 type Client struct {
+	// dropped volatile messages, accessed atomically, so it comes first to be 64-bit aligned
+	dropped uint64
+
 	ctx       context.Context
 	ctxCancel context.CancelFunc
 
-	header Header
+	url    url.URL
+	opts   Options
+	parser protocol.Parser
 
-	conn       *websocket.Connection
+	conn       *engineio.Conn
+	connCancel context.CancelFunc
 	connLocker sync.RWMutex
 
+	reconnects int
+
 	namespaces       map[string]*Namespace
 	namespacesLocker sync.RWMutex
 
@@ -136,7 +337,16 @@ type Client struct {
 	handlers       *handlers
 	handlersLocker sync.RWMutex
 
-	out chan *msgWriter
+	queue   *writeQueue
+	writers sync.WaitGroup
+
+	closing       bool
+	closingLocker sync.RWMutex
+	closeOnce     sync.Once
+
+	state       State
+	watchers    stateWatchers
+	stateLocker sync.RWMutex
 }
 
 type handlers struct {
@@ -185,7 +395,7 @@ type location struct {
 	method    string
 }
 
-func (c *Client) getConn() *websocket.Connection {
+func (c *Client) getConn() *engineio.Conn {
 	c.connLocker.RLock()
 	conn := c.conn
 	c.connLocker.RUnlock()
@@ -212,202 +422,543 @@ func (c *Client) init() {
 	c.ack = &ack.Waiter{}
 	c.handlers = &handlers{}
 	c.handlers.Reset()
-	c.out = make(chan *msgWriter)
+	c.queue = newWriteQueue(c.opts.WriteQueueSize, c.opts.WriteQueueFull, c.opts.WriteQueueTimeout, c.opts.Clock)
+
+	// no need to authenticate default namespace
+	// see https://github.com/socketio/socket.io/issues/474
+	// except on socket.io v3 and later, where it is joined once the connection is open
+	def := NewNamespace(c, defaultNamespace)
+	def.state = StateConnecting
+	def.deferred = c.opts.EngineIO >= 4
+	c.namespaces[defaultNamespace] = def
+}
+
+// State of the connection.
+func (c *Client) State() State {
+	c.stateLocker.RLock()
+	state := c.state
+	c.stateLocker.RUnlock()
+	return state
+}
+
+// NotifyState relays the state transitions of the connection and of its namespaces to c.
+// It doesn't block sending to c: the caller must ensure that c has enough buffer space
+// to keep up with the expected rate, or transitions are dropped.
+func (c *Client) NotifyState(ch chan<- Transition) {
+	c.stateLocker.Lock()
+	c.watchers.add(ch)
+	c.stateLocker.Unlock()
+}
+
+// StopNotifyState stops relaying the state transitions to c.
+func (c *Client) StopNotifyState(ch chan<- Transition) {
+	c.stateLocker.Lock()
+	c.watchers.remove(ch)
+	c.stateLocker.Unlock()
+}
+
+func (c *Client) setState(state State, cause error) {
+	c.stateLocker.Lock()
+
+	if c.state == state {
+		c.stateLocker.Unlock()
+		return
+	}
+
+	t := Transition{
+		From:  c.state,
+		To:    state,
+		At:    c.opts.Clock.Now(),
+		Cause: cause,
+	}
+
+	c.state = state
+	c.watchers.notify(t)
+	c.stateLocker.Unlock()
+}
+
+// isClosing tells whether the client is closed or shutting down.
+func (c *Client) isClosing() bool {
+	c.closingLocker.RLock()
+	closing := c.closing
+	c.closingLocker.RUnlock()
+	return closing
+}
+
+// isOpen tells whether the engine.io session is established.
+// Namespaces are joined while holding the namespaces lock, so checking it is synchronized with handleOpen.
+func (c *Client) isOpen() bool {
+	return c.State() == StateConnected
+}
+
+func (c *Client) notifyState(t Transition) {
+	c.stateLocker.RLock()
+	c.watchers.notify(t)
+	c.stateLocker.RUnlock()
+}
+
+// def returns the default namespace.
+func (c *Client) def() *Namespace {
+	n, _ := c.getNamespace(defaultNamespace)
+	return n
 }
 
 // ID of current socket connection
 func (c *Client) ID() string {
-	return c.header.Sid
+	if conn := c.getConn(); conn != nil {
+		return conn.ID()
+	}
+
+	return ""
+}
+
+// Recovered tells whether the session of the default namespace was recovered after reconnecting.
+// See Namespace.Recovered.
+func (c *Client) Recovered() bool {
+	return c.def().Recovered()
 }
 
 // incoming messages loop, puts incoming messages to In channel
-func (c *Client) inLoop() {
+func (c *Client) inLoop(ctx context.Context, conn *engineio.Conn) {
+	c.handleOpen()
+
 	for {
 		select {
-		case <-c.ctx.Done():
+		case <-ctx.Done():
 			return
 		default:
-			// gorilla's websocket (c *Conn) NextReader() is used internally by GetMessage
+			// gorilla's websocket (c *Conn) NextReader() is used internally by ReadMessage
 			// see notes there about breaking out of the loop on error
-			pkg, err := c.getConn().GetMessage()
+			m, err := conn.ReadMessage()
 
-			if err == websocket.ErrUnsupportedBinaryMessage ||
-				err == websocket.ErrBadBuffer ||
-				err == websocket.ErrPacketType {
+			if err == websocket.ErrBadBuffer ||
+				err == websocket.ErrPacketType ||
+				err == sio.ErrUnknownPacketType {
 				c.callLoopEvent(defaultNamespace, protocol.OnError, err)
 				continue
 			}
 
 			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+
+				if err == engineio.ErrServerClose {
+					c.close(ErrServerDisconnect)
+					return
+				}
+
 				c.callLoopEvent(defaultNamespace, protocol.OnError, err)
-				c.ctxCancel()
+
+				// the server sent a frame the transport refuses, so don't reconnect to it
+				if _, ok := err.(websocket.LimitError); ok {
+					c.close(err)
+					return
+				}
+
+				c.lost(err)
 				return
 			}
 
-			msg, err := protocol.Decode(pkg)
+			msg, err := c.parser.Decode(m.Data, m.Binary)
 
 			if err != nil {
 				c.callLoopEvent(defaultNamespace, protocol.OnError, err)
 				continue
 			}
 
-			if msg.Type == protocol.MessageTypeClose {
-				return
-			}
-
 			c.incomingHandler(msg)
 		}
 	}
 }
 
 // outcoming messages loop
-func (c *Client) outLoop() {
-	// socket.io requires a ping strategy to identify that the connection is alive
-	pingInterval, _ := c.getConn().PingParams()
-	var ticker = time.NewTicker(pingInterval)
-	defer ticker.Stop()
-
+func (c *Client) outLoop(ctx context.Context, conn *engineio.Conn) {
 	for {
 		select {
-		case <-c.ctx.Done():
+		case <-ctx.Done():
 			return
-		case mw := <-c.out:
-			writeMsg(mw, c.conn.WriteMessage)
-		case <-ticker.C:
-			if err := c.conn.WriteMessage(protocol.PingMessage); err != nil {
-				c.callLoopEvent(defaultNamespace, OnError, err)
-			}
+		case <-c.queue.ready:
+			c.writeBatch(conn, c.queue.pop())
 		}
 	}
 }
 
-func writeMsg(m *msgWriter, writer func(string) error) {
-	defer m.wg.Done()
-	m.err = writer(m.msg)
+// writeBatch writes the messages, coalescing them into as few network writes as possible.
+func (c *Client) writeBatch(conn *engineio.Conn, batch []*msgWriter) {
+	var messages = make([]engineio.Message, len(batch))
+
+	for i, mw := range batch {
+		messages[i] = mw.frame
+	}
+
+	errs := conn.WriteMessages(messages)
+	c.queue.written(batch)
+
+	for i, mw := range batch {
+		mw.finish(errs[i])
+	}
+}
+
+// writeMessage writes a message on behalf of the user.
+// It is refused once the client starts shutting down.
+func (c *Client) writeMessage(ctx context.Context, frame websocket.Frame) error {
+	return c.submit(ctx, frame, false)
+}
+
+// writeControl is like writeMessage, but for control packets, which skip ahead of the queued messages.
+func (c *Client) writeControl(frame websocket.Frame) error {
+	return c.submit(context.Background(), frame, true)
 }
 
-type msgWriter struct {
-	msg string
-	err error
-	wg  sync.WaitGroup
+func (c *Client) submit(ctx context.Context, frame websocket.Frame, control bool) error {
+	c.closingLocker.RLock()
+
+	if c.closing {
+		c.closingLocker.RUnlock()
+		return ErrClientClosed
+	}
+
+	c.writers.Add(1)
+	c.closingLocker.RUnlock()
+
+	defer c.writers.Done()
+	return c.writeContext(ctx, frame, control)
 }
 
-func (c *Client) writeMessage(msg string) error {
-	mw := &msgWriter{
-		msg: msg,
+// enqueue hands a message to the out loop without waiting for room in the queue, nor for the message to be written.
+// The returned function waits for the message to be written, and must be called once.
+func (c *Client) enqueue(frame websocket.Frame) (wait func() error, err error) {
+	c.closingLocker.RLock()
+
+	if c.closing {
+		c.closingLocker.RUnlock()
+		return nil, ErrClientClosed
 	}
 
-	mw.wg.Add(1)
-	c.out <- mw
-	mw.wg.Wait()
-	return mw.err
+	c.writers.Add(1)
+	c.closingLocker.RUnlock()
+
+	mw := newMsgWriter(frame)
+
+	if err := c.queue.enqueue(mw); err != nil {
+		c.writers.Done()
+		return nil, err
+	}
+
+	return func() error {
+		defer c.writers.Done()
+		<-mw.done
+		return mw.err
+	}, nil
 }
 
-// On registers a handler
-func (c *Client) On(method string, f interface{}) error {
-	def, err := c.Of(defaultNamespace)
+// write a message to the out loop, regardless of the client shutting down.
+func (c *Client) write(frame websocket.Frame, control bool) error {
+	return c.writeContext(context.Background(), frame, control)
+}
 
-	if err != nil {
+// writeContext waits for a message to be written, unless the context is done first.
+// A message that is still queued is then discarded; one that is being written is not.
+func (c *Client) writeContext(ctx context.Context, frame websocket.Frame, control bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mw := newMsgWriter(frame)
+
+	if err := c.queue.push(ctx, mw, control); err != nil {
 		return err
 	}
 
-	return def.On(method, f)
+	select {
+	case <-mw.done:
+		return mw.err
+	case <-ctx.Done():
+		c.queue.remove(mw)
+		return ctx.Err()
+	}
+}
+
+// BufferedAmount is the number of bytes of the messages queued or being written.
+func (c *Client) BufferedAmount() int {
+	return c.queue.bufferedAmount()
+}
+
+// On registers a handler
+func (c *Client) On(method string, f interface{}) error {
+	return c.def().On(method, f)
 }
 
 // Off unregisters a listener
 func (c *Client) Off(method string) {
-	def, _ := c.Of(defaultNamespace)
-
-	if def != nil {
-		def.Off(method)
-	}
+	c.def().Off(method)
 }
 
 // Listeners on the default namespace
 func (c *Client) Listeners() (list []string) {
-	def, _ := c.Of(defaultNamespace)
-
-	if def != nil {
-		list = def.Listeners()
-	}
-
-	return list
+	return c.def().Listeners()
 }
 
 // Emit message
 func (c *Client) Emit(method string, args ...interface{}) error {
-	def, err := c.Of(defaultNamespace)
+	return c.def().Emit(method, args...)
+}
 
-	if err != nil {
-		return err
-	}
+// EmitContext message, see Namespace.EmitContext.
+func (c *Client) EmitContext(ctx context.Context, method string, args ...interface{}) error {
+	return c.def().EmitContext(ctx, method, args...)
+}
 
-	return def.Emit(method, args...)
+// EmitDurable message, see Namespace.EmitDurable.
+func (c *Client) EmitDurable(method string, args ...interface{}) (key string, err error) {
+	return c.def().EmitDurable(method, args...)
 }
 
 // Ack packet based on given data and send it and receive response
 func (c *Client) Ack(ctx context.Context, method string, args interface{}, ret interface{}) error {
-	def, err := c.Of(defaultNamespace)
+	return c.def().Ack(ctx, method, args, ret)
+}
+
+// AckWithOptions on the default namespace, see Namespace.AckWithOptions.
+func (c *Client) AckWithOptions(ctx context.Context, method string, args interface{}, ret interface{}, opts *AckOptions) error {
+	return c.def().AckWithOptions(ctx, method, args, ret, opts)
+}
+
+// Of subscribes to a namespace, such as "/chat".
+// Names that don't start with '/' or have a comma return ErrInvalidNamespace.
+// If the namespace was closed, it is joined again.
+// It doesn't wait for the server to accept the connection; see OfContext.
+func (c *Client) Of(namespace string) (*Namespace, error) {
+	n, _, err := c.of(namespace, nil)
+	return n, err
+}
+
+// Socket of a namespace, see Of.
+func (c *Client) Socket(namespace string) (Socket, error) {
+	n, err := c.Of(namespace)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return def.Ack(ctx, method, args, ret)
+	return n, nil
 }
 
-// Of subscribes to a namespace
-func (c *Client) Of(namespace string) (*Namespace, error) {
-	c.namespacesLocker.RLock()
-	n, ok := c.namespaces[namespace]
-	c.namespacesLocker.RUnlock()
+// OfContext subscribes to a namespace and waits until the server accepts the connection.
+// The options are sent with the namespace connection packet and reused when joining it again, unless nil.
+// If the server refuses the connection, a ConnectError is returned.
+func (c *Client) OfContext(ctx context.Context, namespace string, opts *NamespaceOptions) (*Namespace, error) {
+	n, attempt, err := c.of(namespace, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-attempt.done:
+		if attempt.err != nil {
+			return nil, attempt.err
+		}
 
-	if ok {
 		return n, nil
+	case <-c.ctx.Done():
+		return nil, ErrClientClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
+}
 
-	if err := c.maybeOf(namespace); err != nil {
-		return nil, err
+func (c *Client) of(namespace string, opts *NamespaceOptions) (*Namespace, *joinAttempt, error) {
+	// the connect packet would be rejected when written, which only reaches OnError if the join is deferred
+	if namespace != defaultNamespace && (namespace[0] != '/' || strings.Contains(namespace, ",")) {
+		return nil, nil, ErrInvalidNamespace
 	}
 
-	n = NewNamespace(c, namespace)
+	c.namespacesLocker.RLock()
+
+	if n, ok := c.namespaces[namespace]; ok {
+		attempt, err := n.join(opts)
+		c.namespacesLocker.RUnlock()
+		return n, attempt, err
+	}
+
+	c.namespacesLocker.RUnlock()
+
 	c.namespacesLocker.Lock()
+	defer c.namespacesLocker.Unlock()
+
+	n, ok := c.namespaces[namespace]
+
+	if !ok {
+		n = NewNamespace(c, namespace)
+	}
+
+	attempt, err := n.join(opts)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
 	c.namespaces[namespace] = n
-	c.namespacesLocker.Unlock()
+	return n, attempt, nil
+}
 
-	return n, nil
+func (c *Client) getNamespace(namespace string) (*Namespace, bool) {
+	c.namespacesLocker.RLock()
+	n, ok := c.namespaces[namespace]
+	c.namespacesLocker.RUnlock()
+	return n, ok
 }
 
-func (c *Client) maybeOf(namespace string) error {
-	// no need to authenticate default namespace
-	// see https://github.com/socketio/socket.io/issues/474
-	if namespace == "" {
-		return nil
+// Close client connection immediately.
+// Queued messages are discarded and pending acks fail with ErrClientClosed.
+// Use Shutdown to close the connection gracefully.
+func (c *Client) Close() {
+	c.close(nil)
+}
+
+// close the connection, informing the cause to the state watchers.
+func (c *Client) close(cause error) {
+	c.closeOnce.Do(func() {
+		c.closingLocker.Lock()
+		c.closing = true
+		c.closingLocker.Unlock()
+
+		c.ctxCancel()
+
+		if conn := c.getConn(); conn != nil {
+			conn.Close()
+		}
+
+		c.getAck().Close()
+		c.queue.close(ErrClientClosed)
+
+		if cause == nil {
+			cause = ErrClientClosed
+		}
+
+		c.namespacesLocker.RLock()
+		var left []string
+
+		for name, n := range c.namespaces {
+			if n.leave(cause) && name != defaultNamespace {
+				left = append(left, name)
+			}
+		}
+
+		c.namespacesLocker.RUnlock()
+
+		c.setState(StateClosed, cause)
+
+		for _, name := range left {
+			c.callLoopEvent(name, protocol.OnDisconnect)
+		}
+
+		c.callLoopEvent(defaultNamespace, protocol.OnDisconnect)
+	})
+}
+
+// Shutdown gracefully closes the client connection.
+// It stops accepting new messages, waits for queued messages to be written,
+// sends the disconnect packets for each namespace and the close packet, and then closes the connection.
+// Pending acks fail with ErrClientClosed.
+// If the context expires first, the connection is closed immediately and the context error is returned.
+func (c *Client) Shutdown(ctx context.Context) error {
+	return c.shutdown(ctx, false)
+}
+
+// ShutdownAfterAcks is like Shutdown, but also waits for the pending acks to be received
+// before closing the connection.
+func (c *Client) ShutdownAfterAcks(ctx context.Context) error {
+	return c.shutdown(ctx, true)
+}
+
+func (c *Client) shutdown(ctx context.Context, waitAcks bool) (err error) {
+	c.closingLocker.Lock()
+
+	if c.closing {
+		c.closingLocker.Unlock()
+		return ErrClientClosed
 	}
 
-	msg := &protocol.Message{
-		Type:   protocol.MessageTypeNamespace,
-		Method: namespace,
+	c.closing = true
+	c.closingLocker.Unlock()
+
+	// there is no one to say goodbye to while the connection is down
+	connected := c.State() == StateConnected
+	c.setState(StateClosing, nil)
+	defer c.Close()
+
+	if waitAcks {
+		select {
+		case <-c.getAck().Drained():
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 
-	command, err := protocol.Encode(msg)
+	flushed := make(chan struct{})
 
-	if err != nil {
-		return err
+	go func() {
+		c.writers.Wait()
+		close(flushed)
+	}()
+
+	select {
+	case <-flushed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if !connected {
+		return nil
 	}
 
-	return c.writeMessage(command)
+	return c.writeClosePackets()
 }
 
-// Close client connection
-func (c *Client) Close() {
-	if len(c.ctx.Done()) != 0 {
-		return
+func (c *Client) writeClosePackets() error {
+	c.namespacesLocker.RLock()
+	var namespaces []string
+
+	for name, n := range c.namespaces {
+		if name != defaultNamespace && n.isJoined() {
+			namespaces = append(namespaces, name)
+		}
 	}
 
-	c.getConn().Close()
-	c.ctxCancel()
-	c.callLoopEvent(defaultNamespace, protocol.OnDisconnect)
+	c.namespacesLocker.RUnlock()
+
+	// the default namespace is disconnected last
+	namespaces = append(namespaces, defaultNamespace)
+
+	for _, name := range namespaces {
+		frame, err := c.encode(&protocol.Message{
+			Type:      protocol.MessageTypeDisconnect,
+			Namespace: name,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if err := c.write(frame, true); err != nil {
+			return err
+		}
+	}
+
+	return c.getConn().WriteClose()
+}
+
+// encode a message with the parser.
+func (c *Client) encode(msg *protocol.Message, args ...interface{}) (websocket.Frame, error) {
+	data, binary, err := c.parser.Encode(msg, args...)
+
+	return websocket.Frame{
+		Data:   data,
+		Binary: binary,
+	}, err
 }
 
 // Find message processing function associated with given method
@@ -443,24 +994,8 @@ func (c *Client) callLoopEvent(namespace string, event string, args ...interface
 
 func (c *Client) incomingHandler(msg *protocol.Message) {
 	switch msg.Type {
-	case protocol.MessageTypeOpen:
-		if err := jsonUnmarshalUnpanic([]byte(msg.Source[1:]), &c.header); err != nil {
-			c.callLoopEvent(defaultNamespace, OnError, err)
-			return
-		}
-
-		def, _ := c.Of(defaultNamespace)
-		def.setReady()
-
-		c.callLoopEvent(msg.Namespace, protocol.OnConnection)
-	case protocol.MessageTypePing:
-		if err := c.writeMessage(protocol.PongMessage); err != nil {
-			c.callLoopEvent(defaultNamespace, OnError, err)
-		}
-	case protocol.MessageTypePong:
 	case protocol.MessageTypeError:
-		err := fmt.Errorf("error on method %s on namespace %s", msg.Method, msg.Namespace)
-		c.callLoopEvent(msg.Namespace, protocol.OnError, err)
+		c.handleIncomingConnectError(msg)
 	case protocol.MessageTypeEmit:
 		c.handleIncomingEmit(msg)
 	case protocol.MessageTypeAckRequest:
@@ -468,25 +1003,67 @@ func (c *Client) incomingHandler(msg *protocol.Message) {
 	case protocol.MessageTypeAckResponse:
 		c.handleIncomingAckResponse(msg)
 	case protocol.MessageTypeEmpty:
-		if msg.Namespace != defaultNamespace {
-			def, _ := c.Of(msg.Namespace)
-			def.setReady()
+		// socket.io v2 servers acknowledge the default namespace with the open packet instead
+		if msg.Namespace == defaultNamespace && c.opts.EngineIO < 4 {
+			return
+		}
+
+		if n, ok := c.getNamespace(msg.Namespace); ok {
+			n.setSession(msg.Data)
+			n.setReady()
 			c.handleIncomingNamespaceConnection(msg)
 		}
+	case protocol.MessageTypeDisconnect:
+		c.handleIncomingNamespaceDisconnect(msg)
 	default:
 		err := fmt.Errorf("message type %s is not implemented", msg.Type)
 		c.callLoopEvent(msg.Namespace, OnError, err)
 	}
 }
 
+func (c *Client) handleOpen() {
+	c.namespacesLocker.Lock()
+	c.setState(StateConnected, nil)
+	c.resetReconnects()
+
+	var namespaces []*Namespace
+
+	for _, n := range c.namespaces {
+		namespaces = append(namespaces, n)
+	}
+
+	c.namespacesLocker.Unlock()
+
+	if c.opts.EngineIO < 4 {
+		c.def().setReady()
+	}
+
+	for _, n := range namespaces {
+		if err := n.flushJoin(); err != nil {
+			c.callLoopEvent(n.name, OnError, err)
+		}
+	}
+
+	// the messages held since the connection was lost are written after the connection packets
+	c.queue.resume()
+
+	if c.opts.EngineIO < 4 {
+		c.callLoopEvent(defaultNamespace, protocol.OnConnection)
+	}
+}
+
 func (c *Client) handleIncomingEmit(msg *protocol.Message) {
+	if n, ok := c.getNamespace(msg.Namespace); ok {
+		n.trackOffset(msg.Data)
+	}
+
 	h, ok := c.getHandler(msg.Namespace, msg.Method)
 
 	if !ok {
 		return
 	}
 
-	var args, err = h.getFunctionCallArgs(msg)
+	var args, err = h.getFunctionCallArgs(msg, c.parser.Codec())
 
 	if err != nil {
 		c.callLoopEvent(msg.Namespace, OnError, err)
@@ -497,13 +1074,21 @@ func (c *Client) handleIncomingEmit(msg *protocol.Message) {
 }
 
 func (c *Client) handleIncomingAckRequest(msg *protocol.Message) {
+	n, ok := c.getNamespace(msg.Namespace)
+
+	if !ok {
+		return
+	}
+
+	n.trackOffset(msg.Data)
+
 	h, ok := c.getHandler(msg.Namespace, msg.Method)
 
-	if !ok || !h.Out {
+	if !ok {
 		return
 	}
 
-	var args, err = h.getFunctionCallArgs(msg)
+	var args, err = h.getFunctionCallArgs(msg, c.parser.Codec())
 
 	if err != nil {
 		c.callLoopEvent(msg.Namespace, OnError, err)
@@ -517,21 +1102,20 @@ func (c *Client) handleIncomingAckRequest(msg *protocol.Message) {
 		AckID: msg.AckID,
 	}
 
-	def, _ := c.Of(defaultNamespace)
-
-	var ri = []interface{}{}
+	ri := values(result)
 
-	for _, r := range result {
-		ri = append(ri, r.Interface())
-	}
-
-	if err = def.send(ack, ri...); err != nil {
+	// the ack response goes to the namespace of the request
+	if err = n.sendNow(ack, ri...); err != nil {
 		c.callLoopEvent(msg.Namespace, OnError, err)
 		return
 	}
 }
 
 func (c *Client) handleIncomingAckResponse(msg *protocol.Message) {
+	if n, ok := c.getNamespace(msg.Namespace); ok && n.acknowledge(msg.AckID) {
+		return
+	}
+
 	ack := c.getAck()
 
 	if waiter, ok := ack.Load(msg.AckID); ok {
@@ -541,6 +1125,28 @@ func (c *Client) handleIncomingAckResponse(msg *protocol.Message) {
 	// couldn't find incoming ack
 }
 
+func (c *Client) handleIncomingConnectError(msg *protocol.Message) {
+	err := newConnectError(msg.Namespace, msg.Data, c.parser.Codec())
+
+	if n, ok := c.getNamespace(msg.Namespace); ok {
+		n.refuse(err)
+	}
+
+	c.callLoopEvent(msg.Namespace, protocol.OnError, err)
+}
+
+func (c *Client) handleIncomingNamespaceDisconnect(msg *protocol.Message) {
+	// the server disconnecting the default namespace terminates the session
+	if msg.Namespace == defaultNamespace {
+		c.close(ErrServerDisconnect)
+		return
+	}
+
+	if n, ok := c.getNamespace(msg.Namespace); ok && n.leave(ErrServerDisconnect) {
+		c.callLoopEvent(msg.Namespace, protocol.OnDisconnect)
+	}
+}
+
 func (c *Client) handleIncomingNamespaceConnection(msg *protocol.Message) {
 	if h, ok := c.getHandler(msg.Namespace, msg.Method); ok {
 		_ = h.Call(nil)
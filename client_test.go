@@ -0,0 +1,1108 @@
+package gosocketio
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/wedeploy/gosocketio/codec"
+	"github.com/wedeploy/gosocketio/engineio"
+	sio "github.com/wedeploy/gosocketio/protocol"
+	"github.com/wedeploy/gosocketio/transport"
+	"github.com/wedeploy/gosocketio/transport/fault"
+	"github.com/wedeploy/gosocketio/websocket"
+)
+
+type mockServer struct {
+	*httptest.Server
+
+	received chan string
+	conns    chan *ws.Conn
+}
+
+// newMockServer sends the handshake and the given packets on each new connection.
+func newMockServer(t *testing.T, packets ...string) *mockServer {
+	m := &mockServer{
+		received: make(chan string, 100),
+		conns:    make(chan *ws.Conn, 1),
+	}
+
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var upgrader ws.Upgrader
+		conn, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			t.Errorf("Expected no error upgrading connection, got %v instead", err)
+			return
+		}
+
+		_ = conn.WriteMessage(ws.TextMessage, []byte(`0{"sid":"abc","upgrades":[],"pingInterval":25000,"pingTimeout":60000}`))
+		_ = conn.WriteMessage(ws.TextMessage, []byte(`40`))
+
+		for _, p := range packets {
+			_ = conn.WriteMessage(ws.TextMessage, []byte(p))
+		}
+
+		m.conns <- conn
+
+		for {
+			_, data, err := conn.ReadMessage()
+
+			if err != nil {
+				close(m.received)
+				return
+			}
+
+			m.received <- string(data)
+		}
+	}))
+
+	return m
+}
+
+func (m *mockServer) URL() url.URL {
+	u, _ := url.Parse(m.Server.URL)
+	u.Scheme = "ws"
+	return *u
+}
+
+func (m *mockServer) expect(t *testing.T, want string) {
+	t.Helper()
+
+	select {
+	case got := <-m.received:
+		if got != want {
+			t.Errorf("Expected server to receive %v, got %v instead", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected server to receive %v, got nothing instead", want)
+	}
+}
+
+func connectMock(t *testing.T) (*Client, *mockServer) {
+	m := newMockServer(t)
+	c, err := Connect(m.URL(), websocket.NewTransport())
+
+	if err != nil {
+		m.Close()
+		t.Fatalf("Expected no error connecting, got %v instead", err)
+	}
+
+	return c, m
+}
+
+func TestClientShutdown(t *testing.T) {
+	c, m := connectMock(t)
+	defer m.Close()
+
+	ns, err := c.Of("/shell")
+
+	if err != nil {
+		t.Fatalf("Expected no error joining namespace, got %v instead", err)
+	}
+
+	m.expect(t, "40/shell")
+
+	if err := ns.Emit("stdin", "ls"); err != nil {
+		t.Errorf("Expected no error emitting, got %v instead", err)
+	}
+
+	m.expect(t, `42/shell,["stdin","ls"]`)
+
+	ackErr := make(chan error, 1)
+
+	go func() {
+		var v string
+		ackErr <- c.Ack(context.Background(), "book", "JFK", &v)
+	}()
+
+	m.expect(t, `421["book","JFK"]`)
+
+	if err := c.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected no error shutting down, got %v instead", err)
+	}
+
+	m.expect(t, "41/shell")
+	m.expect(t, "41")
+	m.expect(t, "1")
+
+	select {
+	case err := <-ackErr:
+		if err != ErrClientClosed {
+			t.Errorf("Expected pending ack to fail with %v, got %v instead", ErrClientClosed, err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected pending ack to fail after shutdown")
+	}
+
+	if err := c.Emit("stdin", "ls"); err != ErrClientClosed {
+		t.Errorf("Expected emit after shutdown to fail with %v, got %v instead", ErrClientClosed, err)
+	}
+
+	if err := c.Shutdown(context.Background()); err != ErrClientClosed {
+		t.Errorf("Expected second shutdown to fail with %v, got %v instead", ErrClientClosed, err)
+	}
+}
+
+func TestClientShutdownAfterAcks(t *testing.T) {
+	c, m := connectMock(t)
+	defer m.Close()
+
+	conn := <-m.conns
+	ackErr := make(chan error, 1)
+	var v string
+
+	go func() {
+		ackErr <- c.Ack(context.Background(), "book", "JFK", &v)
+	}()
+
+	m.expect(t, `421["book","JFK"]`)
+
+	shutdown := make(chan error, 1)
+
+	go func() {
+		shutdown <- c.ShutdownAfterAcks(context.Background())
+	}()
+
+	select {
+	case <-shutdown:
+		t.Fatal("Expected shutdown to wait for the pending ack")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_ = conn.WriteMessage(ws.TextMessage, []byte(`431["Hilton"]`))
+
+	if err := <-ackErr; err != nil {
+		t.Errorf("Expected no error receiving ack, got %v instead", err)
+	}
+
+	if v != "Hilton" {
+		t.Errorf("Expected ack value to be Hilton, got %v instead", v)
+	}
+
+	if err := <-shutdown; err != nil {
+		t.Errorf("Expected no error shutting down, got %v instead", err)
+	}
+
+	m.expect(t, "41")
+	m.expect(t, "1")
+}
+
+func TestNamespaceClose(t *testing.T) {
+	c, m := connectMock(t)
+	defer m.Close()
+	defer c.Close()
+
+	conn := <-m.conns
+	ns, err := c.Of("/shell")
+
+	if err != nil {
+		t.Fatalf("Expected no error joining namespace, got %v instead", err)
+	}
+
+	m.expect(t, "40/shell")
+	_ = conn.WriteMessage(ws.TextMessage, []byte(`40/shell`))
+	<-ns.Ready()
+
+	disconnected := make(chan struct{}, 1)
+
+	if err := ns.On(OnDisconnect, func() {
+		disconnected <- struct{}{}
+	}); err != nil {
+		t.Fatalf("Expected no error registering listener, got %v instead", err)
+	}
+
+	if err := ns.Close(); err != nil {
+		t.Errorf("Expected no error closing namespace, got %v instead", err)
+	}
+
+	m.expect(t, "41/shell")
+
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Error("Expected disconnect listener to be called")
+	}
+
+	if _, err := c.Of("/shell"); err != nil {
+		t.Errorf("Expected no error joining namespace again, got %v instead", err)
+	}
+
+	m.expect(t, "40/shell")
+	_ = conn.WriteMessage(ws.TextMessage, []byte(`40/shell`))
+
+	select {
+	case <-ns.Ready():
+	case <-time.After(time.Second):
+		t.Error("Expected namespace to be ready again")
+	}
+}
+
+func TestNamespaceServerDisconnect(t *testing.T) {
+	c, m := connectMock(t)
+	defer m.Close()
+	defer c.Close()
+
+	conn := <-m.conns
+	ns, err := c.Of("/shell")
+
+	if err != nil {
+		t.Fatalf("Expected no error joining namespace, got %v instead", err)
+	}
+
+	m.expect(t, "40/shell")
+
+	disconnected := make(chan struct{}, 1)
+
+	if err := ns.On(OnDisconnect, func() {
+		disconnected <- struct{}{}
+	}); err != nil {
+		t.Fatalf("Expected no error registering listener, got %v instead", err)
+	}
+
+	clientDisconnected := make(chan struct{}, 1)
+
+	if err := c.On(OnDisconnect, func() {
+		clientDisconnected <- struct{}{}
+	}); err != nil {
+		t.Fatalf("Expected no error registering listener, got %v instead", err)
+	}
+
+	_ = conn.WriteMessage(ws.TextMessage, []byte(`41/shell`))
+
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Error("Expected namespace disconnect listener to be called")
+	}
+
+	select {
+	case <-clientDisconnected:
+		t.Error("Expected client to remain connected")
+	default:
+	}
+
+	if err := c.Emit("ping", 1); err != nil {
+		t.Errorf("Expected no error emitting on the default namespace, got %v instead", err)
+	}
+
+	m.expect(t, `42["ping",1]`)
+}
+
+func TestOfContext(t *testing.T) {
+	c, m := connectMock(t)
+	defer m.Close()
+	defer c.Close()
+
+	conn := <-m.conns
+	joined := make(chan error, 1)
+
+	go func() {
+		_, err := c.OfContext(context.Background(), "/admin", &NamespaceOptions{
+			Query: url.Values{"token": []string{"abc"}},
+		})
+
+		joined <- err
+	}()
+
+	m.expect(t, "40/admin?token=abc")
+
+	select {
+	case <-joined:
+		t.Fatal("Expected OfContext to wait for the server to accept the connection")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_ = conn.WriteMessage(ws.TextMessage, []byte(`40/admin`))
+
+	if err := <-joined; err != nil {
+		t.Errorf("Expected no error joining namespace, got %v instead", err)
+	}
+}
+
+func TestOfContextRefused(t *testing.T) {
+	c, m := connectMock(t)
+	defer m.Close()
+	defer c.Close()
+
+	conn := <-m.conns
+	joined := make(chan error, 1)
+
+	go func() {
+		_, err := c.OfContext(context.Background(), "/admin", &NamespaceOptions{
+			Auth: map[string]string{"token": "abc"},
+		})
+
+		joined <- err
+	}()
+
+	m.expect(t, `40/admin,{"token":"abc"}`)
+	_ = conn.WriteMessage(ws.TextMessage, []byte(`44/admin,{"message":"Not authorized","data":{"retry":false}}`))
+
+	err := <-joined
+	ce, ok := err.(ConnectError)
+
+	if !ok {
+		t.Fatalf("Expected error to be a ConnectError, got %v instead", err)
+	}
+
+	if ce.Namespace() != "/admin" {
+		t.Errorf("Expected namespace to be /admin, got %v instead", ce.Namespace())
+	}
+
+	if ce.Message() != "Not authorized" {
+		t.Errorf("Expected message to be Not authorized, got %v instead", ce.Message())
+	}
+
+	if string(ce.Data()) != `{"retry":false}` {
+		t.Errorf(`Expected data to be {"retry":false}, got %s instead`, ce.Data())
+	}
+
+	// joining again is possible after a refusal
+	go func() {
+		_, err := c.OfContext(context.Background(), "/admin", nil)
+		joined <- err
+	}()
+
+	m.expect(t, `40/admin,{"token":"abc"}`)
+	_ = conn.WriteMessage(ws.TextMessage, []byte(`40/admin`))
+
+	if err := <-joined; err != nil {
+		t.Errorf("Expected no error joining namespace, got %v instead", err)
+	}
+}
+
+func TestOfInvalidNamespace(t *testing.T) {
+	c := New(url.URL{Scheme: "ws", Host: "example.com"}, nil)
+	defer c.Close()
+
+	for _, name := range []string{"example", "/a,b"} {
+		if _, err := c.Of(name); err != ErrInvalidNamespace {
+			t.Errorf("Expected error to be %v for %q, got %v instead", ErrInvalidNamespace, name, err)
+		}
+
+		// returned right away, even before the client is open
+		if _, err := c.OfContext(context.Background(), name, nil); err != ErrInvalidNamespace {
+			t.Errorf("Expected error to be %v for %q, got %v instead", ErrInvalidNamespace, name, err)
+		}
+	}
+}
+
+func TestOfContextTimeout(t *testing.T) {
+	c, m := connectMock(t)
+	defer m.Close()
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.OfContext(ctx, "/admin", nil); err != context.DeadlineExceeded {
+		t.Errorf("Expected error to be %v, got %v instead", context.DeadlineExceeded, err)
+	}
+}
+
+func TestConnectErrorPayloads(t *testing.T) {
+	var cases = []struct {
+		payload string
+		message string
+		data    string
+	}{
+		{``, "", ""},
+		{`"Invalid namespace"`, "Invalid namespace", ""},
+		{`{"message":"Not authorized"}`, "Not authorized", ""},
+		{`{"message":"Not authorized","data":[1]}`, "Not authorized", "[1]"},
+		{`oops`, "oops", ""},
+	}
+
+	for _, c := range cases {
+		e := newConnectError("/admin", []byte(c.payload), codec.JSON)
+
+		if e.Message() != c.message {
+			t.Errorf("Expected message to be %v, got %v instead", c.message, e.Message())
+		}
+
+		if string(e.Data()) != c.data {
+			t.Errorf("Expected data to be %v, got %s instead", c.data, e.Data())
+		}
+	}
+}
+
+func TestNamespaceReadyBroadcast(t *testing.T) {
+	c, m := connectMock(t)
+	defer m.Close()
+	defer c.Close()
+
+	conn := <-m.conns
+	ns, err := c.Of("/shell")
+
+	if err != nil {
+		t.Fatalf("Expected no error joining namespace, got %v instead", err)
+	}
+
+	m.expect(t, "40/shell")
+
+	states := make(chan Transition, 10)
+	ns.NotifyState(states)
+
+	if state := ns.State(); state != StateConnecting {
+		t.Errorf("Expected state to be %v, got %v instead", StateConnecting, state)
+	}
+
+	var waiters = make(chan struct{}, 3)
+
+	for i := 0; i < cap(waiters); i++ {
+		go func() {
+			<-ns.Ready()
+			waiters <- struct{}{}
+		}()
+	}
+
+	// a repeated connection packet must not block the client
+	_ = conn.WriteMessage(ws.TextMessage, []byte(`40/shell`))
+	_ = conn.WriteMessage(ws.TextMessage, []byte(`40/shell`))
+
+	for i := 0; i < cap(waiters); i++ {
+		select {
+		case <-waiters:
+		case <-time.After(time.Second):
+			t.Fatal("Expected every waiter to see the ready signal")
+		}
+	}
+
+	_ = conn.WriteMessage(ws.TextMessage, []byte(`41/shell`))
+
+	if want := []State{StateConnected, StateDisconnected}; !receiveStates(states, want) {
+		t.Errorf("Expected state changes to be %v", want)
+	}
+
+	select {
+	case <-ns.Ready():
+		t.Error("Expected ready signal to reset after disconnecting")
+	default:
+	}
+
+	ns.StopNotifyState(states)
+
+	if _, err := c.Of("/shell"); err != nil {
+		t.Errorf("Expected no error joining namespace again, got %v instead", err)
+	}
+
+	select {
+	case s := <-states:
+		t.Errorf("Expected no state changes after stopping notifications, got %v instead", s)
+	default:
+	}
+}
+
+func receiveStates(states chan Transition, want []State) bool {
+	for _, w := range want {
+		select {
+		case s := <-states:
+			if s.To != w {
+				return false
+			}
+		case <-time.After(time.Second):
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestClientState(t *testing.T) {
+	m := newMockServer(t)
+	defer m.Close()
+
+	c := New(m.URL(), nil)
+	transitions := make(chan Transition, 10)
+	c.NotifyState(transitions)
+
+	if state := c.State(); state != StateDisconnected {
+		t.Errorf("Expected state to be %v, got %v instead", StateDisconnected, state)
+	}
+
+	if err := c.Open(context.Background()); err != nil {
+		t.Fatalf("Expected no error opening client, got %v instead", err)
+	}
+
+	if state := c.State(); state != StateConnected {
+		t.Errorf("Expected state to be %v, got %v instead", StateConnected, state)
+	}
+
+	// the connection is connecting once dialed, while waiting for the engine.io handshake
+	var connection []State
+
+	for len(connection) < 3 {
+		if got := <-transitions; got.Namespace == nil {
+			connection = append(connection, got.To)
+		}
+	}
+
+	if want := []State{StateDialing, StateConnecting, StateConnected}; !reflect.DeepEqual(connection, want) {
+		t.Errorf("Expected connection to go through %v, got %v instead", want, connection)
+	}
+
+	// the default namespace is connected once the server accepts it, before Open returns
+	select {
+	case got := <-transitions:
+		if got.Namespace != c.def() || got.From != StateConnecting || got.To != StateConnected {
+			t.Errorf("Expected default namespace to be connected, got %+v instead", got)
+		}
+	default:
+		t.Error("Expected default namespace to be connected")
+	}
+
+	ns, err := c.Of("/shell")
+
+	if err != nil {
+		t.Fatalf("Expected no error joining namespace, got %v instead", err)
+	}
+
+	m.expect(t, "40/shell")
+
+	if err := c.Shutdown(context.Background()); err != nil {
+		t.Errorf("Expected no error shutting down, got %v instead", err)
+	}
+
+	var want = []struct {
+		namespace *Namespace
+		from      State
+		to        State
+	}{
+		{ns, StateDisconnected, StateConnecting},
+		{nil, StateConnected, StateClosing},
+	}
+
+	for _, w := range want {
+		got := <-transitions
+
+		if got.Namespace != w.namespace || got.From != w.from || got.To != w.to {
+			t.Errorf("Expected transition from %v to %v, got %+v instead", w.from, w.to, got)
+		}
+
+		if got.At.IsZero() {
+			t.Error("Expected transition time to be set")
+		}
+	}
+
+	// namespaces are disconnected in no particular order before the connection is closed
+	var closed bool
+
+	for i := 0; i < 3; i++ {
+		got := <-transitions
+
+		if got.Cause != ErrClientClosed {
+			t.Errorf("Expected cause to be %v, got %v instead", ErrClientClosed, got.Cause)
+		}
+
+		if got.Namespace == nil && got.To == StateClosed {
+			closed = true
+		}
+	}
+
+	if !closed {
+		t.Error("Expected connection to be closed")
+	}
+
+	if state := c.State(); state != StateClosed {
+		t.Errorf("Expected state to be %v, got %v instead", StateClosed, state)
+	}
+}
+
+func TestClientServerClose(t *testing.T) {
+	c, m := connectMock(t)
+	defer m.Close()
+
+	conn := <-m.conns
+	transitions := make(chan Transition, 10)
+	c.NotifyState(transitions)
+
+	_ = conn.WriteMessage(ws.TextMessage, []byte(`1`))
+
+	for {
+		select {
+		case got := <-transitions:
+			if got.Namespace != nil {
+				continue
+			}
+
+			if got.To != StateClosed || got.Cause != ErrServerDisconnect {
+				t.Errorf("Expected connection to be closed by the server, got %+v instead", got)
+			}
+
+			return
+		case <-time.After(time.Second):
+			t.Fatal("Expected connection to be closed")
+		}
+	}
+}
+
+func TestClientOpen(t *testing.T) {
+	m := newMockServer(t, `42["welcome","hi"]`)
+	defer m.Close()
+
+	c := New(m.URL(), nil)
+	defer c.Close()
+
+	if state := c.State(); state != StateDisconnected {
+		t.Errorf("Expected state to be %v, got %v instead", StateDisconnected, state)
+	}
+
+	welcome := make(chan string, 1)
+
+	if err := c.On("welcome", func(s string) {
+		welcome <- s
+	}); err != nil {
+		t.Fatalf("Expected no error registering listener, got %v instead", err)
+	}
+
+	ns, err := c.Of("/shell")
+
+	if err != nil {
+		t.Fatalf("Expected no error declaring namespace, got %v instead", err)
+	}
+
+	if state := ns.State(); state != StateConnecting {
+		t.Errorf("Expected namespace state to be %v, got %v instead", StateConnecting, state)
+	}
+
+	if err := c.Open(context.Background()); err != nil {
+		t.Fatalf("Expected no error opening connection, got %v instead", err)
+	}
+
+	select {
+	case got := <-welcome:
+		if got != "hi" {
+			t.Errorf("Expected welcome message to be hi, got %v instead", got)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected welcome message to be received")
+	}
+
+	m.expect(t, "40/shell")
+
+	if err := c.Open(context.Background()); err != ErrAlreadyOpen {
+		t.Errorf("Expected error to be %v, got %v instead", ErrAlreadyOpen, err)
+	}
+}
+
+// openPipe opens the client over the in-memory pipe, answering the handshake like a socket.io v4 server would.
+func openPipe(t *testing.T, p *transport.Pipe, opts *Options, handshake string) (*Client, *transport.PipeConn) {
+	t.Helper()
+
+	opts.EngineIO = 4
+	c := New(url.URL{Scheme: "ws", Host: "example.com"}, opts)
+	opened := make(chan error, 1)
+
+	go func() {
+		opened <- c.Open(context.Background())
+	}()
+
+	peer, err := p.Accept(context.Background())
+
+	if err != nil {
+		t.Fatalf("Expected no error accepting connection, got %v instead", err)
+	}
+
+	_ = peer.WriteFrame(transport.Frame{Data: []byte(handshake)})
+	expectFrame(t, peer, "40")
+	_ = peer.WriteFrame(transport.Frame{Data: []byte(`40{"sid":"def"}`)})
+
+	if err := <-opened; err != nil {
+		t.Fatalf("Expected no error opening connection, got %v instead", err)
+	}
+
+	return c, peer
+}
+
+func expectFrame(t *testing.T, peer *transport.PipeConn, want string) {
+	t.Helper()
+
+	if f, err := peer.ReadFrame(); err != nil || string(f.Data) != want {
+		t.Errorf("Expected peer to receive %v, got %q (%v) instead", want, f.Data, err)
+	}
+}
+
+func TestClientPipe(t *testing.T) {
+	p := transport.NewPipe()
+	defer p.Close()
+
+	c, peer := openPipe(t, p, &Options{Transport: p}, `0{"sid":"abc","upgrades":[],"pingInterval":25000,"pingTimeout":20000}`)
+	defer c.Close()
+
+	if want := "ws://example.com/socket.io/?EIO=4&transport=websocket"; peer.URL() != want {
+		t.Errorf("Expected URL to be %v, got %v instead", want, peer.URL())
+	}
+
+	welcome := make(chan string, 1)
+
+	_ = c.On("welcome", func(s string) {
+		welcome <- s
+	})
+
+	_ = peer.WriteFrame(transport.Frame{Data: []byte(`42["welcome","hi"]`)})
+
+	if got := <-welcome; got != "hi" {
+		t.Errorf("Expected welcome message to be hi, got %v instead", got)
+	}
+
+	if c.ID() != "abc" {
+		t.Errorf("Expected ID to be abc, got %v instead", c.ID())
+	}
+
+	if err := c.Emit("reply", "thanks"); err != nil {
+		t.Errorf("Expected no error emitting, got %v instead", err)
+	}
+
+	expectFrame(t, peer, `42["reply","thanks"]`)
+
+	// pings are answered by the engine.io session
+	_ = peer.WriteFrame(transport.Frame{Data: []byte("2")})
+	expectFrame(t, peer, "3")
+}
+
+func TestClientPingTimeout(t *testing.T) {
+	p := transport.NewPipe()
+	defer p.Close()
+
+	ft := fault.New(p)
+	c, peer := openPipe(t, p, &Options{Transport: ft}, `0{"sid":"abc","upgrades":[],"pingInterval":30,"pingTimeout":30}`)
+	defer c.Close()
+
+	transitions := make(chan Transition, 10)
+	c.NotifyState(transitions)
+
+	// the server keeps pinging, but the pings are lost on the way after the first one
+	ft.Incoming(fault.Engine(sio.EnginePing)).After(1).Times(-1).Drop()
+
+	for i := 0; i < 5; i++ {
+		_ = peer.WriteFrame(transport.Frame{Data: []byte("2")})
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	expectFrame(t, peer, "3")
+
+	for {
+		select {
+		case got := <-transitions:
+			if got.Namespace != nil || got.To != StateClosed {
+				continue
+			}
+
+			if got.Cause != engineio.ErrPingTimeout {
+				t.Errorf("Expected cause to be %v, got %v instead", engineio.ErrPingTimeout, got.Cause)
+			}
+
+			return
+		case <-time.After(time.Second):
+			t.Fatal("Expected connection to be closed")
+		}
+	}
+}
+
+func TestClientOpenRefused(t *testing.T) {
+	m := newMockServer(t)
+	defer m.Close()
+
+	u := m.URL()
+	u.Host = "localhost:1"
+	c := New(u, nil)
+
+	if err := c.Open(context.Background()); err == nil {
+		t.Error("Expected error opening connection")
+	}
+
+	if state := c.State(); state != StateClosed {
+		t.Errorf("Expected state to be %v, got %v instead", StateClosed, state)
+	}
+
+	if err := c.Open(context.Background()); err != ErrClientClosed {
+		t.Errorf("Expected error to be %v, got %v instead", ErrClientClosed, err)
+	}
+}
+
+func TestEmitContext(t *testing.T) {
+	c := New(url.URL{}, &Options{
+		WriteQueueSize: 1,
+	})
+
+	// there is no out loop, so messages stay in the queue
+	c.setState(StateConnected, nil)
+	c.def().setReady()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := c.EmitContext(ctx, "progress", 1); err != context.DeadlineExceeded {
+		t.Errorf("Expected error to be %v, got %v instead", context.DeadlineExceeded, err)
+	}
+
+	if b := c.BufferedAmount(); b != 0 {
+		t.Errorf("Expected message to be discarded from the queue, got %v buffered bytes instead", b)
+	}
+
+	if err := c.EmitContext(ctx, "progress", 2); err != context.DeadlineExceeded {
+		t.Errorf("Expected error to be %v, got %v instead", context.DeadlineExceeded, err)
+	}
+
+	c.Close()
+
+	if err := c.EmitContext(context.Background(), "progress", 3); err != ErrClientClosed {
+		t.Errorf("Expected error to be %v, got %v instead", ErrClientClosed, err)
+	}
+}
+
+func TestEmitContextQueueFull(t *testing.T) {
+	c := New(url.URL{}, &Options{
+		WriteQueueSize: 1,
+	})
+
+	defer c.Close()
+
+	c.setState(StateConnected, nil)
+	c.def().setReady()
+
+	go func() {
+		_ = c.Emit("progress", 1)
+	}()
+
+	for i := 0; i < 100 && c.BufferedAmount() == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	emitted := make(chan error, 1)
+
+	go func() {
+		emitted <- c.EmitContext(ctx, "progress", 2)
+	}()
+
+	select {
+	case err := <-emitted:
+		t.Fatalf("Expected emit to wait for room in the queue, got %v instead", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancel()
+
+	if err := <-emitted; err != context.Canceled {
+		t.Errorf("Expected error to be %v, got %v instead", context.Canceled, err)
+	}
+}
+
+func TestClientMsgPack(t *testing.T) {
+	var received = make(chan []byte, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var upgrader ws.Upgrader
+		conn, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			t.Errorf("Expected no error upgrading connection, got %v instead", err)
+			return
+		}
+
+		defer conn.Close()
+
+		_ = conn.WriteMessage(ws.TextMessage, []byte(`0{"sid":"abc","upgrades":[],"pingInterval":25000,"pingTimeout":60000}`))
+
+		for {
+			mt, data, err := conn.ReadMessage()
+
+			if err != nil {
+				return
+			}
+
+			if mt != ws.BinaryMessage {
+				t.Errorf("Expected binary message, got %q instead", data)
+			}
+
+			received <- data
+
+			var packet struct {
+				Type int `msgpack:"type"`
+			}
+
+			if err := codec.MsgPack.Unmarshal(data, &packet); err != nil {
+				t.Errorf("Expected no error decoding packet, got %v instead", err)
+			}
+
+			if packet.Type == 0 {
+				connect, _ := codec.MsgPack.Marshal(map[string]interface{}{
+					"type": 0,
+					"nsp":  "/",
+					"data": map[string]string{"sid": "s1"},
+				})
+
+				welcome, _ := codec.MsgPack.Marshal(map[string]interface{}{
+					"type": 2,
+					"nsp":  "/",
+					"data": []interface{}{"welcome", "hi", 42},
+				})
+
+				_ = conn.WriteMessage(ws.BinaryMessage, connect)
+				_ = conn.WriteMessage(ws.BinaryMessage, welcome)
+			}
+		}
+	}))
+
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	u.Scheme = "ws"
+
+	c := New(*u, &Options{
+		EngineIO: 4,
+		Codec:    codec.MsgPack,
+	})
+
+	defer c.Close()
+
+	welcome := make(chan int, 1)
+
+	if err := c.On("welcome", func(s string, n int) {
+		welcome <- n
+	}); err != nil {
+		t.Fatalf("Expected no error registering listener, got %v instead", err)
+	}
+
+	if err := c.Open(context.Background()); err != nil {
+		t.Fatalf("Expected no error opening connection, got %v instead", err)
+	}
+
+	<-received
+
+	select {
+	case got := <-welcome:
+		if got != 42 {
+			t.Errorf("Expected welcome message to be 42, got %v instead", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected welcome message to be received")
+	}
+
+	if id := c.def().ID(); id != "s1" {
+		t.Errorf("Expected namespace ID to be s1, got %v instead", id)
+	}
+
+	if err := c.Emit("fleet", 100); err != nil {
+		t.Errorf("Expected no error emitting, got %v instead", err)
+	}
+
+	var want, _ = codec.MsgPack.Marshal(map[string]interface{}{
+		"type": 2,
+		"data": []interface{}{"fleet", 100},
+		"nsp":  "/",
+	})
+
+	select {
+	case got := <-received:
+		var a, b map[string]interface{}
+		_ = codec.MsgPack.Unmarshal(got, &a)
+		_ = codec.MsgPack.Unmarshal(want, &b)
+
+		if ga, gb := fmt.Sprint(a), fmt.Sprint(b); ga != gb {
+			t.Errorf("Expected packet to be %v, got %v instead", gb, ga)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected packet to be received")
+	}
+}
+
+func TestClientIncomingAckRequest(t *testing.T) {
+	m := newMockServer(t, `4212["ask","weather"]`, `4213["notify"]`)
+	defer m.Close()
+
+	c := New(m.URL(), nil)
+	defer c.Close()
+
+	if err := c.On("ask", func(q string) string {
+		return "sunny " + q
+	}); err != nil {
+		t.Fatalf("Expected no error registering listener, got %v instead", err)
+	}
+
+	notified := make(chan struct{}, 1)
+
+	if err := c.On("notify", func() {
+		notified <- struct{}{}
+	}); err != nil {
+		t.Fatalf("Expected no error registering listener, got %v instead", err)
+	}
+
+	if err := c.Open(context.Background()); err != nil {
+		t.Fatalf("Expected no error opening connection, got %v instead", err)
+	}
+
+	m.expect(t, `4312["sunny weather"]`)
+
+	// listeners without a return value answer with an empty ack
+	m.expect(t, `4313[]`)
+
+	select {
+	case <-notified:
+	case <-time.After(time.Second):
+		t.Error("Expected notify listener to be called")
+	}
+}
+
+func TestClientFrameLimits(t *testing.T) {
+	var cases = []struct {
+		packet string
+		limit  websocket.Limit
+	}{
+		{`42["big","` + strings.Repeat("x", 1024) + `"]`, websocket.LimitFrameSize},
+		{`42["deep",[[[[[1]]]]]]`, websocket.LimitJSONDepth},
+		{`42["many",1,2,3,4,5]`, websocket.LimitArgs},
+	}
+
+	for _, tc := range cases {
+		m := newMockServer(t, tc.packet)
+
+		tr := websocket.NewTransport()
+		tr.MaxFrameSize = 512
+		tr.MaxJSONDepth = 4
+		tr.MaxArgs = 4
+
+		c := New(m.URL(), &Options{
+			Transport: tr,
+			Reconnect: true,
+		})
+
+		transitions := make(chan Transition, 20)
+		c.NotifyState(transitions)
+
+		if err := c.Open(context.Background()); err != nil {
+			t.Fatalf("Expected no error opening connection, got %v instead", err)
+		}
+
+		var closed bool
+
+		for !closed {
+			select {
+			case got := <-transitions:
+				if got.Namespace != nil || got.To != StateClosed {
+					continue
+				}
+
+				closed = true
+
+				if le, ok := got.Cause.(websocket.LimitError); !ok || le.Limit() != tc.limit {
+					t.Errorf("Expected cause of closing to be the %v limit, got %v instead", tc.limit, got.Cause)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("Expected client to close on the %v limit", tc.limit)
+			}
+		}
+
+		c.Close()
+		m.Close()
+	}
+}
@@ -0,0 +1,137 @@
+// Package clock abstracts the time used by timers and timeouts, such as pings, deadlines, and reconnection delays,
+// so they can be driven by a Fake clock in tests instead of waiting for real time to pass.
+package clock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and creates timers.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+
+	// AfterFunc calls f in its own goroutine once d elapses, or, on a Fake clock, when it is advanced past it.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is like time.Timer. C is nil for timers created with AfterFunc.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real clock, using the time package.
+var Real Clock = realClock{}
+
+// Or returns c, or Real if c is nil.
+func Or(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// WithTimeout is like context.WithTimeout, but the timeout elapses on the clock.
+// On clocks other than Real, Deadline doesn't report the timeout, as it wouldn't be in real time.
+func WithTimeout(parent context.Context, c Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if c == Real {
+		return context.WithTimeout(parent, d)
+	}
+
+	ctx := &timeoutCtx{
+		Context: parent,
+		done:    make(chan struct{}),
+	}
+
+	timer := c.AfterFunc(d, func() {
+		ctx.cancel(context.DeadlineExceeded)
+	})
+
+	go func() {
+		select {
+		case <-parent.Done():
+			ctx.cancel(parent.Err())
+		case <-ctx.done:
+		}
+	}()
+
+	return ctx, func() {
+		timer.Stop()
+		ctx.cancel(context.Canceled)
+	}
+}
+
+// timeoutCtx is done when its parent is, or when a timer of the clock fires.
+type timeoutCtx struct {
+	context.Context
+
+	done   chan struct{}
+	err    error
+	locker sync.Mutex
+}
+
+func (c *timeoutCtx) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *timeoutCtx) Err() error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	return c.err
+}
+
+func (c *timeoutCtx) cancel(err error) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+
+	if c.err == nil {
+		c.err = err
+		close(c.done)
+	}
+}
@@ -0,0 +1,193 @@
+package clock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Fake clock for tests. Its time only moves when it is advanced, firing the timers and tickers that are due.
+type Fake struct {
+	now    time.Time
+	timers map[*fakeTimer]struct{}
+	seq    int
+
+	// changed is closed when a timer is started
+	changed chan struct{}
+
+	locker sync.Mutex
+}
+
+// NewFake creates a fake clock starting at the given time.
+func NewFake(now time.Time) *Fake {
+	return &Fake{
+		now:     now,
+		timers:  map[*fakeTimer]struct{}{},
+		changed: make(chan struct{}),
+	}
+}
+
+// Now on the clock.
+func (f *Fake) Now() time.Time {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	return f.now
+}
+
+// NewTimer that fires when the clock is advanced by d.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{f: f, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// NewTicker that ticks every time the clock is advanced by d.
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for clock.Fake.NewTicker")
+	}
+
+	t := &fakeTimer{f: f, c: make(chan time.Time, 1), period: d}
+	t.Reset(d)
+	return fakeTicker{t}
+}
+
+// AfterFunc calls f when the clock is advanced by d. It is called by Advance, before it returns.
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	t := &fakeTimer{f: f, fn: fn}
+	t.Reset(d)
+	return t
+}
+
+// Advance the clock by d, firing the timers that are due in order, each at its own time.
+// Timers created by the functions called by AfterFunc timers fire too, if they are due.
+func (f *Fake) Advance(d time.Duration) {
+	f.locker.Lock()
+	end := f.now.Add(d)
+
+	for {
+		t := f.next(end)
+
+		if t == nil {
+			break
+		}
+
+		f.now = t.when
+
+		if t.period > 0 {
+			t.when = t.when.Add(t.period)
+		} else {
+			delete(f.timers, t)
+		}
+
+		now := f.now
+		f.locker.Unlock()
+
+		if t.fn != nil {
+			t.fn()
+		} else {
+			// like the time package, ticks are dropped for slow receivers
+			select {
+			case t.c <- now:
+			default:
+			}
+		}
+
+		f.locker.Lock()
+	}
+
+	f.now = end
+	f.locker.Unlock()
+}
+
+// next timer due by end, if any. It must be called with the lock held.
+func (f *Fake) next(end time.Time) *fakeTimer {
+	var next *fakeTimer
+
+	for t := range f.timers {
+		if t.when.After(end) {
+			continue
+		}
+
+		if next == nil || t.when.Before(next.when) || (t.when.Equal(next.when) && t.seq < next.seq) {
+			next = t
+		}
+	}
+
+	return next
+}
+
+// Timers running on the clock, including tickers.
+func (f *Fake) Timers() int {
+	f.locker.Lock()
+	defer f.locker.Unlock()
+	return len(f.timers)
+}
+
+// WaitTimers waits until at least n timers are running on the clock,
+// so a test can advance it once the code under test is waiting for them.
+func (f *Fake) WaitTimers(ctx context.Context, n int) error {
+	for {
+		f.locker.Lock()
+		running, changed := len(f.timers), f.changed
+		f.locker.Unlock()
+
+		if running >= n {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// fakeTimer is a timer or, if it has a period, a ticker of a Fake clock.
+type fakeTimer struct {
+	f      *Fake
+	c      chan time.Time
+	fn     func()
+	period time.Duration
+
+	// when the timer fires, and seq to fire the ones due at the same time in the order they were started
+	when time.Time
+	seq  int
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.f.locker.Lock()
+	defer t.f.locker.Unlock()
+
+	_, running := t.f.timers[t]
+	delete(t.f.timers, t)
+	return running
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	f := t.f
+	f.locker.Lock()
+	defer f.locker.Unlock()
+
+	_, running := f.timers[t]
+	f.seq++
+	t.when, t.seq = f.now.Add(d), f.seq
+	f.timers[t] = struct{}{}
+
+	close(f.changed)
+	f.changed = make(chan struct{})
+	return running
+}
+
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (t fakeTicker) Stop() {
+	t.fakeTimer.Stop()
+}
@@ -0,0 +1,192 @@
+package clock
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func fired(t Timer) bool {
+	select {
+	case <-t.C():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFakeTimer(t *testing.T) {
+	f := NewFake(epoch)
+	timer := f.NewTimer(time.Second)
+
+	f.Advance(999 * time.Millisecond)
+
+	if fired(timer) {
+		t.Errorf("Expected timer not to fire before its time")
+	}
+
+	f.Advance(time.Millisecond)
+
+	select {
+	case now := <-timer.C():
+		if !now.Equal(epoch.Add(time.Second)) {
+			t.Errorf("Expected timer to fire at %v, got %v instead", epoch.Add(time.Second), now)
+		}
+	default:
+		t.Errorf("Expected timer to fire")
+	}
+
+	if timer.Stop() {
+		t.Errorf("Expected Stop to return false for a timer that fired")
+	}
+
+	if timer.Reset(time.Second) {
+		t.Errorf("Expected Reset to return false for a timer that fired")
+	}
+
+	if !timer.Stop() {
+		t.Errorf("Expected Stop to return true for a running timer")
+	}
+
+	f.Advance(time.Hour)
+
+	if fired(timer) {
+		t.Errorf("Expected stopped timer not to fire")
+	}
+
+	if now := f.Now(); !now.Equal(epoch.Add(time.Hour + time.Second)) {
+		t.Errorf("Expected time to be %v, got %v instead", epoch.Add(time.Hour+time.Second), now)
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	f := NewFake(epoch)
+	ticker := f.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var ticks []time.Time
+
+	for i := 0; i < 3; i++ {
+		f.Advance(time.Second)
+		ticks = append(ticks, <-ticker.C())
+	}
+
+	want := []time.Time{epoch.Add(time.Second), epoch.Add(2 * time.Second), epoch.Add(3 * time.Second)}
+
+	if !reflect.DeepEqual(ticks, want) {
+		t.Errorf("Expected ticks %v, got %v instead", want, ticks)
+	}
+
+	// ticks are dropped while the receiver is busy
+	f.Advance(5 * time.Second)
+
+	if tick := <-ticker.C(); !tick.Equal(epoch.Add(4 * time.Second)) {
+		t.Errorf("Expected tick at %v, got %v instead", epoch.Add(4*time.Second), tick)
+	}
+}
+
+func TestFakeAfterFuncOrder(t *testing.T) {
+	f := NewFake(epoch)
+
+	var calls []string
+
+	f.AfterFunc(2*time.Second, func() {
+		calls = append(calls, "b")
+	})
+
+	f.AfterFunc(time.Second, func() {
+		calls = append(calls, "a")
+
+		// timers started while advancing fire if they are due
+		f.AfterFunc(500*time.Millisecond, func() {
+			calls = append(calls, "a2")
+		})
+	})
+
+	f.AfterFunc(2*time.Second, func() {
+		calls = append(calls, "c")
+	})
+
+	f.Advance(2 * time.Second)
+
+	if want := []string{"a", "a2", "b", "c"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("Expected calls %v, got %v instead", want, calls)
+	}
+
+	if n := f.Timers(); n != 0 {
+		t.Errorf("Expected no timers running, got %v instead", n)
+	}
+}
+
+func TestWaitTimers(t *testing.T) {
+	f := NewFake(epoch)
+	done := make(chan struct{})
+
+	go func() {
+		timer := f.NewTimer(time.Minute)
+		<-timer.C()
+		close(done)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := f.WaitTimers(ctx, 1); err != nil {
+		t.Fatalf("Expected no error waiting for timers, got %v instead", err)
+	}
+
+	f.Advance(time.Minute)
+	<-done
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := f.WaitTimers(ctx, 1); err != context.DeadlineExceeded {
+		t.Errorf("Expected error to be %v, got %v instead", context.DeadlineExceeded, err)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	f := NewFake(epoch)
+	ctx, cancel := WithTimeout(context.Background(), f, time.Second)
+	defer cancel()
+
+	child, cancelChild := context.WithCancel(ctx)
+	defer cancelChild()
+
+	if _, ok := ctx.Deadline(); ok {
+		t.Errorf("Expected no deadline")
+	}
+
+	f.Advance(time.Second)
+	<-child.Done()
+
+	if err := ctx.Err(); err != context.DeadlineExceeded {
+		t.Errorf("Expected error to be %v, got %v instead", context.DeadlineExceeded, err)
+	}
+
+	if err := child.Err(); err != context.DeadlineExceeded {
+		t.Errorf("Expected error of child to be %v, got %v instead", context.DeadlineExceeded, err)
+	}
+
+	parent, cancelParent := context.WithCancel(context.Background())
+	ctx, cancel = WithTimeout(parent, f, time.Second)
+	defer cancel()
+
+	cancelParent()
+	<-ctx.Done()
+
+	if err := ctx.Err(); err != context.Canceled {
+		t.Errorf("Expected error to be %v, got %v instead", context.Canceled, err)
+	}
+
+	ctx, cancel = WithTimeout(context.Background(), Real, time.Second)
+	defer cancel()
+
+	if _, ok := ctx.Deadline(); !ok {
+		t.Errorf("Expected deadline on the real clock")
+	}
+}
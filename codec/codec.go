@@ -0,0 +1,100 @@
+// Package codec provides the payload codecs for socket.io packets, such as the arguments of events.
+package codec
+
+import (
+	"encoding/json"
+
+	"github.com/wedeploy/gosocketio/internal/msgpack"
+)
+
+// Codec marshals and unmarshals the payloads of packets.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+
+	// Split an encoded array into its encoded elements.
+	Split(data []byte) ([][]byte, error)
+}
+
+// JSON codec, used with the default socket.io parser.
+var JSON Codec = NewJSON(json.Marshal, json.Unmarshal)
+
+// NewJSON creates a JSON codec with the given functions, so a faster JSON library can be used.
+// The unmarshal function must support json.RawMessage.
+func NewJSON(marshal func(v interface{}) ([]byte, error), unmarshal func(data []byte, v interface{}) error) Codec {
+	return jsonCodec{
+		marshal:   marshal,
+		unmarshal: unmarshal,
+	}
+}
+
+type jsonCodec struct {
+	marshal   func(v interface{}) ([]byte, error)
+	unmarshal func(data []byte, v interface{}) error
+}
+
+func (c jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return c.marshal(v)
+}
+
+func (c jsonCodec) Unmarshal(data []byte, v interface{}) (err error) {
+	// preventing json/encoding "index out of range" panic
+	defer func() {
+		if r := recover(); r != nil && err == nil {
+			err = r.(error)
+		}
+	}()
+
+	return c.unmarshal(data, v)
+}
+
+func (c jsonCodec) Split(data []byte) ([][]byte, error) {
+	var parts []json.RawMessage
+
+	if err := c.Unmarshal(data, &parts); err != nil {
+		return nil, err
+	}
+
+	var split = make([][]byte, len(parts))
+
+	for i, p := range parts {
+		split[i] = p
+	}
+
+	return split, nil
+}
+
+// MsgPack codec, wire-compatible with socket.io-msgpack-parser.
+// When used, whole packets are encoded with it and sent as binary frames.
+// Struct fields are mapped with the msgpack tag, or the json one as a fallback.
+var MsgPack Codec = MsgPackCodec{}
+
+// MsgPackCodec is the type of the MsgPack codec.
+type MsgPackCodec struct{}
+
+// Marshal v.
+func (MsgPackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+// Unmarshal data into v.
+func (MsgPackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// Split an encoded array into its encoded elements.
+func (MsgPackCodec) Split(data []byte) ([][]byte, error) {
+	parts, err := msgpack.Split(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var split = make([][]byte, len(parts))
+
+	for i, p := range parts {
+		split[i] = p
+	}
+
+	return split, nil
+}
@@ -0,0 +1,74 @@
+package codec
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONSplit(t *testing.T) {
+	parts, err := JSON.Split([]byte(`["a",{"b":[1,2]},3]`))
+
+	if err != nil {
+		t.Fatalf("Expected no error splitting, got %v instead", err)
+	}
+
+	var want = []string{`"a"`, `{"b":[1,2]}`, `3`}
+
+	if len(parts) != len(want) {
+		t.Fatalf("Expected %d parts, got %d instead", len(want), len(parts))
+	}
+
+	for i, p := range parts {
+		if string(p) != want[i] {
+			t.Errorf("Expected part %d to be %v, got %s instead", i, want[i], p)
+		}
+	}
+
+	if _, err := JSON.Split([]byte(`{}`)); err == nil {
+		t.Error("Expected error splitting an object")
+	}
+}
+
+func TestNewJSON(t *testing.T) {
+	var marshaled, unmarshaled bool
+
+	c := NewJSON(func(v interface{}) ([]byte, error) {
+		marshaled = true
+		return json.Marshal(v)
+	}, func(data []byte, v interface{}) error {
+		unmarshaled = true
+		return json.Unmarshal(data, v)
+	})
+
+	b, _ := c.Marshal([]int{1})
+
+	var v []int
+
+	if err := c.Unmarshal(b, &v); err != nil || len(v) != 1 {
+		t.Errorf("Expected value to round trip, got %v (%v) instead", v, err)
+	}
+
+	if !marshaled || !unmarshaled {
+		t.Error("Expected the given functions to be used")
+	}
+}
+
+func TestMsgPack(t *testing.T) {
+	b, err := MsgPack.Marshal([]interface{}{"a", 1})
+
+	if err != nil {
+		t.Fatalf("Expected no error marshaling, got %v instead", err)
+	}
+
+	parts, err := MsgPack.Split(b)
+
+	if err != nil || len(parts) != 2 {
+		t.Fatalf("Expected 2 parts, got %d (%v) instead", len(parts), err)
+	}
+
+	var s string
+
+	if err := MsgPack.Unmarshal(parts[0], &s); err != nil || s != "a" {
+		t.Errorf("Expected first part to be a, got %v (%v) instead", s, err)
+	}
+}
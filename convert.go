@@ -1,14 +1,14 @@
 package gosocketio
 
 import (
-	"encoding/json"
 	"fmt"
 	"reflect"
 
+	"github.com/wedeploy/gosocketio/codec"
 	"github.com/wedeploy/gosocketio/internal/protocol"
 )
 
-func (h *Handler) getFunctionCallArgs(msg *protocol.Message) (is []interface{}, err error) {
+func (h *Handler) getFunctionCallArgs(msg *protocol.Message, c codec.Codec) (is []interface{}, err error) {
 	is = []interface{}{}
 
 	if len(h.args) == 0 {
@@ -16,9 +16,9 @@ func (h *Handler) getFunctionCallArgs(msg *protocol.Message) (is []interface{},
 		return is, nil
 	}
 
-	var parts []json.RawMessage
+	parts, err := c.Split(msg.Data)
 
-	if err := jsonUnmarshalUnpanic(msg.Data, &parts); err != nil {
+	if err != nil {
 		return nil, err
 	}
 
@@ -40,10 +40,10 @@ func (h *Handler) getFunctionCallArgs(msg *protocol.Message) (is []interface{},
 
 	funcsArgsNum := len(funcArgs)
 
-	for c := 0; c < handledArguments; c++ {
-		if h.Variadic && c == funcsArgsNum-1 {
+	for i := 0; i < handledArguments; i++ {
+		if h.Variadic && i == funcsArgsNum-1 {
 			variadic := parts[funcsArgsNum-1:]
-			vis, err := getVariadicFunctionCallArgs(variadic, funcArgs[funcsArgsNum-1])
+			vis, err := getVariadicFunctionCallArgs(variadic, funcArgs[funcsArgsNum-1], c)
 
 			if err != nil {
 				return nil, err
@@ -53,51 +53,33 @@ func (h *Handler) getFunctionCallArgs(msg *protocol.Message) (is []interface{},
 			break
 		}
 
-		if err := jsonUnmarshalUnpanic(parts[c], &funcArgs[c]); err != nil {
+		if err := c.Unmarshal(parts[i], funcArgs[i]); err != nil {
 			return nil, err
 		}
 
-		is = append(is, funcArgs[c])
+		is = append(is, funcArgs[i])
 
 	}
 
 	return is, nil
 }
 
-func getVariadicFunctionCallArgs(variadic []json.RawMessage, funcArg interface{}) (vis []interface{}, err error) {
-	var vp = []byte("[")
+func getVariadicFunctionCallArgs(variadic [][]byte, funcArg interface{}, c codec.Codec) (vis []interface{}, err error) {
+	elems := reflect.ValueOf(funcArg).Elem()
 
-	for l, value := range variadic {
-		vp = append(vp, value...)
+	for _, value := range variadic {
+		elem := reflect.New(elems.Type().Elem())
 
-		if l != len(variadic)-1 {
-			vp = append(vp, ',')
+		if err := c.Unmarshal(value, elem.Interface()); err != nil {
+			return nil, err
 		}
-	}
 
-	vp = append(vp, ']')
-
-	if err := jsonUnmarshalUnpanic(vp, &funcArg); err != nil {
-		return nil, err
+		elems = reflect.Append(elems, elem.Elem())
 	}
 
-	elems := reflect.ValueOf(funcArg).Elem()
-	lenFuncArg := elems.Len()
-
-	for c := 0; c < lenFuncArg; c++ {
-		addElem := elems.Index(c)
-		vis = append(vis, addElem)
+	for i := 0; i < elems.Len(); i++ {
+		vis = append(vis, elems.Index(i))
 	}
 
 	return vis, nil
 }
-
-func jsonUnmarshalUnpanic(data []byte, v interface{}) (err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			err = r.(error)
-		}
-	}()
-
-	return json.Unmarshal(data, v)
-}
@@ -0,0 +1,178 @@
+package gosocketio
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/wedeploy/gosocketio/internal/protocol"
+	"github.com/wedeploy/gosocketio/outbox"
+)
+
+// ErrNoOutbox is returned when sending a durable message from a client without an outbox.
+var ErrNoOutbox = errors.New("socket.io client has no outbox")
+
+// durableMeta is sent as the last argument of durable messages.
+type durableMeta struct {
+	IdempotencyKey string `json:"idempotencyKey"`
+}
+
+// EmitDurable stores a message in the outbox and sends it to the server, asking for an ack.
+// The message is kept in the outbox until the server acknowledges it, and it is sent again
+// whenever the namespace is connected, including after a reconnection or by a new client
+// using the same outbox, so it is delivered at least once.
+// The idempotency key is sent as an extra last argument, {"idempotencyKey": key},
+// so the server can discard the duplicates.
+// It returns once the message is stored, without waiting for the ack.
+func (n *Namespace) EmitDurable(method string, args ...interface{}) (key string, err error) {
+	if n.outbox == nil {
+		return "", ErrNoOutbox
+	}
+
+	if n.isClosing() {
+		return "", ErrClientClosed
+	}
+
+	if key, err = outbox.NewKey(); err != nil {
+		return "", err
+	}
+
+	if args == nil {
+		args = []interface{}{}
+	}
+
+	raw, err := json.Marshal(args)
+
+	if err != nil {
+		return "", err
+	}
+
+	e := outbox.Entry{
+		Key:       key,
+		Namespace: n.name,
+		Method:    method,
+		Args:      raw,
+	}
+
+	if err := n.outbox.Append(e); err != nil {
+		return "", err
+	}
+
+	n.locker.Lock()
+
+	// otherwise, it is sent once the namespace is connected
+	if n.state != StateConnected {
+		n.locker.Unlock()
+		return key, nil
+	}
+
+	// queued with the lock held, so a flush of the outbox on connection doesn't send it twice, but waited for without it
+	wait, err := n.writeDurable(e)
+	n.locker.Unlock()
+
+	if err != nil {
+		return key, err
+	}
+
+	return key, wait()
+}
+
+// flushOutbox hands the outbox entries for the namespace to the out loop on a new session,
+// without waiting for them to be written. It returns the functions waiting for them.
+// It must be called with the lock held.
+func (n *Namespace) flushOutbox() (waits []func() error, err error) {
+	if n.outbox == nil {
+		return nil, nil
+	}
+
+	// acks for the previous session are never going to arrive
+	n.inflight = nil
+
+	for _, e := range n.outbox.Pending() {
+		if e.Namespace != n.name {
+			continue
+		}
+
+		wait, err := n.writeDurable(e)
+
+		if err != nil {
+			return waits, err
+		}
+
+		waits = append(waits, wait)
+	}
+
+	return waits, nil
+}
+
+// finishOutbox waits for the flushed outbox entries to be written.
+// The ones that couldn't be stay in the outbox, to be sent again on the next session.
+func (n *Namespace) finishOutbox(waits []func() error) {
+	var err error
+
+	for _, wait := range waits {
+		if werr := wait(); werr != nil && err == nil {
+			err = werr
+		}
+	}
+
+	if err != nil {
+		n.callLoopEvent(n.name, OnError, err)
+	}
+}
+
+// writeDurable hands an outbox entry to the out loop, returning the function waiting for it to be written.
+// It must be called with the lock held.
+func (n *Namespace) writeDurable(e outbox.Entry) (wait func() error, err error) {
+	var raw []json.RawMessage
+
+	if err := json.Unmarshal(e.Args, &raw); err != nil {
+		return nil, err
+	}
+
+	var args = make([]interface{}, 0, len(raw)+1)
+
+	for _, r := range raw {
+		args = append(args, r)
+	}
+
+	args = append(args, durableMeta{e.Key})
+
+	msg := &protocol.Message{
+		Type:      protocol.MessageTypeAckRequest,
+		AckID:     n.getAck().Next(),
+		Namespace: n.name,
+		Method:    e.Method,
+	}
+
+	frame, err := n.encodeFrame(msg, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if n.inflight == nil {
+		n.inflight = map[int]string{}
+	}
+
+	n.inflight[msg.AckID] = e.Key
+	return n.enqueue(frame)
+}
+
+// acknowledge removes the outbox entry sent with the given ack ID.
+// It returns false if the ack isn't for a durable message.
+func (n *Namespace) acknowledge(id int) bool {
+	n.locker.Lock()
+	key, ok := n.inflight[id]
+	delete(n.inflight, id)
+	n.locker.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	if err := n.outbox.Remove(key); err != nil {
+		n.callLoopEvent(n.name, OnError, err)
+	}
+
+	return true
+}
@@ -0,0 +1,163 @@
+package gosocketio
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/wedeploy/gosocketio/outbox"
+	"github.com/wedeploy/gosocketio/transport"
+	"github.com/wedeploy/gosocketio/transport/fault"
+)
+
+func TestEmitDurableWithoutOutbox(t *testing.T) {
+	c := New(url.URL{}, nil)
+
+	if _, err := c.EmitDurable("event"); err != ErrNoOutbox {
+		t.Errorf("Expected error to be %v, got %v instead", ErrNoOutbox, err)
+	}
+}
+
+func TestEmitDurable(t *testing.T) {
+	dir := t.TempDir()
+	o, err := outbox.Open(dir)
+
+	if err != nil {
+		t.Fatalf("Expected no error opening outbox, got %v instead", err)
+	}
+
+	m := newMockServer(t)
+	defer m.Close()
+
+	c := New(m.URL(), &Options{
+		Outbox: o,
+	})
+
+	// stored before connecting, and sent once connected
+	key, err := c.EmitDurable("order", "pizza")
+
+	if err != nil {
+		t.Fatalf("Expected no error emitting durable message, got %v instead", err)
+	}
+
+	if err := c.Open(context.Background()); err != nil {
+		t.Fatalf("Expected no error opening connection, got %v instead", err)
+	}
+
+	var want = `["order","pizza",{"idempotencyKey":"` + key + `"}]`
+	m.expect(t, `421`+want)
+
+	// the server never acknowledges it, so it is kept for the next client
+	c.Close()
+	_ = o.Close()
+
+	if o, err = outbox.Open(dir); err != nil {
+		t.Fatalf("Expected no error opening outbox again, got %v instead", err)
+	}
+
+	defer o.Close()
+
+	if l := o.Len(); l != 1 {
+		t.Fatalf("Expected 1 pending entry, got %v instead", l)
+	}
+
+	m2 := newMockServer(t)
+	defer m2.Close()
+
+	c = New(m2.URL(), &Options{
+		Outbox: o,
+	})
+
+	defer c.Close()
+
+	if err := c.Open(context.Background()); err != nil {
+		t.Fatalf("Expected no error opening connection, got %v instead", err)
+	}
+
+	conn := <-m2.conns
+
+	var got string
+
+	select {
+	case got = <-m2.received:
+	case <-time.After(time.Second):
+		t.Fatal("Expected message to be sent again")
+	}
+
+	if !strings.HasPrefix(got, "42") || !strings.HasSuffix(got, want) {
+		t.Fatalf("Expected message to be sent again as %v, got %v instead", want, got)
+	}
+
+	ackID := strings.TrimSuffix(strings.TrimPrefix(got, "42"), want)
+	_ = conn.WriteMessage(ws.TextMessage, []byte(`43`+ackID+`["ok"]`))
+
+	for i := 0; i < 100 && o.Len() != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if l := o.Len(); l != 0 {
+		t.Errorf("Expected outbox to be empty after the ack, got %v entries instead", l)
+	}
+}
+
+func TestEmitDurableFlushDoesNotBlock(t *testing.T) {
+	o, err := outbox.Open(t.TempDir())
+
+	if err != nil {
+		t.Fatalf("Expected no error opening outbox, got %v instead", err)
+	}
+
+	defer o.Close()
+
+	p := transport.NewPipe()
+	defer p.Close()
+
+	ft := fault.New(p)
+	c, peer := openPipe(t, p, &Options{
+		Transport: ft,
+		Outbox:    o,
+	}, `0{"sid":"abc","upgrades":[],"pingInterval":25000,"pingTimeout":20000}`)
+
+	defer c.Close()
+
+	ns, err := c.Of("/shop")
+
+	if err != nil {
+		t.Fatalf("Expected no error declaring namespace, got %v instead", err)
+	}
+
+	expectFrame(t, peer, "40/shop")
+
+	news := make(chan string, 1)
+
+	if err := ns.On("news", func(s string) {
+		news <- s
+	}); err != nil {
+		t.Fatalf("Expected no error adding listener, got %v instead", err)
+	}
+
+	if _, err := ns.EmitDurable("order", "pizza"); err != nil {
+		t.Fatalf("Expected no error emitting durable message, got %v instead", err)
+	}
+
+	// the outbox entry takes long to be written, but messages are still read meanwhile
+	ft.Outgoing(fault.Event("order")).Delay(time.Second)
+	_ = peer.WriteFrame(transport.Frame{Data: []byte(`40/shop`)})
+	_ = peer.WriteFrame(transport.Frame{Data: []byte(`42/shop,["news","hi"]`)})
+
+	select {
+	case got := <-news:
+		if got != "hi" {
+			t.Errorf("Expected news to be hi, got %v instead", got)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Error("Expected messages to be read while the outbox is flushed")
+	}
+
+	if f, err := peer.ReadFrame(); err != nil || !strings.HasPrefix(string(f.Data), `42/shop,1["order","pizza",`) {
+		t.Errorf("Expected peer to receive the durable message, got %q (%v) instead", f.Data, err)
+	}
+}
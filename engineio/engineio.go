@@ -0,0 +1,575 @@
+// Package engineio implements a client of Engine.IO, the transport layer of socket.io.
+// It establishes the session, keeps it alive with heartbeats, and carries raw text and binary messages,
+// leaving their content, such as socket.io packets, to the caller.
+package engineio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wedeploy/gosocketio/clock"
+	"github.com/wedeploy/gosocketio/protocol"
+	"github.com/wedeploy/gosocketio/transport"
+	"github.com/wedeploy/gosocketio/websocket"
+)
+
+var (
+	// ErrHandshake is returned when the server doesn't start the session with an open packet.
+	ErrHandshake = errors.New("engine.io invalid handshake")
+
+	// ErrUpgrade is returned when the session can't be upgraded to WebSocket.
+	ErrUpgrade = errors.New("engine.io upgrade failed")
+
+	// ErrPingTimeout is returned when the heartbeat of the server stops.
+	ErrPingTimeout = errors.New("engine.io ping timeout")
+
+	// ErrServerClose is returned when the server closes the session.
+	ErrServerClose = errors.New("engine.io server closed the connection")
+)
+
+// Message of Engine.IO, either text or binary. Its data doesn't carry the packet type.
+type Message = protocol.Frame
+
+// Header of the session, sent by the server with the open packet.
+// PingInterval and PingTimeout are in milliseconds.
+type Header struct {
+	Sid          string   `json:"sid"`
+	Upgrades     []string `json:"upgrades"`
+	PingInterval int      `json:"pingInterval"`
+	PingTimeout  int      `json:"pingTimeout"`
+	MaxPayload   int      `json:"maxPayload,omitempty"`
+}
+
+// Options for dialing.
+type Options struct {
+	// Transport used to connect to the server. If nil, websocket.NewTransport() is used.
+	Transport transport.Transport
+
+	// EngineIO protocol revision: 3 for socket.io v2 servers, or 4 for socket.io v3 and later.
+	// If zero, 4 is used.
+	EngineIO int
+
+	// Upgrade starts the session with HTTP long-polling and upgrades it to WebSocket, like browsers do.
+	// Use it for servers or proxies that don't accept WebSocket connections without a session.
+	Upgrade bool
+
+	// HTTPClient for the long-polling handshake. If nil, http.DefaultClient is used.
+	// The long-polling handshake is sent with the request header of the transport if it is a *websocket.Transport.
+	HTTPClient *http.Client
+
+	// Clock for the heartbeat. If nil, clock.Real is used.
+	Clock clock.Clock
+
+	// Dialed, if set, is called once the connection of the transport is established, before the handshake.
+	// With Upgrade, it is called once the WebSocket connection is established, before probing it.
+	Dialed func()
+}
+
+// Conn is an Engine.IO session over a connection of the transport, such as WebSocket.
+// Pings and pongs are handled by ReadMessage, so the session is kept alive only while it is being read.
+// Pongs are written by the heartbeat, so reading doesn't wait for the messages being written.
+type Conn struct {
+	socket   transport.Conn
+	header   Header
+	engineIO int
+	clock    clock.Clock
+
+	interval time.Duration
+	timeout  time.Duration
+
+	// pending messages received with the long-polling handshake, read before the socket
+	pending []transport.Frame
+
+	alive     chan struct{}
+	pongs     chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+
+	err       error
+	errLocker sync.RWMutex
+
+	writeLocker sync.Mutex
+}
+
+// Dial the server at u and wait for the handshake.
+// The EIO and transport query parameters are set by Dial.
+func Dial(ctx context.Context, u url.URL, opts *Options) (*Conn, error) {
+	var o Options
+
+	if opts != nil {
+		o = *opts
+	}
+
+	if o.Transport == nil {
+		o.Transport = websocket.NewTransport()
+	}
+
+	if o.EngineIO == 0 {
+		o.EngineIO = 4
+	}
+
+	var (
+		socket  transport.Conn
+		header  Header
+		pending []transport.Frame
+		err     error
+	)
+
+	if o.Upgrade {
+		socket, header, pending, err = upgrade(ctx, u, o)
+	} else {
+		socket, header, err = open(ctx, u, o)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conn{
+		socket:   socket,
+		header:   header,
+		engineIO: o.EngineIO,
+		clock:    clock.Or(o.Clock),
+		pending:  pending,
+		interval: time.Duration(header.PingInterval) * time.Millisecond,
+		timeout:  time.Duration(header.PingTimeout) * time.Millisecond,
+		alive:    make(chan struct{}, 1),
+		pongs:    make(chan []byte, 1),
+		done:     make(chan struct{}),
+	}
+
+	interval, timeout := socket.PingParams()
+
+	if c.interval <= 0 {
+		c.interval = orDefault(interval, websocket.PingInterval)
+	}
+
+	if c.timeout <= 0 {
+		c.timeout = orDefault(timeout, websocket.PingTimeout)
+	}
+
+	go c.heartbeat()
+	return c, nil
+}
+
+// open a WebSocket session and read its open packet.
+func open(ctx context.Context, u url.URL, o Options) (transport.Conn, Header, error) {
+	var header Header
+	socket, err := o.Transport.Dial(ctx, endpoint(u, o.EngineIO, "websocket", ""))
+
+	if err != nil {
+		return nil, header, err
+	}
+
+	o.dialed()
+
+	err = watch(ctx, socket, func() error {
+		f, err := socket.ReadFrame()
+
+		if err != nil {
+			return err
+		}
+
+		header, err = parseOpen(f)
+		return err
+	})
+
+	if err != nil {
+		socket.Close()
+		return nil, header, err
+	}
+
+	return socket, header, nil
+}
+
+// upgrade a long-polling session to WebSocket by probing it first.
+func upgrade(ctx context.Context, u url.URL, o Options) (transport.Conn, Header, []transport.Frame, error) {
+	header, pending, err := poll(ctx, u, o)
+
+	if err != nil {
+		return nil, header, nil, err
+	}
+
+	if !contains(header.Upgrades, "websocket") {
+		return nil, header, nil, ErrUpgrade
+	}
+
+	socket, err := o.Transport.Dial(ctx, endpoint(u, o.EngineIO, "websocket", header.Sid))
+
+	if err != nil {
+		return nil, header, nil, err
+	}
+
+	o.dialed()
+
+	err = watch(ctx, socket, func() error {
+		if err := socket.WriteFrame(transport.Frame{Data: []byte("2probe")}); err != nil {
+			return err
+		}
+
+		f, err := socket.ReadFrame()
+
+		if err != nil {
+			return err
+		}
+
+		if f.Binary || string(f.Data) != "3probe" {
+			return ErrUpgrade
+		}
+
+		return socket.WriteFrame(transport.Frame{Data: []byte{'0' + byte(protocol.EngineUpgrade)}})
+	})
+
+	if err != nil {
+		socket.Close()
+		return nil, header, nil, err
+	}
+
+	return socket, header, pending, nil
+}
+
+func (o Options) dialed() {
+	if o.Dialed != nil {
+		o.Dialed()
+	}
+}
+
+// watch closes the socket if the context is done before f returns.
+func watch(ctx context.Context, socket transport.Conn, f func() error) error {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		select {
+		case <-ctx.Done():
+			socket.Close()
+		case <-stop:
+		}
+	}()
+
+	err := f()
+	close(stop)
+	<-stopped
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// parseOpen reads the header of the session from the open packet.
+func parseOpen(f transport.Frame) (Header, error) {
+	var header Header
+
+	if f.Binary || len(f.Data) == 0 || f.Data[0] != '0'+byte(protocol.EngineOpen) {
+		return header, ErrHandshake
+	}
+
+	if err := json.Unmarshal(f.Data[1:], &header); err != nil || header.Sid == "" {
+		return header, ErrHandshake
+	}
+
+	return header, nil
+}
+
+// endpoint of the given transport, such as "websocket" or "polling".
+func endpoint(u url.URL, engineIO int, transport string, sid string) string {
+	switch {
+	case transport == "websocket" && u.Scheme == "http":
+		u.Scheme = "ws"
+	case transport == "websocket" && u.Scheme == "https":
+		u.Scheme = "wss"
+	case transport == "polling" && u.Scheme == "ws":
+		u.Scheme = "http"
+	case transport == "polling" && u.Scheme == "wss":
+		u.Scheme = "https"
+	}
+
+	var query = u.Query()
+	query.Set("EIO", strconv.Itoa(engineIO))
+	query.Set("transport", transport)
+
+	if sid != "" {
+		query.Set("sid", sid)
+	}
+
+	// socket.io v2 servers only send text payloads when asked to
+	if transport == "polling" && engineIO < 4 {
+		query.Set("b64", "1")
+	}
+
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func orDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+
+	return d
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Header of the session.
+func (c *Conn) Header() Header {
+	return c.header
+}
+
+// ID of the session.
+func (c *Conn) ID() string {
+	return c.header.Sid
+}
+
+// ReadMessage waits for the next message, answering pings and skipping noop packets.
+// It must not be called concurrently.
+//
+// Invalid packets return protocol.ErrUnknownPacketType, or the error of the transport, such as
+// websocket.ErrBadBuffer, and leave the connection open. Once the connection is closed,
+// the error tells why, such as ErrServerClose or ErrPingTimeout.
+func (c *Conn) ReadMessage() (Message, error) {
+	for {
+		f, err := c.next()
+
+		if err != nil {
+			return Message{}, c.cause(err)
+		}
+
+		if f.Binary {
+			return c.binary(f)
+		}
+
+		if len(f.Data) == 0 {
+			return Message{}, protocol.ErrUnknownPacketType
+		}
+
+		var data = f.Data[1:]
+
+		switch protocol.EnginePacketType(f.Data[0] - '0') {
+		case protocol.EngineMessage:
+			return Message{Data: data}, nil
+		case protocol.EnginePing:
+			c.beat()
+			c.pong(data)
+		case protocol.EnginePong:
+			c.beat()
+		case protocol.EngineNoop:
+		case protocol.EngineClose:
+			c.fail(ErrServerClose)
+			return Message{}, ErrServerClose
+		default:
+			return Message{}, protocol.ErrUnknownPacketType
+		}
+	}
+}
+
+func (c *Conn) next() (transport.Frame, error) {
+	if len(c.pending) != 0 {
+		f := c.pending[0]
+		c.pending = c.pending[1:]
+		return f, nil
+	}
+
+	return c.socket.ReadFrame()
+}
+
+// binary message from the frame, which Engine.IO v3 prefixes with the message packet type.
+func (c *Conn) binary(f transport.Frame) (Message, error) {
+	if c.engineIO >= 4 {
+		return f, nil
+	}
+
+	if len(f.Data) == 0 || f.Data[0] != byte(protocol.EngineMessage) {
+		return Message{}, protocol.ErrUnknownPacketType
+	}
+
+	return Message{Data: f.Data[1:], Binary: true}, nil
+}
+
+// WriteMessage to the server.
+func (c *Conn) WriteMessage(m Message) error {
+	c.writeLocker.Lock()
+	defer c.writeLocker.Unlock()
+	return c.socket.WriteFrame(c.frame(m))
+}
+
+// WriteMessages to the server, coalescing them into as few network writes as possible.
+// It returns the error for each message.
+func (c *Conn) WriteMessages(messages []Message) []error {
+	var frames = make([]transport.Frame, len(messages))
+
+	for i, m := range messages {
+		frames[i] = c.frame(m)
+	}
+
+	c.writeLocker.Lock()
+	defer c.writeLocker.Unlock()
+
+	if bw, ok := c.socket.(transport.BatchWriter); ok {
+		return bw.WriteFrames(frames)
+	}
+
+	var errs = make([]error, len(frames))
+
+	for i, f := range frames {
+		errs[i] = c.socket.WriteFrame(f)
+	}
+
+	return errs
+}
+
+func (c *Conn) frame(m Message) transport.Frame {
+	if !m.Binary {
+		return transport.Frame{Data: append([]byte{'0' + byte(protocol.EngineMessage)}, m.Data...)}
+	}
+
+	if c.engineIO < 4 {
+		return transport.Frame{Data: append([]byte{byte(protocol.EngineMessage)}, m.Data...), Binary: true}
+	}
+
+	return m
+}
+
+// write an Engine.IO packet other than a message.
+func (c *Conn) write(t protocol.EnginePacketType, data []byte) error {
+	c.writeLocker.Lock()
+	defer c.writeLocker.Unlock()
+
+	return c.socket.WriteFrame(transport.Frame{
+		Data: append([]byte{'0' + byte(t)}, data...),
+	})
+}
+
+// heartbeat of the session: on Engine.IO v3, the client pings the server, which must answer in time;
+// on later versions, the server pings the client, and the session times out when the pings stop.
+func (c *Conn) heartbeat() {
+	if c.engineIO >= 4 {
+		timer := c.clock.NewTimer(c.interval + c.timeout)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-c.alive:
+				timer.Reset(c.interval + c.timeout)
+			case data := <-c.pongs:
+				// a write failure is read by ReadMessage
+				_ = c.write(protocol.EnginePong, data)
+			case <-timer.C():
+				c.fail(ErrPingTimeout)
+				return
+			}
+		}
+	}
+
+	ticker := c.clock.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	var deadline clock.Timer
+	var expired <-chan time.Time
+
+	defer func() {
+		if deadline != nil {
+			deadline.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.alive:
+			if deadline != nil {
+				deadline.Stop()
+			}
+
+			deadline, expired = nil, nil
+		case data := <-c.pongs:
+			_ = c.write(protocol.EnginePong, data)
+		case <-ticker.C():
+			// a write failure is read by ReadMessage
+			_ = c.write(protocol.EnginePing, nil)
+
+			if deadline == nil {
+				deadline = c.clock.NewTimer(c.timeout)
+				expired = deadline.C()
+			}
+		case <-expired:
+			c.fail(ErrPingTimeout)
+			return
+		}
+	}
+}
+
+// beat tells the heartbeat that the server is alive.
+func (c *Conn) beat() {
+	select {
+	case c.alive <- struct{}{}:
+	default:
+	}
+}
+
+// pong answers a ping through the heartbeat. A pong already waiting to be written answers it too.
+func (c *Conn) pong(data []byte) {
+	select {
+	case c.pongs <- data:
+	default:
+	}
+}
+
+// fail closes the connection, keeping the cause for ReadMessage.
+func (c *Conn) fail(err error) {
+	c.errLocker.Lock()
+
+	if c.err == nil {
+		c.err = err
+	}
+
+	c.errLocker.Unlock()
+	c.Close()
+}
+
+// cause of a read error: why the connection was closed, if it was.
+func (c *Conn) cause(err error) error {
+	c.errLocker.RLock()
+	defer c.errLocker.RUnlock()
+
+	if c.err != nil {
+		return c.err
+	}
+
+	return err
+}
+
+// WriteClose tells the server the client is leaving with the close packet.
+// The connection is left open, for the caller to close.
+func (c *Conn) WriteClose() error {
+	return c.write(protocol.EngineClose, nil)
+}
+
+// Close the connection immediately.
+func (c *Conn) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.socket.Close()
+	})
+}
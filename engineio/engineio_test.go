@@ -0,0 +1,618 @@
+package engineio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/wedeploy/gosocketio/clock"
+	"github.com/wedeploy/gosocketio/protocol"
+	"github.com/wedeploy/gosocketio/transport"
+	"github.com/wedeploy/gosocketio/transport/fault"
+	"github.com/wedeploy/gosocketio/websocket"
+)
+
+const handshake = `0{"sid":"abc","upgrades":["websocket"],"pingInterval":25000,"pingTimeout":20000,"maxPayload":1000000}`
+
+type mockServer struct {
+	*httptest.Server
+
+	// polling is the payload of the long-polling handshake
+	polling string
+	queries chan url.Values
+	conns   chan *ws.Conn
+}
+
+// newMockServer sends the given packets on each new WebSocket connection, and hands it to the test.
+func newMockServer(t *testing.T, packets ...string) *mockServer {
+	m := &mockServer{
+		queries: make(chan url.Values, 10),
+		conns:   make(chan *ws.Conn, 1),
+	}
+
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.queries <- r.URL.Query()
+
+		if r.URL.Query().Get("transport") == "polling" {
+			_, _ = w.Write([]byte(m.polling))
+			return
+		}
+
+		var upgrader ws.Upgrader
+		conn, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			t.Errorf("Expected no error upgrading connection, got %v instead", err)
+			return
+		}
+
+		for _, p := range packets {
+			_ = conn.WriteMessage(ws.TextMessage, []byte(p))
+		}
+
+		m.conns <- conn
+	}))
+
+	return m
+}
+
+func (m *mockServer) URL() url.URL {
+	u, _ := url.Parse(m.Server.URL)
+	u.Scheme = "ws"
+	return *u
+}
+
+func (m *mockServer) dial(t *testing.T, opts *Options) (*Conn, *ws.Conn) {
+	t.Helper()
+
+	c, err := Dial(context.Background(), m.URL(), opts)
+
+	if err != nil {
+		t.Fatalf("Expected no error dialing, got %v instead", err)
+	}
+
+	conn := <-m.conns
+
+	// keeps the server side of the connection reachable until the test ends,
+	// otherwise it might be closed by the garbage collector while the client still uses it
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return c, conn
+}
+
+func expect(t *testing.T, conn *ws.Conn, want string) {
+	t.Helper()
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := conn.ReadMessage()
+
+	if err != nil || string(data) != want {
+		t.Errorf("Expected server to receive %v, got %q (%v) instead", want, data, err)
+	}
+}
+
+func TestDial(t *testing.T) {
+	m := newMockServer(t, handshake)
+	defer m.Close()
+
+	c, _ := m.dial(t, nil)
+	defer c.Close()
+
+	want := Header{
+		Sid:          "abc",
+		Upgrades:     []string{"websocket"},
+		PingInterval: 25000,
+		PingTimeout:  20000,
+		MaxPayload:   1000000,
+	}
+
+	if h := c.Header(); !reflect.DeepEqual(h, want) {
+		t.Errorf("Expected header to be %+v, got %+v instead", want, h)
+	}
+
+	if c.ID() != "abc" {
+		t.Errorf("Expected ID to be abc, got %v instead", c.ID())
+	}
+
+	q := <-m.queries
+
+	if q.Get("EIO") != "4" || q.Get("transport") != "websocket" {
+		t.Errorf("Expected query to ask for Engine.IO 4 over websocket, got %v instead", q)
+	}
+}
+
+func TestDialHandshake(t *testing.T) {
+	for _, p := range []string{`40`, `0{"sid":""}`, `0{`} {
+		m := newMockServer(t, p)
+
+		if _, err := Dial(context.Background(), m.URL(), nil); err != ErrHandshake {
+			t.Errorf("Expected error to be %v for %v, got %v instead", ErrHandshake, p, err)
+		}
+
+		m.Close()
+	}
+}
+
+func TestDialed(t *testing.T) {
+	m := newMockServer(t, `40`)
+	defer m.Close()
+
+	var dialed bool
+
+	// called once the transport is connected, even if the handshake fails
+	if _, err := Dial(context.Background(), m.URL(), &Options{Dialed: func() { dialed = true }}); err != ErrHandshake {
+		t.Errorf("Expected error to be %v, got %v instead", ErrHandshake, err)
+	}
+
+	if !dialed {
+		t.Error("Expected Dialed to be called")
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	m := newMockServer(t)
+	defer m.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := Dial(ctx, m.URL(), nil); err != context.DeadlineExceeded {
+		t.Errorf("Expected error to be %v, got %v instead", context.DeadlineExceeded, err)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	m := newMockServer(t, handshake, `6`, `4hello`, `2`, `6`)
+	defer m.Close()
+
+	c, conn := m.dial(t, nil)
+	defer c.Close()
+
+	_ = conn.WriteMessage(ws.BinaryMessage, []byte{1, 2, 3})
+
+	got := make(chan Message, 2)
+
+	go func() {
+		for {
+			msg, err := c.ReadMessage()
+
+			if err != nil {
+				close(got)
+				return
+			}
+
+			got <- msg
+		}
+	}()
+
+	if msg := <-got; msg.Binary || string(msg.Data) != "hello" {
+		t.Errorf("Expected text message hello, got %+v instead", msg)
+	}
+
+	// pings are answered while reading
+	expect(t, conn, "3")
+
+	if msg := <-got; !msg.Binary || !reflect.DeepEqual(msg.Data, []byte{1, 2, 3}) {
+		t.Errorf("Expected binary message, got %+v instead", msg)
+	}
+}
+
+func TestReadMessageUnknownPacket(t *testing.T) {
+	m := newMockServer(t, handshake, `9`, `4hello`)
+	defer m.Close()
+
+	c, _ := m.dial(t, nil)
+	defer c.Close()
+
+	if _, err := c.ReadMessage(); err != protocol.ErrUnknownPacketType {
+		t.Errorf("Expected error to be %v, got %v instead", protocol.ErrUnknownPacketType, err)
+	}
+
+	// the connection is still open
+	if msg, err := c.ReadMessage(); err != nil || string(msg.Data) != "hello" {
+		t.Errorf("Expected message hello, got %+v (%v) instead", msg, err)
+	}
+}
+
+func TestWriteMessage(t *testing.T) {
+	m := newMockServer(t, handshake)
+	defer m.Close()
+
+	c, conn := m.dial(t, nil)
+	defer c.Close()
+
+	if err := c.WriteMessage(Message{Data: []byte(`2["hi"]`)}); err != nil {
+		t.Errorf("Expected no error writing message, got %v instead", err)
+	}
+
+	expect(t, conn, `42["hi"]`)
+
+	errs := c.WriteMessages([]Message{
+		{Data: []byte("a")},
+		{Data: []byte{7}, Binary: true},
+	})
+
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("Expected no error writing messages, got %v instead", err)
+		}
+	}
+
+	expect(t, conn, "4a")
+
+	if mt, data, err := conn.ReadMessage(); err != nil || mt != ws.BinaryMessage || !reflect.DeepEqual(data, []byte{7}) {
+		t.Errorf("Expected binary message, got %x (%v) instead", data, err)
+	}
+
+	if err := c.WriteClose(); err != nil {
+		t.Errorf("Expected no error writing close packet, got %v instead", err)
+	}
+
+	expect(t, conn, "1")
+}
+
+func TestEngineIO3Binary(t *testing.T) {
+	m := newMockServer(t, `0{"sid":"abc","upgrades":[],"pingInterval":25000,"pingTimeout":60000}`)
+	defer m.Close()
+
+	c, conn := m.dial(t, &Options{EngineIO: 3})
+	defer c.Close()
+
+	if q := <-m.queries; q.Get("EIO") != "3" {
+		t.Errorf("Expected query to ask for Engine.IO 3, got %v instead", q)
+	}
+
+	_ = conn.WriteMessage(ws.BinaryMessage, []byte{1, 2})
+	_ = conn.WriteMessage(ws.BinaryMessage, []byte{4, 2})
+
+	if _, err := c.ReadMessage(); err != protocol.ErrUnknownPacketType {
+		t.Errorf("Expected error to be %v, got %v instead", protocol.ErrUnknownPacketType, err)
+	}
+
+	if msg, err := c.ReadMessage(); err != nil || !msg.Binary || !reflect.DeepEqual(msg.Data, []byte{2}) {
+		t.Errorf("Expected binary message without its packet type, got %+v (%v) instead", msg, err)
+	}
+
+	if err := c.WriteMessage(Message{Data: []byte{7}, Binary: true}); err != nil {
+		t.Errorf("Expected no error writing message, got %v instead", err)
+	}
+
+	if _, data, err := conn.ReadMessage(); err != nil || !reflect.DeepEqual(data, []byte{4, 7}) {
+		t.Errorf("Expected binary message prefixed with its packet type, got %x (%v) instead", data, err)
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	m := newMockServer(t, handshake, `1`)
+	defer m.Close()
+
+	c, _ := m.dial(t, nil)
+	defer c.Close()
+
+	if _, err := c.ReadMessage(); err != ErrServerClose {
+		t.Errorf("Expected error to be %v, got %v instead", ErrServerClose, err)
+	}
+}
+
+func TestPingTimeout(t *testing.T) {
+	m := newMockServer(t, `0{"sid":"abc","upgrades":[],"pingInterval":20,"pingTimeout":20}`)
+	defer m.Close()
+
+	c, conn := m.dial(t, nil)
+	defer c.Close()
+
+	done := make(chan error, 1)
+
+	go func() {
+		for {
+			if _, err := c.ReadMessage(); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+
+	// pings keep the session alive
+	for i := 0; i < 4; i++ {
+		time.Sleep(20 * time.Millisecond)
+		_ = conn.WriteMessage(ws.TextMessage, []byte("2"))
+		expect(t, conn, "3")
+	}
+
+	select {
+	case err := <-done:
+		if err != ErrPingTimeout {
+			t.Errorf("Expected error to be %v, got %v instead", ErrPingTimeout, err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected session to time out")
+	}
+}
+
+func TestHeartbeatEngineIO3(t *testing.T) {
+	m := newMockServer(t, `0{"sid":"abc","upgrades":[],"pingInterval":20,"pingTimeout":50}`)
+	defer m.Close()
+
+	c, conn := m.dial(t, &Options{EngineIO: 3})
+	defer c.Close()
+
+	done := make(chan error, 1)
+
+	go func() {
+		for {
+			if _, err := c.ReadMessage(); err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+
+	// the client pings the server, which answers with pongs
+	for i := 0; i < 3; i++ {
+		expect(t, conn, "2")
+		_ = conn.WriteMessage(ws.TextMessage, []byte("3"))
+	}
+
+	select {
+	case err := <-done:
+		t.Fatalf("Expected session to be alive, got %v instead", err)
+	default:
+	}
+
+	select {
+	case err := <-done:
+		if err != ErrPingTimeout {
+			t.Errorf("Expected error to be %v, got %v instead", ErrPingTimeout, err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected session to time out without pongs")
+	}
+}
+
+func TestHeartbeatClock(t *testing.T) {
+	for _, engineIO := range []int{3, 4} {
+		m := newMockServer(t, `0{"sid":"abc","upgrades":[],"pingInterval":25000,"pingTimeout":20000}`)
+		f := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+		c, conn := m.dial(t, &Options{EngineIO: engineIO, Clock: f})
+
+		done := make(chan error, 1)
+
+		go func() {
+			for {
+				if _, err := c.ReadMessage(); err != nil {
+					done <- err
+					return
+				}
+			}
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+
+		if err := f.WaitTimers(ctx, 1); err != nil {
+			t.Fatalf("Expected heartbeat to start, got %v instead", err)
+		}
+
+		// with Engine.IO v3, the client pings the server, which must answer within the ping timeout
+		if engineIO < 4 {
+			f.Advance(25 * time.Second)
+			expect(t, conn, "2")
+
+			if err := f.WaitTimers(ctx, 2); err != nil {
+				t.Fatalf("Expected ping timeout to start, got %v instead", err)
+			}
+		} else {
+			f.Advance(25 * time.Second)
+		}
+
+		cancel()
+		f.Advance(20*time.Second - time.Millisecond)
+
+		select {
+		case err := <-done:
+			t.Fatalf("Expected session to be alive, got %v instead", err)
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		f.Advance(time.Millisecond)
+
+		select {
+		case err := <-done:
+			if err != ErrPingTimeout {
+				t.Errorf("Expected error to be %v, got %v instead", ErrPingTimeout, err)
+			}
+		case <-time.After(time.Second):
+			t.Error("Expected session to time out")
+		}
+
+		c.Close()
+		m.Close()
+	}
+}
+
+func TestPongWhileWriting(t *testing.T) {
+	p := transport.NewPipe()
+	defer p.Close()
+
+	ft := fault.New(p)
+	accepted := make(chan *transport.PipeConn, 1)
+
+	go func() {
+		peer, _ := p.Accept(context.Background())
+		_ = peer.WriteFrame(transport.Frame{Data: []byte(handshake)})
+		accepted <- peer
+	}()
+
+	c, err := Dial(context.Background(), url.URL{Scheme: "ws", Host: "example.com"}, &Options{Transport: ft})
+
+	if err != nil {
+		t.Fatalf("Expected no error dialing, got %v instead", err)
+	}
+
+	defer c.Close()
+
+	peer := <-accepted
+
+	// a slow write holds the connection while the server pings
+	ft.Outgoing(fault.Engine(protocol.EngineMessage)).Delay(time.Second)
+
+	go func() {
+		_ = c.WriteMessages([]Message{{Data: []byte("slow")}})
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	_ = peer.WriteFrame(transport.Frame{Data: []byte("2")})
+	_ = peer.WriteFrame(transport.Frame{Data: []byte("4hello")})
+
+	got := make(chan Message, 1)
+
+	go func() {
+		msg, _ := c.ReadMessage()
+		got <- msg
+	}()
+
+	select {
+	case msg := <-got:
+		if string(msg.Data) != "hello" {
+			t.Errorf("Expected message hello, got %+v instead", msg)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Error("Expected reading not to wait for the pong to be written")
+	}
+
+	var frames []string
+
+	for i := 0; i < 2; i++ {
+		f, err := peer.ReadFrame()
+
+		if err != nil {
+			t.Fatalf("Expected server to receive frames, got %v instead", err)
+		}
+
+		frames = append(frames, string(f.Data))
+	}
+
+	if !reflect.DeepEqual(frames, []string{"4slow", "3"}) {
+		t.Errorf("Expected server to receive the message and the pong, got %q instead", frames)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	m := newMockServer(t, `3probe`, `6`, `4after`)
+	m.polling = handshake + "\x1e4before"
+	defer m.Close()
+
+	u := m.URL()
+	u.Scheme = "http"
+
+	c, err := Dial(context.Background(), u, &Options{Upgrade: true})
+
+	if err != nil {
+		t.Fatalf("Expected no error dialing, got %v instead", err)
+	}
+
+	defer c.Close()
+	conn := <-m.conns
+
+	if q := <-m.queries; q.Get("transport") != "polling" || q.Get("EIO") != "4" {
+		t.Errorf("Expected handshake with long-polling, got %v instead", q)
+	}
+
+	if q := <-m.queries; q.Get("transport") != "websocket" || q.Get("sid") != "abc" {
+		t.Errorf("Expected upgrade of the session to websocket, got %v instead", q)
+	}
+
+	expect(t, conn, "2probe")
+	expect(t, conn, "5")
+
+	for _, want := range []string{"before", "after"} {
+		if msg, err := c.ReadMessage(); err != nil || string(msg.Data) != want {
+			t.Errorf("Expected message %v, got %+v (%v) instead", want, msg, err)
+		}
+	}
+}
+
+func TestUpgradeRefused(t *testing.T) {
+	m := newMockServer(t)
+	m.polling = `0{"sid":"abc","upgrades":[],"pingInterval":25000,"pingTimeout":20000}`
+	defer m.Close()
+
+	if _, err := Dial(context.Background(), m.URL(), &Options{Upgrade: true}); err != ErrUpgrade {
+		t.Errorf("Expected error to be %v, got %v instead", ErrUpgrade, err)
+	}
+}
+
+func TestUpgradeLimits(t *testing.T) {
+	var cases = []struct {
+		polling string
+		limit   websocket.Limit
+	}{
+		{handshake + "\x1e4" + strings.Repeat("a", 200), websocket.LimitFrameSize},
+		{handshake + "\x1e42[\"a\",1,2]", websocket.LimitArgs},
+		{handshake + "\x1e42[\"a\",[[[1]]]]", websocket.LimitJSONDepth},
+	}
+
+	for _, c := range cases {
+		m := newMockServer(t)
+		m.polling = c.polling
+
+		wst := websocket.NewTransport()
+		wst.MaxFrameSize = 200
+		wst.MaxJSONDepth = 2
+		wst.MaxArgs = 1
+
+		_, err := Dial(context.Background(), m.URL(), &Options{Transport: wst, Upgrade: true})
+
+		if le, ok := err.(websocket.LimitError); !ok || le.Limit() != c.limit {
+			t.Errorf("Expected %v to be exceeded by %q, got %v instead", c.limit, c.polling, err)
+		}
+
+		m.Close()
+	}
+}
+
+func TestDecodePayload(t *testing.T) {
+	var cases = []struct {
+		payload  string
+		engineIO int
+		want     []protocol.Frame
+	}{
+		{
+			"0{}\x1e4hi\x1ebAQI=",
+			4,
+			[]protocol.Frame{{Data: []byte("0{}")}, {Data: []byte("4hi")}, {Data: []byte{1, 2}, Binary: true}},
+		},
+		{
+			"3:0{}2:40",
+			3,
+			[]protocol.Frame{{Data: []byte("0{}")}, {Data: []byte("40")}},
+		},
+		{
+			// lengths count characters as JavaScript does: 😀 is two of them
+			"4:42😀6:b4AQI=",
+			3,
+			[]protocol.Frame{{Data: []byte("42😀")}, {Data: []byte{4, 1, 2}, Binary: true}},
+		},
+	}
+
+	for _, c := range cases {
+		got, err := decodePayload([]byte(c.payload), c.engineIO)
+
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Expected payload %q to be %v, got %v (%v) instead", c.payload, c.want, got, err)
+		}
+	}
+
+	for _, invalid := range []string{"4", "x:40", "9:40", "0:", "4:b2AQ="} {
+		if _, err := decodePayload([]byte(invalid), 3); err != protocol.ErrInvalidPacket {
+			t.Errorf("Expected error to be %v for %q, got %v instead", protocol.ErrInvalidPacket, invalid, err)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package engineio
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/wedeploy/gosocketio/protocol"
+	"github.com/wedeploy/gosocketio/transport"
+	"github.com/wedeploy/gosocketio/websocket"
+)
+
+// recordSeparator between the packets of an Engine.IO v4 long-polling payload.
+const recordSeparator = 0x1e
+
+// poll the server for the long-polling handshake.
+// Packets sent along with the open packet are returned as the WebSocket frames they would have been.
+func poll(ctx context.Context, u url.URL, o Options) (Header, []transport.Frame, error) {
+	var header Header
+	req, err := http.NewRequest(http.MethodGet, endpoint(u, o.EngineIO, "polling", ""), nil)
+
+	if err != nil {
+		return header, nil, err
+	}
+
+	wst, limited := o.Transport.(*websocket.Transport)
+
+	if limited {
+		for k, v := range wst.RequestHeader {
+			req.Header[k] = v
+		}
+	}
+
+	var client = o.HTTPClient
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+
+	if err != nil {
+		return header, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return header, nil, ErrHandshake
+	}
+
+	var body []byte
+
+	// the frames of the payload are checked against the limits of the transport, as if read from WebSocket
+	if limited {
+		body, err = wst.ReadLimited(resp.Body)
+	} else {
+		body, err = io.ReadAll(resp.Body)
+	}
+
+	if err != nil {
+		return header, nil, err
+	}
+
+	frames, err := decodePayload(body, o.EngineIO)
+
+	if err != nil {
+		return header, nil, err
+	}
+
+	for i := 0; limited && i < len(frames); i++ {
+		if err := wst.CheckFrame(frames[i]); err != nil {
+			return header, nil, err
+		}
+	}
+
+	if len(frames) == 0 {
+		return header, nil, ErrHandshake
+	}
+
+	header, err = parseOpen(frames[0])
+	return header, frames[1:], err
+}
+
+// decodePayload splits a long-polling payload into the frames its packets would have on WebSocket.
+//
+// Engine.IO v4 separates packets with the record separator, 0x1e. Engine.IO v3 prefixes them with their length
+// in characters and a colon, such as "2:40". On both, binary packets are encoded as "b" followed by base64.
+func decodePayload(payload []byte, engineIO int) ([]transport.Frame, error) {
+	if engineIO >= 4 {
+		return decodeSeparatedPayload(payload)
+	}
+
+	return decodeLegacyPayload(payload)
+}
+
+func decodeSeparatedPayload(payload []byte) ([]transport.Frame, error) {
+	var frames []transport.Frame
+
+	for _, p := range bytes.Split(payload, []byte{recordSeparator}) {
+		f, err := decodePacket(p, false)
+
+		if err != nil {
+			return nil, err
+		}
+
+		frames = append(frames, f)
+	}
+
+	return frames, nil
+}
+
+func decodeLegacyPayload(payload []byte) ([]transport.Frame, error) {
+	var frames []transport.Frame
+
+	for len(payload) != 0 {
+		pos := bytes.IndexByte(payload, ':')
+
+		if pos == -1 {
+			return nil, protocol.ErrInvalidPacket
+		}
+
+		n, err := strconv.Atoi(string(payload[:pos]))
+
+		if err != nil || n <= 0 {
+			return nil, protocol.ErrInvalidPacket
+		}
+
+		payload = payload[pos+1:]
+		size, ok := utf16Prefix(payload, n)
+
+		if !ok {
+			return nil, protocol.ErrInvalidPacket
+		}
+
+		f, err := decodePacket(payload[:size], true)
+
+		if err != nil {
+			return nil, err
+		}
+
+		frames = append(frames, f)
+		payload = payload[size:]
+	}
+
+	return frames, nil
+}
+
+// utf16Prefix tells the size in bytes of the first n characters of p,
+// counted like JavaScript does, in UTF-16 code units.
+func utf16Prefix(p []byte, n int) (size int, ok bool) {
+	for n > 0 && size < len(p) {
+		r, width := utf8.DecodeRune(p[size:])
+		size += width
+		n--
+
+		if r >= 0x10000 {
+			n--
+		}
+	}
+
+	return size, n == 0
+}
+
+// decodePacket of a payload. Engine.IO v3 binary packets keep their packet type, like on WebSocket.
+func decodePacket(p []byte, legacy bool) (transport.Frame, error) {
+	if len(p) == 0 {
+		return transport.Frame{}, protocol.ErrInvalidPacket
+	}
+
+	if p[0] != 'b' {
+		return transport.Frame{Data: p}, nil
+	}
+
+	p = p[1:]
+	var prefix []byte
+
+	if legacy {
+		if len(p) == 0 || p[0] != '0'+byte(protocol.EngineMessage) {
+			return transport.Frame{}, protocol.ErrInvalidPacket
+		}
+
+		p, prefix = p[1:], []byte{byte(protocol.EngineMessage)}
+	}
+
+	data := make([]byte, base64.StdEncoding.DecodedLen(len(p)))
+	n, err := base64.StdEncoding.Decode(data, p)
+
+	if err != nil {
+		return transport.Frame{}, protocol.ErrInvalidPacket
+	}
+
+	return transport.Frame{Data: append(prefix, data[:n]...), Binary: true}, nil
+}
@@ -0,0 +1,731 @@
+package msgpack
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// Unmarshal the MessagePack encoded data into the value pointed to by v.
+// Extension values, such as JavaScript's undefined, are decoded as nil.
+func Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("msgpack: Unmarshal(non-pointer %T)", v)
+	}
+
+	if err := checkDepth(data); err != nil {
+		return err
+	}
+
+	d := decoder{data: data}
+
+	if err := d.decode(rv.Elem()); err != nil {
+		return err
+	}
+
+	if d.pos != len(d.data) {
+		return ErrTrailingData
+	}
+
+	return nil
+}
+
+// maxDepth of nested arrays and maps, like encoding/json, so that decoding can't exhaust the stack.
+const maxDepth = 10000
+
+// checkDepth of the first value in data, without recursion.
+func checkDepth(data []byte) error {
+	d := decoder{data: data}
+
+	// elements left in each array or map being read
+	var remaining []int
+
+	for {
+		h, err := d.header()
+
+		if err != nil {
+			return err
+		}
+
+		switch h.kind {
+		case kindString, kindBinary, kindExt:
+			if _, err := d.next(h.n); err != nil {
+				return err
+			}
+		case kindArray, kindMap:
+			var n = h.n
+
+			if h.kind == kindMap {
+				n *= 2
+			}
+
+			if n != 0 {
+				if len(remaining) == maxDepth {
+					return ErrMaxDepth
+				}
+
+				remaining = append(remaining, n)
+				continue
+			}
+		}
+
+		// the value is complete, and so is any array or map it was the last element of
+		for len(remaining) != 0 {
+			remaining[len(remaining)-1]--
+
+			if remaining[len(remaining)-1] != 0 {
+				break
+			}
+
+			remaining = remaining[:len(remaining)-1]
+		}
+
+		if len(remaining) == 0 {
+			return nil
+		}
+	}
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *decoder) peek() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, ErrUnexpectedEnd
+	}
+
+	return d.data[d.pos], nil
+}
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, ErrUnexpectedEnd
+	}
+
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *decoder) readUint(n int) (uint64, error) {
+	b, err := d.next(n)
+
+	if err != nil {
+		return 0, err
+	}
+
+	switch n {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
+	}
+}
+
+func (d *decoder) readLen(n int) (int, error) {
+	l, err := d.readUint(n)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if l > uint64(len(d.data)) {
+		return 0, ErrUnexpectedEnd
+	}
+
+	return int(l), nil
+}
+
+// kind of value
+type kind int
+
+const (
+	kindNil kind = iota
+	kindBool
+	kindInt
+	kindUint
+	kindFloat
+	kindString
+	kindBinary
+	kindArray
+	kindMap
+	kindExt
+)
+
+func (k kind) String() string {
+	return [...]string{"nil", "bool", "integer", "integer", "float", "string", "binary", "array", "map", "extension"}[k]
+}
+
+// header of a value: its kind, and its scalar value or its length.
+type header struct {
+	kind kind
+	b    bool
+	i    int64
+	u    uint64
+	f    float64
+	n    int
+}
+
+func (d *decoder) header() (h header, err error) {
+	c, err := d.peek()
+
+	if err != nil {
+		return h, err
+	}
+
+	d.pos++
+
+	switch {
+	case c <= 0x7f:
+		return header{kind: kindUint, u: uint64(c)}, nil
+	case c >= 0xe0:
+		return header{kind: kindInt, i: int64(int8(c))}, nil
+	case c&0xe0 == 0xa0:
+		return header{kind: kindString, n: int(c & 0x1f)}, nil
+	case c&0xf0 == 0x90:
+		return header{kind: kindArray, n: int(c & 0x0f)}, nil
+	case c&0xf0 == 0x80:
+		return header{kind: kindMap, n: int(c & 0x0f)}, nil
+	}
+
+	switch c {
+	case codeNil:
+		h.kind = kindNil
+	case codeFalse, codeTrue:
+		h.kind, h.b = kindBool, c == codeTrue
+	case codeUint8, codeUint16, codeUint32, codeUint64:
+		h.kind = kindUint
+		h.u, err = d.readUint(1 << (c - codeUint8))
+	case codeInt8, codeInt16, codeInt32, codeInt64:
+		var u uint64
+		h.kind = kindInt
+		u, err = d.readUint(1 << (c - codeInt8))
+
+		switch c {
+		case codeInt8:
+			h.i = int64(int8(u))
+		case codeInt16:
+			h.i = int64(int16(u))
+		case codeInt32:
+			h.i = int64(int32(u))
+		default:
+			h.i = int64(u)
+		}
+	case codeFloat32:
+		var u uint64
+		h.kind = kindFloat
+		u, err = d.readUint(4)
+		h.f = float64(math.Float32frombits(uint32(u)))
+	case codeFloat64:
+		var u uint64
+		h.kind = kindFloat
+		u, err = d.readUint(8)
+		h.f = math.Float64frombits(u)
+	case codeStr8, codeStr16, codeStr32:
+		h.kind = kindString
+		h.n, err = d.readLen(1 << (c - codeStr8))
+	case codeBin8, codeBin16, codeBin32:
+		h.kind = kindBinary
+		h.n, err = d.readLen(1 << (c - codeBin8))
+	case codeArray16, codeArray32:
+		h.kind = kindArray
+		h.n, err = d.readLen(2 << (c - codeArray16))
+	case codeMap16, codeMap32:
+		h.kind = kindMap
+		h.n, err = d.readLen(2 << (c - codeMap16))
+	case codeExt8, codeExt16, codeExt32:
+		h.kind = kindExt
+
+		if h.n, err = d.readLen(1 << (c - codeExt8)); err == nil {
+			// type of the extension
+			h.n++
+		}
+	default:
+		if c >= codeFixExt1 && c <= codeFixExt16 {
+			h.kind = kindExt
+			h.n = 1 + 1<<(c-codeFixExt1)
+			break
+		}
+
+		return h, fmt.Errorf("msgpack: invalid format code 0x%x", c)
+	}
+
+	return h, err
+}
+
+// skip the contents of a value whose header was already read.
+func (d *decoder) skip(h header) error {
+	switch h.kind {
+	case kindString, kindBinary, kindExt:
+		_, err := d.next(h.n)
+		return err
+	case kindArray, kindMap:
+		var n = h.n
+
+		if h.kind == kindMap {
+			n *= 2
+		}
+
+		for i := 0; i < n; i++ {
+			eh, err := d.header()
+
+			if err != nil {
+				return err
+			}
+
+			if err := d.skip(eh); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (d *decoder) decode(v reflect.Value) error {
+	if v.Type() == rawMessageType {
+		start := d.pos
+		h, err := d.header()
+
+		if err != nil {
+			return err
+		}
+
+		if err := d.skip(h); err != nil {
+			return err
+		}
+
+		v.SetBytes(append(RawMessage(nil), d.data[start:d.pos]...))
+		return nil
+	}
+
+	h, err := d.header()
+
+	if err != nil {
+		return err
+	}
+
+	if v.Type() == jsonRawMessageType {
+		return d.decodeJSON(h, v)
+	}
+
+	return d.decodeValue(h, v)
+}
+
+// decodeJSON converts a value to JSON.
+func (d *decoder) decodeJSON(h header, v reflect.Value) error {
+	any, err := d.decodeAny(h)
+
+	if err != nil {
+		return err
+	}
+
+	if b, ok := any.([]byte); ok {
+		any = string(b)
+	}
+
+	b, err := json.Marshal(any)
+
+	if err != nil {
+		return err
+	}
+
+	v.SetBytes(b)
+	return nil
+}
+
+func (d *decoder) decodeValue(h header, v reflect.Value) error {
+	if h.kind == kindNil || h.kind == kindExt {
+		if err := d.skip(h); err != nil {
+			return err
+		}
+
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+
+		return d.decodeValue(h, v.Elem())
+	}
+
+	if v.Kind() == reflect.Interface {
+		if v.NumMethod() != 0 {
+			return d.mismatch(h, v)
+		}
+
+		any, err := d.decodeAny(h)
+
+		if err != nil {
+			return err
+		}
+
+		if any == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(any))
+		}
+
+		return nil
+	}
+
+	if h.kind == kindString && v.CanAddr() && reflect.PtrTo(v.Type()).Implements(textUnmarshalerType) {
+		b, err := d.next(h.n)
+
+		if err != nil {
+			return err
+		}
+
+		return v.Addr().Interface().(interface{ UnmarshalText([]byte) error }).UnmarshalText(b)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if h.kind != kindBool {
+			return d.mismatch(h, v)
+		}
+
+		v.SetBool(h.b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := h.int()
+
+		if !ok || v.OverflowInt(i) {
+			return d.mismatch(h, v)
+		}
+
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, ok := h.uint()
+
+		if !ok || v.OverflowUint(u) {
+			return d.mismatch(h, v)
+		}
+
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, ok := h.float()
+
+		if !ok {
+			return d.mismatch(h, v)
+		}
+
+		v.SetFloat(f)
+	case reflect.String:
+		if h.kind != kindString && h.kind != kindBinary {
+			return d.mismatch(h, v)
+		}
+
+		b, err := d.next(h.n)
+
+		if err != nil {
+			return err
+		}
+
+		v.SetString(string(b))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && (h.kind == kindBinary || h.kind == kindString) {
+			b, err := d.next(h.n)
+
+			if err != nil {
+				return err
+			}
+
+			v.SetBytes(append([]byte{}, b...))
+			return nil
+		}
+
+		if h.kind != kindArray {
+			return d.mismatch(h, v)
+		}
+
+		s := reflect.MakeSlice(v.Type(), h.n, h.n)
+
+		for i := 0; i < h.n; i++ {
+			if err := d.decode(s.Index(i)); err != nil {
+				return err
+			}
+		}
+
+		v.Set(s)
+	case reflect.Array:
+		if h.kind != kindArray {
+			return d.mismatch(h, v)
+		}
+
+		for i := 0; i < h.n; i++ {
+			if i >= v.Len() {
+				eh, err := d.header()
+
+				if err == nil {
+					err = d.skip(eh)
+				}
+
+				if err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			if err := d.decode(v.Index(i)); err != nil {
+				return err
+			}
+		}
+
+		for i := h.n; i < v.Len(); i++ {
+			v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+		}
+	case reflect.Map:
+		return d.decodeMap(h, v)
+	case reflect.Struct:
+		return d.decodeStruct(h, v)
+	default:
+		return d.mismatch(h, v)
+	}
+
+	return nil
+}
+
+func (d *decoder) mismatch(h header, v reflect.Value) error {
+	return &UnmarshalTypeError{
+		Value: h.kind.String(),
+		Type:  v.Type(),
+	}
+}
+
+func (h header) int() (int64, bool) {
+	switch h.kind {
+	case kindInt:
+		return h.i, true
+	case kindUint:
+		return int64(h.u), h.u <= math.MaxInt64
+	case kindFloat:
+		return int64(h.f), h.f == math.Trunc(h.f) && math.Abs(h.f) <= 1<<53
+	}
+
+	return 0, false
+}
+
+func (h header) uint() (uint64, bool) {
+	switch h.kind {
+	case kindInt:
+		return uint64(h.i), h.i >= 0
+	case kindUint:
+		return h.u, true
+	case kindFloat:
+		return uint64(h.f), h.f >= 0 && h.f == math.Trunc(h.f) && h.f <= 1<<53
+	}
+
+	return 0, false
+}
+
+func (h header) float() (float64, bool) {
+	switch h.kind {
+	case kindInt:
+		return float64(h.i), true
+	case kindUint:
+		return float64(h.u), true
+	case kindFloat:
+		return h.f, true
+	}
+
+	return 0, false
+}
+
+func (d *decoder) decodeMap(h header, v reflect.Value) error {
+	if h.kind != kindMap {
+		return d.mismatch(h, v)
+	}
+
+	t := v.Type()
+
+	if v.IsNil() {
+		v.Set(reflect.MakeMapWithSize(t, h.n))
+	}
+
+	for i := 0; i < h.n; i++ {
+		k := reflect.New(t.Key()).Elem()
+
+		if err := d.decode(k); err != nil {
+			return err
+		}
+
+		e := reflect.New(t.Elem()).Elem()
+
+		if err := d.decode(e); err != nil {
+			return err
+		}
+
+		v.SetMapIndex(k, e)
+	}
+
+	return nil
+}
+
+func (d *decoder) decodeStruct(h header, v reflect.Value) error {
+	if h.kind != kindMap {
+		return d.mismatch(h, v)
+	}
+
+	fs := fields(v.Type())
+
+	for i := 0; i < h.n; i++ {
+		var name string
+
+		if err := d.decode(reflect.ValueOf(&name).Elem()); err != nil {
+			return err
+		}
+
+		f, ok := findField(fs, name)
+
+		if !ok {
+			vh, err := d.header()
+
+			if err == nil {
+				err = d.skip(vh)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		fv, err := settableField(v, f.index)
+
+		if err != nil {
+			return err
+		}
+
+		if err := d.decode(fv); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findField prefers an exact match, but accepts a case-insensitive one, like encoding/json.
+func findField(fs []field, name string) (field, bool) {
+	for _, f := range fs {
+		if f.name == name {
+			return f, true
+		}
+	}
+
+	for _, f := range fs {
+		if strings.EqualFold(f.name, name) {
+			return f, true
+		}
+	}
+
+	return field{}, false
+}
+
+// settableField allocates the nil pointers to embedded structs on the way.
+func settableField(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return v, errors.New("msgpack: cannot set embedded pointer to unexported struct")
+				}
+
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+
+			v = v.Elem()
+		}
+
+		v = v.Field(x)
+	}
+
+	return v, nil
+}
+
+// decodeAny decodes values into the same types as encoding/json,
+// except for integers, which are int64, or uint64 when they don't fit, and binary values, which are []byte.
+func (d *decoder) decodeAny(h header) (interface{}, error) {
+	switch h.kind {
+	case kindNil, kindExt:
+		return nil, d.skip(h)
+	case kindBool:
+		return h.b, nil
+	case kindInt:
+		return h.i, nil
+	case kindUint:
+		if h.u <= math.MaxInt64 {
+			return int64(h.u), nil
+		}
+
+		return h.u, nil
+	case kindFloat:
+		return h.f, nil
+	case kindString:
+		b, err := d.next(h.n)
+		return string(b), err
+	case kindBinary:
+		b, err := d.next(h.n)
+		return append([]byte{}, b...), err
+	case kindArray:
+		var a = make([]interface{}, h.n)
+
+		for i := range a {
+			eh, err := d.header()
+
+			if err != nil {
+				return nil, err
+			}
+
+			if a[i], err = d.decodeAny(eh); err != nil {
+				return nil, err
+			}
+		}
+
+		return a, nil
+	}
+
+	var m = make(map[string]interface{}, h.n)
+
+	for i := 0; i < h.n; i++ {
+		kh, err := d.header()
+
+		if err != nil {
+			return nil, err
+		}
+
+		k, err := d.decodeAny(kh)
+
+		if err != nil {
+			return nil, err
+		}
+
+		vh, err := d.header()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if m[fmt.Sprint(k)], err = d.decodeAny(vh); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
@@ -0,0 +1,329 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// Marshal returns the MessagePack encoding of v.
+func Marshal(v interface{}) ([]byte, error) {
+	var e encoder
+
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+
+	return e.buf, nil
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.buf = append(e.buf, codeNil)
+		return nil
+	}
+
+	switch v.Type() {
+	case rawMessageType:
+		if v.Len() == 0 {
+			e.buf = append(e.buf, codeNil)
+			return nil
+		}
+
+		e.buf = append(e.buf, v.Bytes()...)
+		return nil
+	case jsonNumberType:
+		return e.encodeNumber(json.Number(v.String()))
+	case jsonRawMessageType:
+		return e.encodeJSON(v.Bytes())
+	}
+
+	if v.Kind() != reflect.Ptr && v.Kind() != reflect.Interface && v.Type().Implements(textMarshalerType) {
+		text, err := v.Interface().(interface{ MarshalText() ([]byte, error) }).MarshalText()
+
+		if err != nil {
+			return err
+		}
+
+		e.encodeString(string(text))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, codeTrue)
+		} else {
+			e.buf = append(e.buf, codeFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.encodeUint(v.Uint())
+	case reflect.Float32:
+		e.buf = append(e.buf, codeFloat32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.encodeFloat(v.Float())
+	case reflect.String:
+		e.encodeString(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.buf = append(e.buf, codeNil)
+			return nil
+		}
+
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.encodeBinary(v.Bytes())
+			return nil
+		}
+
+		return e.encodeArray(v)
+	case reflect.Array:
+		return e.encodeArray(v)
+	case reflect.Map:
+		return e.encodeMap(v)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.buf = append(e.buf, codeNil)
+			return nil
+		}
+
+		return e.encode(v.Elem())
+	default:
+		return fmt.Errorf("msgpack: unsupported type %v", v.Type())
+	}
+
+	return nil
+}
+
+func (e *encoder) encodeInt(i int64) {
+	switch {
+	case i >= 0:
+		e.encodeUint(uint64(i))
+	case i >= -32:
+		e.buf = append(e.buf, byte(i))
+	case i >= math.MinInt8:
+		e.buf = append(e.buf, codeInt8, byte(i))
+	case i >= math.MinInt16:
+		e.buf = append(e.buf, codeInt16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(i))
+	case i >= math.MinInt32:
+		e.buf = append(e.buf, codeInt32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(i))
+	default:
+		e.buf = append(e.buf, codeInt64)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(i))
+	}
+}
+
+func (e *encoder) encodeUint(u uint64) {
+	switch {
+	case u <= math.MaxInt8:
+		e.buf = append(e.buf, byte(u))
+	case u <= math.MaxUint8:
+		e.buf = append(e.buf, codeUint8, byte(u))
+	case u <= math.MaxUint16:
+		e.buf = append(e.buf, codeUint16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(u))
+	case u <= math.MaxUint32:
+		e.buf = append(e.buf, codeUint32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(u))
+	default:
+		e.buf = append(e.buf, codeUint64)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, u)
+	}
+}
+
+func (e *encoder) encodeFloat(f float64) {
+	e.buf = append(e.buf, codeFloat64)
+	e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(f))
+}
+
+// encodeNumber encodes integers like the JavaScript encoders do, and other numbers as floats.
+func (e *encoder) encodeNumber(n json.Number) error {
+	if i, err := n.Int64(); err == nil {
+		e.encodeInt(i)
+		return nil
+	}
+
+	f, err := n.Float64()
+
+	if err != nil {
+		return err
+	}
+
+	e.encodeFloat(f)
+	return nil
+}
+
+// encodeJSON converts a JSON value.
+func (e *encoder) encodeJSON(data []byte) error {
+	if len(data) == 0 {
+		e.buf = append(e.buf, codeNil)
+		return nil
+	}
+
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	if err := d.Decode(&v); err != nil {
+		return err
+	}
+
+	return e.encode(reflect.ValueOf(v))
+}
+
+func (e *encoder) encodeString(s string) {
+	switch l := len(s); {
+	case l < 32:
+		e.buf = append(e.buf, 0xa0|byte(l))
+	case l <= math.MaxUint8:
+		e.buf = append(e.buf, codeStr8, byte(l))
+	case l <= math.MaxUint16:
+		e.buf = append(e.buf, codeStr16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(l))
+	default:
+		e.buf = append(e.buf, codeStr32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(l))
+	}
+
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) encodeBinary(b []byte) {
+	switch l := len(b); {
+	case l <= math.MaxUint8:
+		e.buf = append(e.buf, codeBin8, byte(l))
+	case l <= math.MaxUint16:
+		e.buf = append(e.buf, codeBin16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(l))
+	default:
+		e.buf = append(e.buf, codeBin32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(l))
+	}
+
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) encodeArrayHeader(l int) {
+	switch {
+	case l < 16:
+		e.buf = append(e.buf, 0x90|byte(l))
+	case l <= math.MaxUint16:
+		e.buf = append(e.buf, codeArray16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(l))
+	default:
+		e.buf = append(e.buf, codeArray32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(l))
+	}
+}
+
+func (e *encoder) encodeMapHeader(l int) {
+	switch {
+	case l < 16:
+		e.buf = append(e.buf, 0x80|byte(l))
+	case l <= math.MaxUint16:
+		e.buf = append(e.buf, codeMap16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(l))
+	default:
+		e.buf = append(e.buf, codeMap32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(l))
+	}
+}
+
+func (e *encoder) encodeArray(v reflect.Value) error {
+	e.encodeArrayHeader(v.Len())
+
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodeMap sorts the keys, like encoding/json does, so the output is deterministic.
+func (e *encoder) encodeMap(v reflect.Value) error {
+	if v.IsNil() {
+		e.buf = append(e.buf, codeNil)
+		return nil
+	}
+
+	keys := v.MapKeys()
+
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+
+	e.encodeMapHeader(len(keys))
+
+	for _, k := range keys {
+		if err := e.encode(k); err != nil {
+			return err
+		}
+
+		if err := e.encode(v.MapIndex(k)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *encoder) encodeStruct(v reflect.Value) error {
+	var values []reflect.Value
+	var names []string
+
+	for _, f := range fields(v.Type()) {
+		fv, ok := fieldByIndex(v, f.index)
+
+		if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+			continue
+		}
+
+		names = append(names, f.name)
+		values = append(values, fv)
+	}
+
+	e.encodeMapHeader(len(values))
+
+	for i, fv := range values {
+		e.encodeString(names[i])
+
+		if err := e.encode(fv); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fieldByIndex returns false if the field is promoted through a nil pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+
+			v = v.Elem()
+		}
+
+		v = v.Field(x)
+	}
+
+	return v, true
+}
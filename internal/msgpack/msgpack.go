@@ -0,0 +1,177 @@
+// Package msgpack implements the MessagePack format, as used by socket.io-msgpack-parser.
+// Values are mapped like encoding/json does, using the msgpack struct tag, or the json one as a fallback.
+package msgpack
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// RawMessage is a raw encoded MessagePack value.
+type RawMessage []byte
+
+var (
+	// ErrUnexpectedEnd is returned when the data ends in the middle of a value.
+	ErrUnexpectedEnd = errors.New("msgpack: unexpected end of data")
+
+	// ErrTrailingData is returned when there is data after the value.
+	ErrTrailingData = errors.New("msgpack: trailing data after value")
+
+	// ErrMaxDepth is returned when decoding values nested too deeply.
+	ErrMaxDepth = errors.New("msgpack: exceeded max depth")
+
+	rawMessageType      = reflect.TypeOf(RawMessage(nil))
+	jsonNumberType      = reflect.TypeOf(json.Number(""))
+	jsonRawMessageType  = reflect.TypeOf(json.RawMessage(nil))
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// Format codes.
+const (
+	codeNil      = 0xc0
+	codeFalse    = 0xc2
+	codeTrue     = 0xc3
+	codeBin8     = 0xc4
+	codeBin16    = 0xc5
+	codeBin32    = 0xc6
+	codeExt8     = 0xc7
+	codeExt16    = 0xc8
+	codeExt32    = 0xc9
+	codeFloat32  = 0xca
+	codeFloat64  = 0xcb
+	codeUint8    = 0xcc
+	codeUint16   = 0xcd
+	codeUint32   = 0xce
+	codeUint64   = 0xcf
+	codeInt8     = 0xd0
+	codeInt16    = 0xd1
+	codeInt32    = 0xd2
+	codeInt64    = 0xd3
+	codeFixExt1  = 0xd4
+	codeFixExt16 = 0xd8
+	codeStr8     = 0xd9
+	codeStr16    = 0xda
+	codeStr32    = 0xdb
+	codeArray16  = 0xdc
+	codeArray32  = 0xdd
+	codeMap16    = 0xde
+	codeMap32    = 0xdf
+)
+
+// UnmarshalTypeError describes a value that can't be stored in a Go value of a given type.
+type UnmarshalTypeError struct {
+	Value string
+	Type  reflect.Type
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	return fmt.Sprintf("msgpack: cannot unmarshal %s into Go value of type %v", e.Value, e.Type)
+}
+
+// field of a struct.
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+var fieldsCache sync.Map
+
+// fields of a struct type, including the promoted ones from embedded structs.
+func fields(t reflect.Type) []field {
+	if f, ok := fieldsCache.Load(t); ok {
+		return f.([]field)
+	}
+
+	var list = appendFields(nil, t, nil)
+	fieldsCache.Store(t, list)
+	return list
+}
+
+func appendFields(list []field, t reflect.Type, index []int) []field {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, opts := tag(sf)
+
+		if name == "-" && opts == "" {
+			continue
+		}
+
+		idx := append(append([]int{}, index...), i)
+
+		if sf.Anonymous && name == "" {
+			ft := sf.Type
+
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+
+			if ft.Kind() == reflect.Struct {
+				list = appendFields(list, ft, idx)
+				continue
+			}
+		}
+
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = sf.Name
+		}
+
+		list = append(list, field{
+			name:      name,
+			index:     idx,
+			omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+		})
+	}
+
+	return list
+}
+
+func tag(sf reflect.StructField) (name, opts string) {
+	t, ok := sf.Tag.Lookup("msgpack")
+
+	if !ok {
+		t = sf.Tag.Get("json")
+	}
+
+	if i := strings.IndexByte(t, ','); i != -1 {
+		return t[:i], t[i+1:]
+	}
+
+	return t, ""
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+
+	return false
+}
+
+// Split an encoded array into its encoded elements.
+func Split(data []byte) ([]RawMessage, error) {
+	var elems []RawMessage
+	err := Unmarshal(data, &elems)
+	return elems, err
+}
@@ -0,0 +1,229 @@
+package msgpack
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var encodeCases = []struct {
+	value interface{}
+	want  string
+}{
+	{nil, "c0"},
+	{true, "c3"},
+	{false, "c2"},
+	{0, "00"},
+	{127, "7f"},
+	{128, "cc80"},
+	{256, "cd0100"},
+	{65536, "ce00010000"},
+	{uint64(math.MaxUint64), "cfffffffffffffffff"},
+	{-1, "ff"},
+	{-32, "e0"},
+	{-33, "d0df"},
+	{-129, "d1ff7f"},
+	{-32769, "d2ffff7fff"},
+	{int64(math.MinInt64), "d38000000000000000"},
+	{1.5, "cb3ff8000000000000"},
+	{float32(1.5), "ca3fc00000"},
+	{"", "a0"},
+	{"a", "a161"},
+	{strings.Repeat("a", 32), "d920" + strings.Repeat("61", 32)},
+	{[]byte{1, 2}, "c4020102"},
+	{[]interface{}{1, "a"}, "9201a161"},
+	{map[string]int{"b": 2, "a": 1}, "82a16101a16202"},
+	{json.Number("42"), "2a"},
+	{json.Number("0.5"), "cb3fe0000000000000"},
+	{json.RawMessage(`{"a":[1,null]}`), "81a1619201c0"},
+	{RawMessage{0xc3}, "c3"},
+	{[]int(nil), "c0"},
+	{time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), "b4" + hex.EncodeToString([]byte("2020-01-02T03:04:05Z"))},
+}
+
+func TestMarshal(t *testing.T) {
+	for _, c := range encodeCases {
+		got, err := Marshal(c.value)
+
+		if err != nil {
+			t.Errorf("Expected no error marshaling %#v, got %v instead", c.value, err)
+			continue
+		}
+
+		if h := hex.EncodeToString(got); h != c.want {
+			t.Errorf("Expected %#v to be encoded as %v, got %v instead", c.value, c.want, h)
+		}
+	}
+}
+
+type embedded struct {
+	Room string `json:"room"`
+}
+
+type payload struct {
+	embedded
+	Name    string            `json:"name"`
+	Count   int               `msgpack:"n" json:"count"`
+	Tags    []string          `json:"tags,omitempty"`
+	Meta    map[string]string `json:"meta"`
+	Ignored string            `json:"-"`
+	Next    *payload          `json:"next,omitempty"`
+	private int
+}
+
+func TestStructRoundTrip(t *testing.T) {
+	in := payload{
+		embedded: embedded{"lobby"},
+		Name:     "Alice",
+		Count:    3,
+		Meta:     map[string]string{"k": "v"},
+		Ignored:  "x",
+		Next:     &payload{Name: "Bob"},
+	}
+
+	b, err := Marshal(in)
+
+	if err != nil {
+		t.Fatalf("Expected no error marshaling, got %v instead", err)
+	}
+
+	var out payload
+
+	if err := Unmarshal(b, &out); err != nil {
+		t.Fatalf("Expected no error unmarshaling, got %v instead", err)
+	}
+
+	in.Ignored = ""
+	in.Next.Meta = map[string]string(nil)
+
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("Expected %+v, got %+v instead", in, out)
+	}
+
+	var generic map[string]interface{}
+
+	if err := Unmarshal(b, &generic); err != nil {
+		t.Fatalf("Expected no error unmarshaling into a map, got %v instead", err)
+	}
+
+	if generic["n"] != int64(3) || generic["room"] != "lobby" {
+		t.Errorf("Expected field names from tags, got %v instead", generic)
+	}
+
+	if _, ok := generic["tags"]; ok {
+		t.Error("Expected empty field to be omitted")
+	}
+}
+
+func TestUnmarshalAny(t *testing.T) {
+	b, _ := hex.DecodeString("93a1610ccb3ff8000000000000")
+	var got interface{}
+
+	if err := Unmarshal(b, &got); err != nil {
+		t.Fatalf("Expected no error unmarshaling, got %v instead", err)
+	}
+
+	if want := []interface{}{"a", int64(12), 1.5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v instead", want, got)
+	}
+}
+
+func TestUnmarshalConversions(t *testing.T) {
+	var f float64
+
+	if err := Unmarshal([]byte{0x05}, &f); err != nil || f != 5 {
+		t.Errorf("Expected integer to be decoded into float, got %v (%v) instead", f, err)
+	}
+
+	var u uint8
+
+	if err := Unmarshal([]byte{0xcd, 0x01, 0x00}, &u); err == nil {
+		t.Error("Expected error decoding overflowing integer")
+	}
+
+	var s string
+
+	if err := Unmarshal([]byte{0x05}, &s); err == nil {
+		t.Error("Expected error decoding integer into string")
+	} else if _, ok := err.(*UnmarshalTypeError); !ok {
+		t.Errorf("Expected UnmarshalTypeError, got %T instead", err)
+	}
+
+	// undefined, as encoded by notepack.io
+	var p = &s
+
+	if err := Unmarshal([]byte{0xd4, 0x00, 0x00}, &p); err != nil || p != nil {
+		t.Errorf("Expected extension to be decoded as nil, got %v (%v) instead", p, err)
+	}
+
+	var tm time.Time
+	b, _ := Marshal("2020-01-02T03:04:05Z")
+
+	if err := Unmarshal(b, &tm); err != nil || tm.Year() != 2020 {
+		t.Errorf("Expected text to be decoded with UnmarshalText, got %v (%v) instead", tm, err)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	var v interface{}
+
+	for _, data := range []string{"", "92", "a2", "cd01", "c1", "dc0001", "c0c0"} {
+		b, _ := hex.DecodeString(data)
+
+		if err := Unmarshal(b, &v); err == nil {
+			t.Errorf("Expected error unmarshaling %v", data)
+		}
+	}
+
+	if err := Unmarshal([]byte{0xc0}, v); err == nil {
+		t.Error("Expected error unmarshaling into non-pointer")
+	}
+}
+
+func TestUnmarshalMaxDepth(t *testing.T) {
+	var v interface{}
+
+	// arrays of one element, nested
+	deep := bytes.Repeat([]byte{0x91}, maxDepth+1)
+	deep = append(deep, 0xc0)
+
+	if err := Unmarshal(deep, &v); err != ErrMaxDepth {
+		t.Errorf("Expected error to be %v, got %v instead", ErrMaxDepth, err)
+	}
+
+	if err := Unmarshal(deep[1:], &v); err != nil {
+		t.Errorf("Expected no error unmarshaling, got %v instead", err)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	b, _ := Marshal([]interface{}{"event", map[string]int{"a": 1}, []int{1, 2}})
+	parts, err := Split(b)
+
+	if err != nil {
+		t.Fatalf("Expected no error splitting, got %v instead", err)
+	}
+
+	var want = []string{"a56576656e74", "81a16101", "920102"}
+
+	if len(parts) != len(want) {
+		t.Fatalf("Expected %d parts, got %d instead", len(want), len(parts))
+	}
+
+	for i, p := range parts {
+		if h := hex.EncodeToString(p); h != want[i] {
+			t.Errorf("Expected part %d to be %v, got %v instead", i, want[i], h)
+		}
+	}
+
+	joined, _ := Marshal(parts)
+
+	if !bytes.Equal(joined, b) {
+		t.Errorf("Expected raw parts to be encoded as they are, got %x instead", joined)
+	}
+}
@@ -1,197 +1,137 @@
 package protocol
 
 import (
-	"fmt"
-	"strconv"
+	"encoding/json"
 	"strings"
+
+	sio "github.com/wedeploy/gosocketio/protocol"
 )
 
-// Decode message.
+// Decode a text packet, following the packet format of the public protocol package.
 func Decode(source []byte) (msg *Message, err error) {
-	data := string(source)
-
-	msg = &Message{
-		Source: data,
-	}
-
-	if len(data) == 0 {
+	if len(source) == 0 {
 		return nil, ErrorWrongMessageType
 	}
 
-	var majorType = data[0:1]
+	var p sio.Packet
 
-	if majorType == OpenMessage {
-		msg.Type = MessageTypeOpen
-
-		if len(data) > 1 {
-			msg.Data = []byte(data[1:])
-		}
-
-		return msg, nil
+	if err := p.UnmarshalText(source); err != nil {
+		return nil, fromPacketError(err, ErrorWrongPacket)
 	}
 
-	msg.Type, err = getMessageType(data)
-
-	if err != nil {
-		return nil, err
-	}
-
-	if msg.Type == MessageTypeEmpty {
-		msg.Method = OnConnection
-	}
-
-	if len(data) > 2 {
-		data = data[2:]
-		msg.Namespace, data = extractNamespace(data)
-	}
-
-	switch msg.Type {
-	case MessageTypeClose,
-		MessageTypePing,
-		MessageTypePong,
-		MessageTypeEmpty,
-		MessageTypeError:
-		return msg, nil
-	}
-
-	if majorType != RegularMessage {
-		return nil, fmt.Errorf("can't decode message type %v", majorType)
+	msg = &Message{
+		Source: string(source),
+		Data:   p.Data,
 	}
 
-	if msg.Type == MessageTypeAckResponse {
-		ack, rest, err := getAckFromPacket(data)
-
-		if err != nil {
+	switch p.EngineType {
+	case sio.EngineOpen:
+		msg.Type = MessageTypeOpen
+	case sio.EngineClose:
+		msg.Type = MessageTypeClose
+	case sio.EnginePing:
+		msg.Type = MessageTypePing
+	case sio.EnginePong:
+		msg.Type = MessageTypePong
+	case sio.EngineMessage:
+		if err := decodePacket(msg, &p); err != nil {
 			return nil, err
 		}
-
-		msg.AckID = ack
-		msg.Data = []byte(rest)
-		return msg, nil
+	default:
+		return nil, ErrorWrongMessageType
 	}
 
-	msg.Type = MessageTypeEmit
-	msg.Method, msg.Data, err = decodePacket(data)
-
-	return msg, err
+	return msg, nil
 }
 
-func extractNamespace(data string) (namespace string, rest string) {
-	var pos int
-
-	if len(data) == 0 {
-		return "", ""
+// fromPacketError converts the errors of the public protocol package.
+func fromPacketError(err, invalid error) error {
+	switch err {
+	case sio.ErrUnknownPacketType:
+		return ErrorWrongMessageType
+	case sio.ErrInvalidPacket:
+		return invalid
 	}
 
-	for i, c := range data {
-		if c == ',' {
-			pos = i
-			break
-		}
+	return err
+}
 
-		if c == '"' {
-			return "", data
-		}
+// decodePacket into a message of the socket.io packet carried by an Engine.IO message.
+func decodePacket(msg *Message, p *sio.Packet) (err error) {
+	if p.Namespace != sio.DefaultNamespace {
+		msg.Namespace = p.Namespace
 	}
 
-	namespace = data[0:pos]
+	switch p.Type {
+	case sio.Connect:
+		msg.Type = MessageTypeEmpty
+		msg.Method = OnConnection
+	case sio.Disconnect:
+		msg.Type = MessageTypeDisconnect
+	case sio.ConnectError:
+		msg.Type = MessageTypeError
+	case sio.Ack:
+		msg.Type = MessageTypeAckResponse
+		msg.AckID = p.AckID
+	case sio.Event:
+		msg.Type = MessageTypeEmit
+
+		if p.HasAckID {
+			msg.Type = MessageTypeAckRequest
+			msg.AckID = p.AckID
+		}
 
-	if len(data) > pos+1 {
-		rest = data[pos+1:]
+		msg.Method, msg.Data, err = decodeEvent(string(p.Data))
+	default:
+		// binary attachments aren't supported
+		return ErrorWrongMessageType
 	}
 
-	return namespace, rest
+	return err
 }
 
-func getMessageType(data string) (string, error) {
-	switch data[0:1] {
-	case OpenMessage:
-		return MessageTypeOpen, nil
-	case CloseMessage:
-		return MessageTypeClose, nil
-	case PingMessage:
-		return MessageTypePing, nil
-	case PongMessage:
-		return MessageTypePong, nil
-	case RegularMessage:
-		return getRegularMessageType(data)
-	}
+const whitespace = " \t\r\n"
 
-	return "", ErrorWrongMessageType
+func isArray(data string) bool {
+	return strings.HasPrefix(strings.TrimLeft(data, whitespace), "[") && json.Valid([]byte(data))
 }
 
-func getRegularMessageType(data string) (string, error) {
-	if len(data) == 1 {
-		return "", ErrorWrongMessageType
+// decodeEvent splits the event name from its arguments.
+// The event name is the first element of the payload array, either a string or a number.
+func decodeEvent(payload string) (method string, args []byte, err error) {
+	if !isArray(payload) {
+		return "", nil, ErrorWrongPacket
 	}
 
-	switch data[0:2] {
-	case NamespaceClose:
-		return MessageTypeClose, nil
-	case EmptyMessage:
-		return MessageTypeEmpty, nil
-	case CommonMessage:
-		return MessageTypeAckRequest, nil
-	case AckMessage:
-		return MessageTypeAckResponse, nil
-	case ErrorMessage:
-		return MessageTypeError, nil
-	}
-
-	return "", ErrorWrongMessageType
-}
+	dec := json.NewDecoder(strings.NewReader(payload))
+	dec.UseNumber()
 
-func getAckFromPacket(text string) (ackID int, restText string, err error) {
-	if len(text) < 2 {
-		return 0, "", ErrorWrongPacket
+	// skip the opening bracket, already checked by isArray
+	if _, err := dec.Token(); err != nil {
+		return "", nil, ErrorWrongPacket
 	}
 
-	pos := strings.IndexByte(text, '[')
+	var name interface{}
 
-	if pos == -1 {
-		return 0, "", ErrorWrongPacket
+	if !dec.More() || dec.Decode(&name) != nil {
+		return "", nil, ErrorWrongPacket
 	}
 
-	ack, err := strconv.Atoi(text[0:pos])
-	if err != nil {
-		return 0, "", err
+	switch n := name.(type) {
+	case string:
+		method = n
+	case json.Number:
+		method = n.String()
+	default:
+		return "", nil, ErrorWrongPacket
 	}
 
-	return ack, text[pos:], nil
-}
-
-func decodePacket(input string) (method string, packet []byte, err error) {
-	var start, end, rest, countQuote int
-
-	for i, c := range input {
-		if c == '"' {
-			switch countQuote {
-			case 0:
-				start = i + 1
-			case 1:
-				end = i
-				rest = i + 1
-			default:
-				return "", []byte(""), ErrorWrongPacket
-			}
-
-			countQuote++
-		}
-
-		if c == ',' {
-			if countQuote < 2 {
-				continue
-			}
-
-			rest = i + 1
-			break
-		}
-	}
+	rest := strings.TrimLeft(payload[dec.InputOffset():], whitespace)
 
-	if (end < start) || (rest >= len(input)) {
-		return "", []byte(""), ErrorWrongPacket
+	if rest[0] == ']' {
+		return method, []byte("[]"), nil
 	}
 
-	b := append([]byte("["), []byte(input[rest:])...)
-	return input[start:end], b, nil
+	// rest starts with the comma separating the arguments
+	return method, []byte("[" + rest[1:]), nil
 }
@@ -1,10 +1,76 @@
 package protocol
 
 import (
+	"encoding/json"
+	"os"
 	"reflect"
 	"testing"
 )
 
+// fixture is a packet captured from a socket.io server and how it should be decoded.
+type fixture struct {
+	Server string `json:"server"`
+	Packet string `json:"packet"`
+
+	Type      string  `json:"type"`
+	Namespace string  `json:"namespace"`
+	Method    string  `json:"method"`
+	AckID     int     `json:"ackID"`
+	Data      *string `json:"data"`
+
+	Error string `json:"error"`
+}
+
+func TestDecodeFixtures(t *testing.T) {
+	b, err := os.ReadFile("testdata/packets.json")
+
+	if err != nil {
+		t.Fatalf("Expected no error reading fixtures, got %v instead", err)
+	}
+
+	var fixtures []fixture
+
+	if err := json.Unmarshal(b, &fixtures); err != nil {
+		t.Fatalf("Expected no error decoding fixtures, got %v instead", err)
+	}
+
+	for _, f := range fixtures {
+		m, err := Decode([]byte(f.Packet))
+
+		if f.Error != "" {
+			if err == nil || err.Error() != f.Error {
+				t.Errorf("Expected error decoding %s packet %q to be %v, got %v instead", f.Server, f.Packet, f.Error, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Expected no error decoding %s packet %q, got %v instead", f.Server, f.Packet, err)
+			continue
+		}
+
+		var data []byte
+
+		if f.Data != nil {
+			data = []byte(*f.Data)
+		}
+
+		want := Message{
+			Namespace: f.Namespace,
+			Method:    f.Method,
+			Type:      f.Type,
+			AckID:     f.AckID,
+			Data:      data,
+			Source:    f.Packet,
+		}
+
+		if !reflect.DeepEqual(want, *m) {
+			t.Errorf("Expected %s packet %q to be decoded as %+v, got %+v instead", f.Server, f.Packet, want, *m)
+		}
+	}
+}
+
 func TestDecodeEmptyMessage(t *testing.T) {
 	m, err := Decode([]byte(""))
 
@@ -54,3 +120,68 @@ func TestDecodeEmptyMessageWithNamespace(t *testing.T) {
 		t.Errorf("Expected type to be %v, got %v instead", wantNamespace, m.Namespace)
 	}
 }
+
+func TestDecodeNamespaceDisconnect(t *testing.T) {
+	m, err := Decode([]byte(`41/shell`))
+
+	if err != nil {
+		t.Errorf("Expected error to be nil, got %v instead", err)
+	}
+
+	if m.Type != MessageTypeDisconnect {
+		t.Errorf("Expected type to be %v, got %v instead", MessageTypeDisconnect, m.Type)
+	}
+
+	if m.Namespace != "/shell" {
+		t.Errorf("Expected namespace to be %v, got %v instead", "/shell", m.Namespace)
+	}
+}
+
+func TestDecodeDefaultNamespaceDisconnect(t *testing.T) {
+	m, err := Decode([]byte(`41`))
+
+	if err != nil {
+		t.Errorf("Expected error to be nil, got %v instead", err)
+	}
+
+	if m.Type != MessageTypeDisconnect {
+		t.Errorf("Expected type to be %v, got %v instead", MessageTypeDisconnect, m.Type)
+	}
+
+	if m.Namespace != "" {
+		t.Errorf("Expected namespace to be empty, got %v instead", m.Namespace)
+	}
+}
+
+func TestDecodeErrorWithNamespace(t *testing.T) {
+	m, err := Decode([]byte(`44/admin,{"message":"Not authorized"}`))
+
+	if err != nil {
+		t.Errorf("Expected error to be nil, got %v instead", err)
+	}
+
+	if m.Type != MessageTypeError {
+		t.Errorf("Expected type to be %v, got %v instead", MessageTypeError, m.Type)
+	}
+
+	if m.Namespace != "/admin" {
+		t.Errorf("Expected namespace to be %v, got %v instead", "/admin", m.Namespace)
+	}
+
+	if want := `{"message":"Not authorized"}`; string(m.Data) != want {
+		t.Errorf("Expected data to be %v, got %v instead", want, string(m.Data))
+	}
+}
+
+// FuzzDecode checks that decoding never panics, whatever the server sends.
+func FuzzDecode(f *testing.F) {
+	addFixtures(f)
+
+	f.Fuzz(func(t *testing.T, packet string) {
+		m, err := Decode([]byte(packet))
+
+		if (m == nil) == (err == nil) {
+			t.Errorf("Expected either a message or an error decoding %q, got %+v and %v instead", packet, m, err)
+		}
+	})
+}
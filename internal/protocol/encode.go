@@ -2,11 +2,20 @@ package protocol
 
 import (
 	"encoding/json"
-	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	sio "github.com/wedeploy/gosocketio/protocol"
 )
 
-// Encode message.
+// Encode message, marshaling its arguments as JSON.
+// Without arguments, the payload is msg.Data, so that decoded messages are encoded back as they were.
 func Encode(msg *Message, args ...interface{}) (packet string, err error) {
+	return encode(msg, json.Marshal, args...)
+}
+
+// encode a message in the text format, marshaling the payload with the given function.
+func encode(msg *Message, marshal func(v interface{}) ([]byte, error), args ...interface{}) (packet string, err error) {
 	// preventing json/encoding "index out of range" panic
 	defer func() {
 		if r := recover(); r != nil && err == nil {
@@ -14,70 +23,107 @@ func Encode(msg *Message, args ...interface{}) (packet string, err error) {
 		}
 	}()
 
-	result, err := typeToText(msg.Type)
+	var p = sio.Packet{
+		EngineType: sio.EngineMessage,
+		Namespace:  msg.Namespace,
+	}
+
+	switch msg.Type {
+	case MessageTypeOpen:
+		p.EngineType, p.Data = sio.EngineOpen, msg.Data
+	case MessageTypeClose:
+		p.EngineType, p.Data = sio.EngineClose, msg.Data
+	case MessageTypePing:
+		p.EngineType, p.Data = sio.EnginePing, msg.Data
+	case MessageTypePong:
+		p.EngineType, p.Data = sio.EnginePong, msg.Data
+	case MessageTypeEmpty:
+		p.Type = sio.Connect
+		p.Data, err = encodeData(msg.Data, marshal, args...)
+	case MessageTypeDisconnect:
+		p.Type = sio.Disconnect
+	case MessageTypeError:
+		p.Type = sio.ConnectError
+		p.Data, err = encodeData(msg.Data, marshal, args...)
+	case MessageTypeEmit, MessageTypeAckRequest:
+		p.Type = sio.Event
+		p.AckID, p.HasAckID = msg.AckID, msg.Type == MessageTypeAckRequest
+		p.Data, err = encodeEvent(msg.Method, msg.Data, marshal, args...)
+	case MessageTypeAckResponse:
+		p.Type = sio.Ack
+		p.AckID, p.HasAckID = msg.AckID, true
+		p.Data, err = encodeArgs(msg.Data, marshal, args...)
+	default:
+		return "", ErrorWrongMessageType
+	}
+
 	if err != nil {
 		return "", err
 	}
 
-	if msg.Type == MessageTypeEmpty || msg.Type == MessageTypePing ||
-		msg.Type == MessageTypePong {
-		return result, nil
+	text, err := p.MarshalText()
+
+	if err != nil {
+		return "", fromPacketError(err, ErrorInvalidMessage)
 	}
 
-	if msg.Type == MessageTypeAckRequest || msg.Type == MessageTypeAckResponse {
-		result += fmt.Sprintf("%v", msg.AckID)
+	return string(text), nil
+}
+
+// encodeData encodes the payload of namespace connections and errors: the first argument, if any, or data.
+func encodeData(data []byte, marshal func(v interface{}) ([]byte, error), args ...interface{}) ([]byte, error) {
+	if len(args) != 0 {
+		return marshal(args[0])
 	}
 
-	if msg.Type == MessageTypeOpen || msg.Type == MessageTypeClose {
-		return fmt.Sprintf("%s%s", result, msg.Data), nil
+	return data, nil
+}
+
+// encodeArgs encodes the arguments of an ack response, or data if there are none.
+func encodeArgs(data []byte, marshal func(v interface{}) ([]byte, error), args ...interface{}) ([]byte, error) {
+	if args == nil && data != nil {
+		return data, nil
 	}
 
-	if msg.Type == MessageTypeAckResponse {
-		return fmt.Sprintf("%s%s", result, msg.Data), nil
+	if args == nil {
+		args = []interface{}{}
 	}
 
-	if msg.Type == MessageTypeNamespace {
-		return fmt.Sprintf("%s%s", result, msg.Method), nil
+	return marshal(&args)
+}
+
+// encodeEvent encodes the event name followed by its arguments, or by the arguments already encoded in data.
+func encodeEvent(method string, data []byte, marshal func(v interface{}) ([]byte, error), args ...interface{}) ([]byte, error) {
+	// JSON strings can't carry invalid UTF-8, so it wouldn't be decoded back as it is
+	if !utf8.ValidString(method) {
+		return nil, ErrorInvalidMessage
 	}
 
-	if args == nil {
-		return fmt.Sprintf(`%s%s,["%s"]`, result, msg.Namespace, msg.Method), nil
+	if args != nil {
+		args = append([]interface{}{method}, args...)
+		return marshal(&args)
 	}
 
-	args = append([]interface{}{msg.Method}, args...)
-	json, err := json.Marshal(&args)
+	name, err := json.Marshal(method)
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	var format = `%s%s,%s`
+	if data == nil {
+		return []byte("[" + string(name) + "]"), nil
+	}
 
-	if msg.Namespace == "" {
-		format = `%s%s%s`
+	if !isArray(string(data)) {
+		return nil, ErrorInvalidMessage
 	}
 
-	packet = fmt.Sprintf(format, result, msg.Namespace, json)
-	return packet, err
-}
+	// splice the name into the array of arguments
+	rest := strings.TrimLeft(string(data), whitespace)[1:]
 
-func typeToText(msgType string) (string, error) {
-	switch msgType {
-	case MessageTypeOpen:
-		return OpenMessage, nil
-	case MessageTypeClose:
-		return CloseMessage, nil
-	case MessageTypePing:
-		return PingMessage, nil
-	case MessageTypePong:
-		return PongMessage, nil
-	case MessageTypeEmpty, MessageTypeNamespace:
-		return EmptyMessage, nil
-	case MessageTypeEmit, MessageTypeAckRequest:
-		return CommonMessage, nil
-	case MessageTypeAckResponse:
-		return AckMessage, nil
+	if strings.TrimLeft(rest, whitespace)[0] == ']' {
+		return []byte("[" + string(name) + "]"), nil
 	}
 
-	return "", ErrorWrongMessageType
+	return []byte("[" + string(name) + "," + rest), nil
 }
@@ -0,0 +1,402 @@
+package protocol
+
+import (
+	"encoding/json"
+	"math/rand"
+	"os"
+	"reflect"
+	"testing"
+	"testing/quick"
+)
+
+func TestEncodeNamespace(t *testing.T) {
+	var cases = []struct {
+		namespace string
+		args      []interface{}
+		want      string
+	}{
+		{"/admin", nil, "40/admin"},
+		{"/admin?token=abc", nil, "40/admin?token=abc"},
+		{"/admin", []interface{}{map[string]string{"token": "abc"}}, `40/admin,{"token":"abc"}`},
+		{"", []interface{}{map[string]string{"token": "abc"}}, `40{"token":"abc"}`},
+	}
+
+	for _, c := range cases {
+		got, err := Encode(&Message{
+			Type:      MessageTypeEmpty,
+			Namespace: c.namespace,
+		}, c.args...)
+
+		if err != nil {
+			t.Errorf("Expected error to be nil, got %v instead", err)
+		}
+
+		if got != c.want {
+			t.Errorf("Expected packet to be %v, got %v instead", c.want, got)
+		}
+	}
+}
+
+func TestEncodeDisconnect(t *testing.T) {
+	got, err := Encode(&Message{
+		Type:      MessageTypeDisconnect,
+		Namespace: "/shell",
+	})
+
+	if err != nil {
+		t.Errorf("Expected error to be nil, got %v instead", err)
+	}
+
+	if got != "41/shell" {
+		t.Errorf("Expected packet to be %v, got %v instead", "41/shell", got)
+	}
+}
+
+func TestEncodeAck(t *testing.T) {
+	var cases = []struct {
+		msg  *Message
+		args []interface{}
+		want string
+	}{
+		{&Message{Type: MessageTypeAckRequest, AckID: 12, Method: "book"}, []interface{}{"JFK"}, `4212["book","JFK"]`},
+		{&Message{Type: MessageTypeAckRequest, AckID: 13, Namespace: "/chat", Method: "book"}, []interface{}{"JFK"}, `42/chat,13["book","JFK"]`},
+		{&Message{Type: MessageTypeAckResponse, AckID: 12}, []interface{}{"ok"}, `4312["ok"]`},
+		{&Message{Type: MessageTypeAckResponse, AckID: 13, Namespace: "/chat"}, nil, `43/chat,13[]`},
+	}
+
+	for _, c := range cases {
+		got, err := Encode(c.msg, c.args...)
+
+		if err != nil {
+			t.Errorf("Expected error to be nil, got %v instead", err)
+		}
+
+		if got != c.want {
+			t.Errorf("Expected packet to be %v, got %v instead", c.want, got)
+		}
+	}
+}
+
+func TestEncodeEscapesEventName(t *testing.T) {
+	var names = []string{`say "hi"`, `back\slash`, "a,b", "[]", "line\nbreak", "\u2028", "café"}
+
+	for _, name := range names {
+		got, err := Encode(&Message{
+			Type:   MessageTypeEmit,
+			Method: name,
+		})
+
+		if err != nil {
+			t.Errorf("Expected error to be nil, got %v instead", err)
+			continue
+		}
+
+		m, err := Decode([]byte(got))
+
+		if err != nil {
+			t.Errorf("Expected packet %v to be decoded, got %v instead", got, err)
+			continue
+		}
+
+		if m.Method != name {
+			t.Errorf("Expected method to be %q, got %q instead", name, m.Method)
+		}
+	}
+}
+
+func TestEncodeInvalidMessage(t *testing.T) {
+	var cases = []*Message{
+		{Type: MessageTypeEmit, Namespace: "/a,b", Method: "x"},
+		{Type: MessageTypeEmit, Namespace: "chat", Method: "x"},
+		{Type: MessageTypeEmit, Method: "\xff"},
+		{Type: MessageTypeEmit, Method: "x", Data: []byte(`{}`)},
+		{Type: MessageTypeAckRequest, AckID: -1, Method: "x"},
+		{Type: MessageTypeAckResponse, AckID: 1, Data: []byte(`"x"`)},
+		{Type: MessageTypeEmpty, Namespace: "/admin", Data: []byte(`{`)},
+	}
+
+	for _, c := range cases {
+		if got, err := Encode(c); err != ErrorInvalidMessage {
+			t.Errorf("Expected error encoding %+v to be %v, got %v (%v) instead", c, ErrorInvalidMessage, err, got)
+		}
+	}
+}
+
+// roundTrip is a message, the arguments to encode it with, and the message it should be decoded as.
+type roundTrip struct {
+	msg  Message
+	args []interface{}
+	want Message
+}
+
+const alphabet = "ab/,\"\\[]{}:09 é☃\n\x00\u2028<"
+
+func randomString(r *rand.Rand) string {
+	var runes = []rune(alphabet)
+	var s = make([]rune, r.Intn(8))
+
+	for i := range s {
+		s[i] = runes[r.Intn(len(runes))]
+	}
+
+	return string(s)
+}
+
+// randomNamespace, other than "/", which is decoded as the default namespace.
+func randomNamespace(r *rand.Rand) string {
+	if r.Intn(2) == 0 {
+		return ""
+	}
+
+	var ns []rune
+
+	for _, c := range randomString(r) {
+		if c != ',' {
+			ns = append(ns, c)
+		}
+	}
+
+	return "/x" + string(ns)
+}
+
+func randomArgs(r *rand.Rand) []interface{} {
+	var args []interface{}
+
+	for i := r.Intn(4); i > 0; i-- {
+		switch r.Intn(4) {
+		case 0:
+			args = append(args, randomString(r))
+		case 1:
+			args = append(args, r.NormFloat64())
+		case 2:
+			args = append(args, map[string]string{randomString(r): randomString(r)})
+		default:
+			args = append(args, nil)
+		}
+	}
+
+	return args
+}
+
+func marshalArgs(args []interface{}) []byte {
+	if args == nil {
+		args = []interface{}{}
+	}
+
+	b, _ := json.Marshal(args)
+	return b
+}
+
+func (roundTrip) Generate(r *rand.Rand, size int) reflect.Value {
+	var types = []string{
+		MessageTypeOpen,
+		MessageTypeClose,
+		MessageTypePing,
+		MessageTypePong,
+		MessageTypeEmpty,
+		MessageTypeDisconnect,
+		MessageTypeEmit,
+		MessageTypeAckRequest,
+		MessageTypeAckResponse,
+		MessageTypeError,
+	}
+
+	var rt roundTrip
+
+	rt.msg.Type = types[r.Intn(len(types))]
+
+	switch rt.msg.Type {
+	case MessageTypeOpen, MessageTypeClose, MessageTypePing, MessageTypePong:
+		if s := randomString(r); s != "" {
+			rt.msg.Data = []byte(s)
+		}
+
+		rt.want = rt.msg
+	case MessageTypeEmpty, MessageTypeError:
+		rt.msg.Namespace = randomNamespace(r)
+		rt.want = rt.msg
+
+		if rt.msg.Type == MessageTypeEmpty {
+			rt.want.Method = OnConnection
+		}
+
+		if r.Intn(2) == 0 {
+			arg := map[string]string{randomString(r): randomString(r)}
+			rt.args = []interface{}{arg}
+			rt.want.Data, _ = json.Marshal(arg)
+		}
+	case MessageTypeDisconnect:
+		rt.msg.Namespace = randomNamespace(r)
+		rt.want = rt.msg
+	case MessageTypeEmit, MessageTypeAckRequest, MessageTypeAckResponse:
+		rt.msg.Namespace = randomNamespace(r)
+
+		if rt.msg.Type != MessageTypeAckResponse {
+			rt.msg.Method = randomString(r)
+		}
+
+		if rt.msg.Type != MessageTypeEmit {
+			rt.msg.AckID = r.Intn(1 << 30)
+		}
+
+		rt.args = randomArgs(r)
+		rt.want = rt.msg
+		rt.want.Data = marshalArgs(rt.args)
+	}
+
+	return reflect.ValueOf(rt)
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	f := func(rt roundTrip) bool {
+		packet, err := Encode(&rt.msg, rt.args...)
+
+		if err != nil {
+			t.Errorf("Expected no error encoding %+v, got %v instead", rt.msg, err)
+			return false
+		}
+
+		m, err := Decode([]byte(packet))
+
+		if err != nil {
+			t.Errorf("Expected no error decoding %q, got %v instead", packet, err)
+			return false
+		}
+
+		m.Source = ""
+
+		if !reflect.DeepEqual(*m, rt.want) {
+			t.Errorf("Expected %q to be decoded as %+v, got %+v instead", packet, rt.want, *m)
+			return false
+		}
+
+		// decoded messages are encoded back as they were
+		again, err := Encode(m)
+
+		if err != nil || again != packet {
+			t.Errorf("Expected %+v to be encoded as %q, got %q (%v) instead", *m, packet, again, err)
+			return false
+		}
+
+		return true
+	}
+
+	if err := quick.Check(f, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func addFixtures(f *testing.F) {
+	b, err := os.ReadFile("testdata/packets.json")
+
+	if err != nil {
+		f.Fatalf("Expected no error reading fixtures, got %v instead", err)
+	}
+
+	var fixtures []fixture
+
+	if err := json.Unmarshal(b, &fixtures); err != nil {
+		f.Fatalf("Expected no error decoding fixtures, got %v instead", err)
+	}
+
+	for _, fx := range fixtures {
+		f.Add(fx.Packet)
+	}
+}
+
+// FuzzDecodeEncode checks that decoded packets are encoded into packets that decode to the same message.
+func FuzzDecodeEncode(f *testing.F) {
+	addFixtures(f)
+
+	f.Fuzz(func(t *testing.T, packet string) {
+		m, err := Decode([]byte(packet))
+
+		if err != nil {
+			return
+		}
+
+		encoded, err := Encode(m)
+
+		if err != nil {
+			t.Fatalf("Expected no error encoding %+v, got %v instead", *m, err)
+		}
+
+		got, err := Decode([]byte(encoded))
+
+		if err != nil {
+			t.Fatalf("Expected no error decoding %q, got %v instead", encoded, err)
+		}
+
+		m.Source, got.Source = "", ""
+
+		if !reflect.DeepEqual(*m, *got) {
+			t.Fatalf("Expected %q to be decoded as %+v, got %+v instead", encoded, *m, *got)
+		}
+	})
+}
+
+// FuzzEncodeDecode checks that encoded messages are decoded into messages that encode to the same packet.
+func FuzzEncodeDecode(f *testing.F) {
+	f.Add(uint8(6), "", "message", 0, []byte(`["hello"]`))
+	f.Add(uint8(7), "/chat", `say "hi"`, 12, []byte(nil))
+	f.Add(uint8(8), "/chat", "", 3, []byte(`[1,{"a":"]"}]`))
+	f.Add(uint8(4), "/admin?token=abc", "", 0, []byte(`{"token":"abc"}`))
+	f.Add(uint8(0), "", "", 0, []byte(`{"sid":"abc"}`))
+
+	var types = []string{
+		MessageTypeOpen,
+		MessageTypeClose,
+		MessageTypePing,
+		MessageTypePong,
+		MessageTypeEmpty,
+		MessageTypeDisconnect,
+		MessageTypeEmit,
+		MessageTypeAckRequest,
+		MessageTypeAckResponse,
+		MessageTypeError,
+	}
+
+	f.Fuzz(func(t *testing.T, typ uint8, namespace, method string, ackID int, data []byte) {
+		msg := &Message{
+			Type:      types[int(typ)%len(types)],
+			Namespace: namespace,
+			Method:    method,
+			AckID:     ackID,
+			Data:      data,
+		}
+
+		packet, err := Encode(msg)
+
+		if err != nil {
+			return
+		}
+
+		m, err := Decode([]byte(packet))
+
+		if err != nil {
+			t.Fatalf("Expected no error decoding %q, got %v instead", packet, err)
+		}
+
+		if m.Type != msg.Type {
+			t.Errorf("Expected type to be %v, got %v instead", msg.Type, m.Type)
+		}
+
+		switch msg.Type {
+		case MessageTypeEmit, MessageTypeAckRequest:
+			if m.Method != msg.Method {
+				t.Errorf("Expected method to be %q, got %q instead", msg.Method, m.Method)
+			}
+		}
+
+		switch msg.Type {
+		case MessageTypeAckRequest, MessageTypeAckResponse:
+			if m.AckID != msg.AckID {
+				t.Errorf("Expected ack ID to be %v, got %v instead", msg.AckID, m.AckID)
+			}
+		}
+
+		if again, err := Encode(m); err != nil || again != packet {
+			t.Errorf("Expected %+v to be encoded as %q, got %q (%v) instead", *m, packet, again, err)
+		}
+	})
+}
@@ -0,0 +1,238 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/wedeploy/gosocketio/codec"
+	"github.com/wedeploy/gosocketio/internal/msgpack"
+)
+
+// ErrBinaryFrame is returned when decoding a binary frame with a parser that only supports text.
+var ErrBinaryFrame = errors.New("binary frames are not supported by the parser")
+
+// Parser encodes and decodes socket.io packets into the messages of Engine.IO, which don't carry its packet type.
+type Parser interface {
+	// Encode a message and its arguments into an Engine.IO message.
+	Encode(msg *Message, args ...interface{}) (data []byte, binary bool, err error)
+
+	// Decode an Engine.IO message into a message.
+	Decode(data []byte, binary bool) (*Message, error)
+
+	// Codec of the payloads of decoded messages, such as the arguments in Message.Data.
+	Codec() codec.Codec
+}
+
+// NewParser for the given payload codec.
+// The MsgPack codec uses the socket.io-msgpack-parser packet format; other codecs use the text format.
+func NewParser(c codec.Codec) Parser {
+	if _, ok := c.(codec.MsgPackCodec); ok {
+		return msgpackParser{}
+	}
+
+	return textParser{
+		codec: c,
+	}
+}
+
+// textParser is the default socket.io parser.
+type textParser struct {
+	codec codec.Codec
+}
+
+func (p textParser) Encode(msg *Message, args ...interface{}) ([]byte, bool, error) {
+	packet, err := encode(msg, p.codec.Marshal, args...)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return trimMessage(packet)
+}
+
+func (p textParser) Decode(data []byte, binary bool) (*Message, error) {
+	if binary {
+		return nil, ErrBinaryFrame
+	}
+
+	return decodeMessage(data)
+}
+
+func (p textParser) Codec() codec.Codec {
+	return p.codec
+}
+
+// Packet types of socket.io-msgpack-parser.
+const (
+	msgpackConnect = iota
+	msgpackDisconnect
+	msgpackEvent
+	msgpackAck
+	msgpackConnectError
+	msgpackBinaryEvent
+	msgpackBinaryAck
+)
+
+// msgpackParser encodes whole socket.io packets with MessagePack, like socket.io-msgpack-parser.
+type msgpackParser struct{}
+
+type msgpackPacket struct {
+	Type int                `msgpack:"type"`
+	Data msgpack.RawMessage `msgpack:"data,omitempty"`
+	Nsp  string             `msgpack:"nsp"`
+	ID   *int               `msgpack:"id,omitempty"`
+}
+
+func (p msgpackParser) Encode(msg *Message, args ...interface{}) ([]byte, bool, error) {
+	var packet = msgpackPacket{
+		Nsp: msg.Namespace,
+	}
+
+	var data interface{}
+
+	switch msg.Type {
+	case MessageTypeEmpty:
+		packet.Type = msgpackConnect
+
+		if len(args) != 0 {
+			data = args[0]
+		}
+	case MessageTypeDisconnect:
+		packet.Type = msgpackDisconnect
+	case MessageTypeEmit, MessageTypeAckRequest:
+		packet.Type = msgpackEvent
+		data = append([]interface{}{msg.Method}, args...)
+
+		if msg.Type == MessageTypeAckRequest {
+			packet.ID = &msg.AckID
+		}
+	case MessageTypeAckResponse:
+		packet.Type = msgpackAck
+		packet.ID = &msg.AckID
+
+		if args == nil {
+			args = []interface{}{}
+		}
+
+		data = args
+	default:
+		return nil, false, ErrorWrongMessageType
+	}
+
+	if packet.Nsp == "" {
+		packet.Nsp = "/"
+	}
+
+	if data != nil {
+		raw, err := msgpack.Marshal(data)
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		packet.Data = raw
+	}
+
+	b, err := msgpack.Marshal(packet)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	return b, true, nil
+}
+
+func (p msgpackParser) Decode(data []byte, binary bool) (*Message, error) {
+	if !binary {
+		return decodeMessage(data)
+	}
+
+	var source = string(data)
+
+	var packet msgpackPacket
+
+	if err := msgpack.Unmarshal(data, &packet); err != nil {
+		return nil, err
+	}
+
+	msg := &Message{
+		Namespace: packet.Nsp,
+		Source:    source,
+	}
+
+	if msg.Namespace == "/" {
+		msg.Namespace = ""
+	}
+
+	if packet.ID != nil {
+		msg.AckID = *packet.ID
+	}
+
+	switch packet.Type {
+	case msgpackConnect:
+		msg.Type = MessageTypeEmpty
+		msg.Method = OnConnection
+		msg.Data = packet.Data
+	case msgpackDisconnect:
+		msg.Type = MessageTypeDisconnect
+	case msgpackEvent, msgpackBinaryEvent:
+		msg.Type = MessageTypeEmit
+
+		if packet.ID != nil {
+			msg.Type = MessageTypeAckRequest
+		}
+
+		if err := p.decodeEvent(msg, packet.Data); err != nil {
+			return nil, err
+		}
+	case msgpackAck, msgpackBinaryAck:
+		if packet.ID == nil {
+			return nil, ErrorWrongPacket
+		}
+
+		msg.Type = MessageTypeAckResponse
+		msg.Data = packet.Data
+	case msgpackConnectError:
+		msg.Type = MessageTypeError
+		msg.Data = packet.Data
+	default:
+		return nil, fmt.Errorf("can't decode message type %v", packet.Type)
+	}
+
+	return msg, nil
+}
+
+// decodeEvent splits the event name from its arguments.
+func (p msgpackParser) decodeEvent(msg *Message, data []byte) error {
+	parts, err := msgpack.Split(data)
+
+	if err != nil || len(parts) == 0 {
+		return ErrorWrongPacket
+	}
+
+	if err := msgpack.Unmarshal(parts[0], &msg.Method); err != nil {
+		return ErrorWrongPacket
+	}
+
+	msg.Data, err = msgpack.Marshal(parts[1:])
+	return err
+}
+
+func (p msgpackParser) Codec() codec.Codec {
+	return codec.MsgPack
+}
+
+// trimMessage takes the Engine.IO packet type out of an encoded socket.io packet.
+func trimMessage(packet string) ([]byte, bool, error) {
+	if !strings.HasPrefix(packet, RegularMessage) {
+		return nil, false, ErrorWrongMessageType
+	}
+
+	return []byte(packet[len(RegularMessage):]), false, nil
+}
+
+// decodeMessage decodes the socket.io packet carried by an Engine.IO message.
+func decodeMessage(data []byte) (*Message, error) {
+	return Decode(append([]byte(RegularMessage), data...))
+}
@@ -0,0 +1,215 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/wedeploy/gosocketio/codec"
+	"github.com/wedeploy/gosocketio/internal/msgpack"
+)
+
+func TestTextParser(t *testing.T) {
+	p := NewParser(codec.JSON)
+
+	data, binary, err := p.Encode(&Message{
+		Type:      MessageTypeEmit,
+		Namespace: "/chat",
+		Method:    "message",
+	}, "hi")
+
+	if err != nil {
+		t.Errorf("Expected no error encoding, got %v instead", err)
+	}
+
+	// the Engine.IO message packet type is left to the transport
+	if binary || string(data) != `2/chat,["message","hi"]` {
+		t.Errorf("Expected text packet, got %q (binary: %v) instead", data, binary)
+	}
+
+	msg, err := p.Decode(data, false)
+
+	if err != nil || msg.Type != MessageTypeEmit || msg.Namespace != "/chat" || msg.Method != "message" {
+		t.Errorf("Expected emit of message on /chat, got %+v (%v) instead", msg, err)
+	}
+
+	if _, _, err := p.Encode(&Message{Type: MessageTypePing}); err != ErrorWrongMessageType {
+		t.Errorf("Expected error to be %v, got %v instead", ErrorWrongMessageType, err)
+	}
+
+	if _, err := p.Decode([]byte{0x83}, true); err != ErrBinaryFrame {
+		t.Errorf("Expected error to be %v, got %v instead", ErrBinaryFrame, err)
+	}
+}
+
+func TestMsgPackParserEncode(t *testing.T) {
+	var cases = []struct {
+		msg  *Message
+		args []interface{}
+		want map[string]interface{}
+	}{
+		{
+			&Message{Type: MessageTypeEmit, Method: "ping"},
+			nil,
+			map[string]interface{}{"type": int64(2), "nsp": "/", "data": []interface{}{"ping"}},
+		},
+		{
+			&Message{Type: MessageTypeAckRequest, Namespace: "/chat", Method: "book", AckID: 7},
+			[]interface{}{"JFK"},
+			map[string]interface{}{"type": int64(2), "nsp": "/chat", "data": []interface{}{"book", "JFK"}, "id": int64(7)},
+		},
+		{
+			&Message{Type: MessageTypeAckResponse, Namespace: "/chat", AckID: 3},
+			nil,
+			map[string]interface{}{"type": int64(3), "nsp": "/chat", "data": []interface{}{}, "id": int64(3)},
+		},
+		{
+			&Message{Type: MessageTypeEmpty, Namespace: "/admin"},
+			[]interface{}{map[string]string{"token": "abc"}},
+			map[string]interface{}{"type": int64(0), "nsp": "/admin", "data": map[string]interface{}{"token": "abc"}},
+		},
+		{
+			&Message{Type: MessageTypeEmpty},
+			nil,
+			map[string]interface{}{"type": int64(0), "nsp": "/"},
+		},
+		{
+			&Message{Type: MessageTypeDisconnect, Namespace: "/admin"},
+			nil,
+			map[string]interface{}{"type": int64(1), "nsp": "/admin"},
+		},
+	}
+
+	p := NewParser(codec.MsgPack)
+
+	for _, c := range cases {
+		data, binary, err := p.Encode(c.msg, c.args...)
+
+		if err != nil || !binary {
+			t.Errorf("Expected binary packet for %+v, got error %v instead", c.msg, err)
+			continue
+		}
+
+		var got map[string]interface{}
+
+		if err := msgpack.Unmarshal(data, &got); err != nil {
+			t.Errorf("Expected no error decoding packet, got %v instead", err)
+			continue
+		}
+
+		if g, w := fmtValue(got), fmtValue(c.want); g != w {
+			t.Errorf("Expected packet to be %v, got %v instead", w, g)
+		}
+	}
+}
+
+func TestMsgPackParserText(t *testing.T) {
+	p := NewParser(codec.MsgPack)
+
+	if _, _, err := p.Encode(&Message{Type: MessageTypePing}); err != ErrorWrongMessageType {
+		t.Errorf("Expected error to be %v, got %v instead", ErrorWrongMessageType, err)
+	}
+
+	// text messages are decoded with the default format
+	if msg, err := p.Decode([]byte(`2["hello"]`), false); err != nil || msg.Type != MessageTypeEmit || msg.Method != "hello" {
+		t.Errorf("Expected text emit to be decoded, got %+v (%v) instead", msg, err)
+	}
+}
+
+func TestMsgPackParserDecode(t *testing.T) {
+	p := NewParser(codec.MsgPack)
+
+	encode := func(v interface{}) []byte {
+		b, err := msgpack.Marshal(v)
+
+		if err != nil {
+			t.Fatalf("Expected no error encoding, got %v instead", err)
+		}
+
+		return b
+	}
+
+	msg, err := p.Decode(encode(map[string]interface{}{
+		"type": 2,
+		"nsp":  "/chat",
+		"data": []interface{}{"book", "JFK", 2},
+		"id":   5,
+	}), true)
+
+	if err != nil {
+		t.Fatalf("Expected no error decoding, got %v instead", err)
+	}
+
+	if msg.Type != MessageTypeAckRequest || msg.Namespace != "/chat" || msg.Method != "book" || msg.AckID != 5 {
+		t.Errorf("Expected ack request for book on /chat, got %+v instead", msg)
+	}
+
+	if want := encode([]interface{}{"JFK", 2}); !bytes.Equal(msg.Data, want) {
+		t.Errorf("Expected data to be the arguments, got %x instead", msg.Data)
+	}
+
+	msg, err = p.Decode(encode(map[string]interface{}{
+		"type": 0,
+		"nsp":  "/",
+		"data": map[string]string{"sid": "abc"},
+	}), true)
+
+	if err != nil || msg.Type != MessageTypeEmpty || msg.Namespace != "" {
+		t.Errorf("Expected connection to the default namespace, got %+v (%v) instead", msg, err)
+	}
+
+	var session struct {
+		SID string `json:"sid"`
+	}
+
+	if err := p.Codec().Unmarshal(msg.Data, &session); err != nil || session.SID != "abc" {
+		t.Errorf("Expected session to be abc, got %v (%v) instead", session.SID, err)
+	}
+
+	msg, err = p.Decode(encode(map[string]interface{}{
+		"type": 4,
+		"nsp":  "/admin",
+		"data": map[string]string{"message": "not authorized"},
+	}), true)
+
+	if err != nil || msg.Type != MessageTypeError || msg.Namespace != "/admin" {
+		t.Errorf("Expected connection error for /admin, got %+v (%v) instead", msg, err)
+	}
+
+	for _, invalid := range []interface{}{
+		map[string]interface{}{"type": 9, "nsp": "/"},
+		map[string]interface{}{"type": 2, "nsp": "/", "data": []interface{}{}},
+		map[string]interface{}{"type": 3, "nsp": "/", "data": []interface{}{}},
+		"garbage",
+	} {
+		if _, err := p.Decode(encode(invalid), true); err == nil {
+			t.Errorf("Expected error decoding %v", invalid)
+		}
+	}
+}
+
+func fmtValue(v interface{}) string {
+	b, _ := msgpack.Marshal(v)
+	return string(b)
+}
+
+// FuzzMsgPackParserDecode checks that decoding binary frames never panics, whatever the server sends.
+func FuzzMsgPackParserDecode(f *testing.F) {
+	for _, v := range []interface{}{
+		map[string]interface{}{"type": 2, "nsp": "/", "data": []interface{}{"event", 1, "a"}, "id": 3},
+		map[string]interface{}{"type": 0, "nsp": "/admin", "data": map[string]string{"sid": "abc"}},
+		map[string]interface{}{"type": 3, "nsp": "/", "data": []interface{}{nil}, "id": 1},
+	} {
+		b, _ := msgpack.Marshal(v)
+		f.Add(b)
+	}
+
+	p := NewParser(codec.MsgPack)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := p.Decode(data, true)
+
+		if (m == nil) == (err == nil) {
+			t.Errorf("Expected either a message or an error decoding %x, got %+v and %v instead", data, m, err)
+		}
+	})
+}
@@ -23,7 +23,7 @@ const (
 	MessageTypeEmit        = "emit"
 	MessageTypeAckRequest  = "ack_request"
 	MessageTypeAckResponse = "ack_response"
-	MessageTypeNamespace   = "namespace"
+	MessageTypeDisconnect  = "disconnect"
 	MessageTypeError       = "error"
 )
 
@@ -77,4 +77,7 @@ var (
 
 	// ErrorWrongPacket is used for wrong packet.
 	ErrorWrongPacket = errors.New("wrong packet")
+
+	// ErrorInvalidMessage is used for messages that can't be encoded, such as namespaces with commas.
+	ErrorInvalidMessage = errors.New("invalid message")
 )
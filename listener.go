@@ -115,6 +115,17 @@ func (h *Handler) Call(args ...interface{}) []reflect.Value {
 	return h.Func.Call(a)
 }
 
+// values returned by a handler, as interfaces.
+func values(result []reflect.Value) []interface{} {
+	var list = []interface{}{}
+
+	for _, r := range result {
+		list = append(list, r.Interface())
+	}
+
+	return list
+}
+
 func (h *Handler) matchArgs(args []interface{}) (a []reflect.Value) {
 	lengthFuncArgs := len(h.args)
 	for pos := range h.args {
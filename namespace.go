@@ -3,9 +3,17 @@ package gosocketio
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
 
 	"github.com/wedeploy/gosocketio/ack"
+	"github.com/wedeploy/gosocketio/clock"
+	"github.com/wedeploy/gosocketio/codec"
 	"github.com/wedeploy/gosocketio/internal/protocol"
+	"github.com/wedeploy/gosocketio/outbox"
+	"github.com/wedeploy/gosocketio/websocket"
 )
 
 // NewNamespace creates a namespace.
@@ -13,11 +21,114 @@ func NewNamespace(c *Client, namespace string) *Namespace {
 	return &Namespace{
 		name: namespace,
 
-		getHandlers:  c.getHandlers,
-		getAck:       c.getAck,
-		writeMessage: c.writeMessage,
+		getHandlers:   c.getHandlers,
+		getAck:        c.getAck,
+		encodeFrame:   c.encode,
+		writeMessage:  c.writeMessage,
+		writeControl:  c.writeControl,
+		enqueue:       c.enqueue,
+		tryWrite:      c.tryWrite,
+		drop:          c.drop,
+		callLoopEvent: c.callLoopEvent,
+		notifyState:   c.notifyState,
+		isOpen:        c.isOpen,
+		isClosing:     c.isClosing,
 
-		ready: make(chan struct{}, 1),
+		ready:   make(chan struct{}),
+		attempt: newJoinAttempt(),
+		buffer:  newSendBuffer(c.opts.SendBuffer, c.opts.SendBufferOverflow),
+		outbox:  c.opts.Outbox,
+		codec:   c.parser.Codec(),
+		clock:   c.opts.Clock,
+
+		ackOptions: AckOptions{
+			Timeout: c.opts.AckTimeout,
+			Retries: c.opts.AckRetries,
+			Backoff: c.opts.AckBackoff,
+		},
+	}
+}
+
+// NamespaceOptions for connecting to a namespace.
+type NamespaceOptions struct {
+	// Query parameters sent with the connection packet (socket.io v2).
+	Query url.Values
+
+	// Auth payload sent with the connection packet (socket.io v3 and later).
+	Auth interface{}
+}
+
+// ConnectError is used when the server refuses a namespace connection.
+type ConnectError struct {
+	namespace string
+	message   string
+	data      json.RawMessage
+}
+
+func newConnectError(namespace string, payload []byte, c codec.Codec) ConnectError {
+	e := ConnectError{
+		namespace: namespace,
+	}
+
+	var details struct {
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+
+	switch {
+	case len(payload) == 0:
+	case c.Unmarshal(payload, &e.message) == nil:
+	case c.Unmarshal(payload, &details) == nil:
+		e.message = details.Message
+		e.data = details.Data
+	default:
+		e.message = string(payload)
+	}
+
+	return e
+}
+
+func (e ConnectError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf(`connection to namespace "%s" refused`, e.namespace)
+	}
+
+	return fmt.Sprintf(`connection to namespace "%s" refused: %s`, e.namespace, e.message)
+}
+
+// Namespace that refused the connection.
+func (e ConnectError) Namespace() string {
+	return e.namespace
+}
+
+// Message sent by the server.
+func (e ConnectError) Message() string {
+	return e.message
+}
+
+// Data sent by the server along with the message, if any.
+func (e ConnectError) Data() json.RawMessage {
+	return e.data
+}
+
+// joinAttempt is finished when the server accepts or refuses the namespace connection.
+type joinAttempt struct {
+	done chan struct{}
+	err  error
+}
+
+func newJoinAttempt() *joinAttempt {
+	return &joinAttempt{
+		done: make(chan struct{}),
+	}
+}
+
+func (j *joinAttempt) finish(err error) {
+	select {
+	case <-j.done:
+	default:
+		j.err = err
+		close(j.done)
 	}
 }
 
@@ -25,21 +136,459 @@ func NewNamespace(c *Client, namespace string) *Namespace {
 type Namespace struct {
 	name string
 
-	getHandlers  func() *handlers
-	getAck       func() *ack.Waiter
-	writeMessage func(message string) error
+	getHandlers   func() *handlers
+	getAck        func() *ack.Waiter
+	encodeFrame   func(msg *protocol.Message, args ...interface{}) (websocket.Frame, error)
+	writeMessage  func(ctx context.Context, frame websocket.Frame) error
+	writeControl  func(frame websocket.Frame) error
+	enqueue       func(frame websocket.Frame) (func() error, error)
+	tryWrite      func(frame websocket.Frame)
+	drop          func()
+	callLoopEvent func(namespace string, event string, args ...interface{})
+	notifyState   func(t Transition)
+	isOpen        func() bool
+	isClosing     func() bool
+
+	codec      codec.Codec
+	clock      clock.Clock
+	opts       *NamespaceOptions
+	ackOptions AckOptions
+	state      State
+	deferred   bool
+	ready      chan struct{}
+	attempt    *joinAttempt
+	buffer     *sendBuffer
+	watchers   stateWatchers
 
-	ready chan struct{}
+	// outbox entries written in the current session, by ack ID
+	outbox   *outbox.Outbox
+	inflight map[int]string
+
+	// session of the namespace for connection state recovery
+	id        string
+	pid       string
+	offset    string
+	recovered bool
+
+	locker sync.RWMutex
 }
 
-// Ready returns a channel that informs whether the namespace is already connected.
+// Ready returns a channel that is closed once the namespace is connected.
 // It is a more idiomatic Go way to do 'on "connection"'.
+// A new channel is used after the namespace is disconnected, so call Ready again after joining it back.
 func (n *Namespace) Ready() <-chan struct{} {
-	return n.ready
+	n.locker.RLock()
+	ready := n.ready
+	n.locker.RUnlock()
+	return ready
+}
+
+// State of the namespace connection: disconnected, connecting, or connected.
+func (n *Namespace) State() State {
+	n.locker.RLock()
+	state := n.state
+	n.locker.RUnlock()
+	return state
+}
+
+// NotifyState relays the namespace state transitions to c.
+// It doesn't block sending to c: the caller must ensure that c has enough buffer space
+// to keep up with the expected rate, or transitions are dropped.
+func (n *Namespace) NotifyState(c chan<- Transition) {
+	n.locker.Lock()
+	n.watchers.add(c)
+	n.locker.Unlock()
+}
+
+// StopNotifyState stops relaying the namespace state transitions to c.
+func (n *Namespace) StopNotifyState(c chan<- Transition) {
+	n.locker.Lock()
+	n.watchers.remove(c)
+	n.locker.Unlock()
+}
+
+// setState must be called with the lock held.
+func (n *Namespace) setState(state State, cause error) {
+	if n.state == state {
+		return
+	}
+
+	t := Transition{
+		Namespace: n,
+		From:      n.state,
+		To:        state,
+		At:        n.clock.Now(),
+		Cause:     cause,
+	}
+
+	n.state = state
+	n.watchers.notify(t)
+	n.notifyState(t)
 }
 
+// setReady marks the namespace as connected and flushes the send buffer.
+// It is a no-op if the namespace is already connected.
+// It doesn't wait for the flushed messages to be written, as it is called by the incoming loop.
 func (n *Namespace) setReady() {
-	n.ready <- struct{}{}
+	n.locker.Lock()
+
+	if n.state == StateConnected {
+		n.locker.Unlock()
+		return
+	}
+
+	n.setState(StateConnected, nil)
+	n.attempt.finish(nil)
+	close(n.ready)
+
+	// new messages wait for the lock, so the queued ones are handed to the out loop first
+	flushed := n.flushBuffer()
+	durable, err := n.flushOutbox()
+
+	n.locker.Unlock()
+
+	if len(flushed) != 0 {
+		go n.finishFlush(flushed)
+	}
+
+	if len(durable) != 0 {
+		go n.finishOutbox(durable)
+	}
+
+	if err != nil {
+		n.callLoopEvent(n.name, OnError, err)
+	}
+}
+
+// flushedMessage is waited for by finishFlush.
+type flushedMessage struct {
+	frame websocket.Frame
+	wait  func() error
+}
+
+// flushBuffer hands the messages of the send buffer to the out loop, in order, without waiting for them to be written.
+// It must be called with the lock held.
+func (n *Namespace) flushBuffer() []flushedMessage {
+	if n.buffer == nil {
+		return nil
+	}
+
+	var flushed []flushedMessage
+
+	for _, frame := range n.buffer.drain() {
+		wait, err := n.enqueue(frame)
+
+		// the client is closed, so the messages are useless
+		if err != nil {
+			break
+		}
+
+		flushed = append(flushed, flushedMessage{frame, wait})
+	}
+
+	return flushed
+}
+
+// finishFlush waits for the flushed messages to be written. The ones that couldn't be are put back in the buffer,
+// ahead of the queued ones, to be written in order once the namespace is connected again, or before the next message sent.
+func (n *Namespace) finishFlush(flushed []flushedMessage) {
+	var (
+		unsent []websocket.Frame
+		err    error
+	)
+
+	for _, f := range flushed {
+		if werr := f.wait(); werr != nil {
+			unsent = append(unsent, f.frame)
+
+			if err == nil {
+				err = werr
+			}
+		}
+	}
+
+	if err == nil {
+		return
+	}
+
+	if !n.isClosing() {
+		n.locker.Lock()
+		n.buffer.restore(unsent)
+		n.locker.Unlock()
+	}
+
+	n.callLoopEvent(n.name, OnError, err)
+}
+
+// Buffered returns the number of messages queued while the namespace isn't connected.
+func (n *Namespace) Buffered() int {
+	n.locker.RLock()
+	l := n.buffer.len()
+	n.locker.RUnlock()
+	return l
+}
+
+// refuse the pending connection attempt.
+func (n *Namespace) refuse(err error) {
+	n.locker.Lock()
+	defer n.locker.Unlock()
+
+	if n.state != StateConnecting {
+		return
+	}
+
+	n.setState(StateDisconnected, err)
+	n.attempt.finish(err)
+}
+
+// ID of the socket on the namespace, as sent by socket.io v3 and later servers.
+func (n *Namespace) ID() string {
+	n.locker.RLock()
+	id := n.id
+	n.locker.RUnlock()
+	return id
+}
+
+// Recovered tells whether the server recovered the namespace session after reconnecting,
+// sending the missed events and keeping its rooms.
+// It requires connection state recovery to be enabled on the server (socket.io v4.6 and later).
+func (n *Namespace) Recovered() bool {
+	n.locker.RLock()
+	recovered := n.recovered
+	n.locker.RUnlock()
+	return recovered
+}
+
+// setSession with the data of the namespace connection packet.
+func (n *Namespace) setSession(data []byte) {
+	var session struct {
+		SID string `json:"sid"`
+		PID string `json:"pid"`
+	}
+
+	if len(data) != 0 {
+		_ = n.codec.Unmarshal(data, &session)
+	}
+
+	n.locker.Lock()
+	n.id = session.SID
+	n.recovered = session.PID != "" && session.PID == n.pid
+	n.pid = session.PID
+	n.locker.Unlock()
+}
+
+// trackOffset of the last event received, sent by the server as its last argument
+// when connection state recovery is enabled.
+func (n *Namespace) trackOffset(data []byte) {
+	n.locker.RLock()
+	pid := n.pid
+	n.locker.RUnlock()
+
+	if pid == "" {
+		return
+	}
+
+	var offset string
+	args, err := n.codec.Split(data)
+
+	if err != nil || len(args) == 0 {
+		return
+	}
+
+	if err := n.codec.Unmarshal(args[len(args)-1], &offset); err != nil {
+		return
+	}
+
+	n.locker.Lock()
+	n.offset = offset
+	n.locker.Unlock()
+}
+
+func (n *Namespace) isJoined() bool {
+	return n.State() != StateDisconnected
+}
+
+// join sends the namespace connection packet, unless the namespace is already joined.
+// The options replace the ones from previous attempts, unless nil.
+// It returns the connection attempt to wait for the server response.
+func (n *Namespace) join(opts *NamespaceOptions) (*joinAttempt, error) {
+	n.locker.Lock()
+	defer n.locker.Unlock()
+
+	if opts != nil {
+		n.opts = opts
+	}
+
+	if n.state != StateDisconnected {
+		return n.attempt, nil
+	}
+
+	attempt := newJoinAttempt()
+
+	// the connection packet is deferred until the connection is open
+	if n.isOpen() {
+		if err := n.writeJoin(); err != nil {
+			return nil, err
+		}
+	} else {
+		n.deferred = true
+	}
+
+	n.attempt = attempt
+	n.setState(StateConnecting, nil)
+	return attempt, nil
+}
+
+// flushJoin sends the deferred connection packet, if any.
+func (n *Namespace) flushJoin() error {
+	n.locker.Lock()
+	defer n.locker.Unlock()
+
+	if !n.deferred {
+		return nil
+	}
+
+	n.deferred = false
+	return n.writeJoin()
+}
+
+// writeJoin must be called with the lock held.
+func (n *Namespace) writeJoin() error {
+	msg := &protocol.Message{
+		Type:      protocol.MessageTypeEmpty,
+		Namespace: n.name,
+	}
+
+	var args []interface{}
+
+	if n.opts != nil && len(n.opts.Query) != 0 {
+		msg.Namespace += "?" + n.opts.Query.Encode()
+	}
+
+	auth, err := n.auth()
+
+	if err != nil {
+		return err
+	}
+
+	if auth != nil {
+		args = append(args, auth)
+	}
+
+	frame, err := n.encodeFrame(msg, args...)
+
+	if err != nil {
+		return err
+	}
+
+	return n.writeControl(frame)
+}
+
+// auth payload of the connection packet, including the session to recover, if any.
+// It must be called with the lock held.
+func (n *Namespace) auth() (interface{}, error) {
+	var auth interface{}
+
+	if n.opts != nil {
+		auth = n.opts.Auth
+	}
+
+	if n.pid == "" {
+		return auth, nil
+	}
+
+	var fields = map[string]interface{}{}
+
+	if auth != nil {
+		b, err := n.codec.Marshal(auth)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := n.codec.Unmarshal(b, &fields); err != nil {
+			return nil, fmt.Errorf("can't recover session: auth payload must be an object: %v", err)
+		}
+	}
+
+	fields["pid"] = n.pid
+
+	if n.offset != "" {
+		fields["offset"] = n.offset
+	}
+
+	return fields, nil
+}
+
+// interrupt the namespace when the connection is lost, preparing to join it again once reconnected.
+// It returns false if the namespace wasn't joined.
+func (n *Namespace) interrupt(cause error, deferJoin bool) bool {
+	if !n.leave(cause) {
+		return false
+	}
+
+	n.locker.Lock()
+
+	if n.state == StateDisconnected {
+		n.deferred = deferJoin
+		n.recovered = false
+		n.setState(StateConnecting, nil)
+	}
+
+	n.locker.Unlock()
+	return true
+}
+
+// leave marks the namespace as disconnected and resets its ready signal.
+// It returns false if the namespace wasn't joined.
+func (n *Namespace) leave(cause error) bool {
+	n.locker.Lock()
+	defer n.locker.Unlock()
+
+	if n.state == StateDisconnected {
+		return false
+	}
+
+	if n.state == StateConnected {
+		n.ready = make(chan struct{})
+	}
+
+	// queued messages are useless if the client is gone
+	if n.buffer != nil && cause == ErrClientClosed {
+		n.buffer.drain()
+	}
+
+	var err = cause
+
+	if err == nil {
+		err = ErrNamespaceDisconnected
+	}
+
+	n.deferred = false
+	n.attempt.finish(err)
+	n.attempt = newJoinAttempt()
+	n.setState(StateDisconnected, cause)
+	return true
+}
+
+// Close leaves the namespace and calls its "disconnect" listeners.
+// Listeners are kept, and the namespace can be joined again with Client.Of.
+func (n *Namespace) Close() error {
+	if !n.leave(nil) {
+		return nil
+	}
+
+	var err error
+
+	// nothing to tell the server if the connection isn't open yet
+	if n.isOpen() {
+		err = n.sendNow(&protocol.Message{
+			Type: protocol.MessageTypeDisconnect,
+		})
+	}
+
+	n.callLoopEvent(n.name, OnDisconnect)
+	return err
 }
 
 // On registers a listener.
@@ -78,46 +627,188 @@ func (n *Namespace) Listeners() (list []string) {
 
 // Emit message.
 func (n *Namespace) Emit(method string, args ...interface{}) error {
+	return n.EmitContext(context.Background(), method, args...)
+}
+
+// EmitContext emits a message, waiting until it is written or the context is done.
+// If the context is done while the message is queued, it is discarded; if it is already being written, it might still be sent.
+// Once the client is closed or shutting down, it returns ErrClientClosed right away.
+func (n *Namespace) EmitContext(ctx context.Context, method string, args ...interface{}) error {
 	msg := &protocol.Message{
 		Type:   protocol.MessageTypeEmit,
 		Method: method,
 	}
 
-	return n.send(msg, args...)
+	return n.send(ctx, msg, args...)
+}
+
+// AckOptions for waiting for acks.
+type AckOptions struct {
+	// Timeout for the server to acknowledge each attempt. If zero, only the context limits the wait.
+	Timeout time.Duration
+
+	// Retries after an attempt times out. The same message, with the same ack ID, is sent again,
+	// so an ack for any of the attempts is accepted. It isn't queued again while still in the send buffer.
+	Retries int
+
+	// Backoff before the first retry. It doubles after each retry.
+	Backoff time.Duration
 }
 
 // Ack packet based on given data and send it and receive response.
+// It uses the client AckTimeout, AckRetries, and AckBackoff options.
 func (n *Namespace) Ack(ctx context.Context, method string, args interface{}, v interface{}) error {
+	return n.AckWithOptions(ctx, method, args, v, nil)
+}
+
+// AckWithOptions is like Ack, but with its own timeout and retries, unless opts is nil.
+// It returns ErrAckTimeout once all attempts time out, or ErrConnectionLost if the connection drops first,
+// even if the client is closed because it doesn't reconnect.
+func (n *Namespace) AckWithOptions(ctx context.Context, method string, args interface{}, v interface{}, opts *AckOptions) error {
+	if opts == nil {
+		opts = &n.ackOptions
+	}
+
 	msg := &protocol.Message{
 		Type:   protocol.MessageTypeAckRequest,
 		AckID:  n.getAck().Next(),
 		Method: method,
 	}
 
-	waiter := make(chan string)
-	n.getAck().Set(msg.AckID, waiter)
+	frame, err := n.encode(msg, args)
 
-	if err := n.send(msg, args); err != nil {
-		n.getAck().Delete(msg.AckID)
+	if err != nil {
+		return err
 	}
 
-	select {
-	case ret := <-waiter:
-		ret = ret[1 : len(ret)-1]
-		return json.Unmarshal([]byte(ret), v)
-	case <-ctx.Done():
-		n.getAck().Delete(msg.AckID)
-		return ctx.Err()
+	// buffered so the incoming loop never blocks on an abandoned ack
+	waiter := make(chan string, 1)
+	acks := n.getAck()
+	acks.Set(msg.AckID, waiter)
+	defer acks.Delete(msg.AckID)
+
+	interrupted := acks.Interrupted()
+
+	wait := func(timeout time.Duration) error {
+		var expired <-chan time.Time
+
+		if timeout > 0 {
+			timer := n.clock.NewTimer(timeout)
+			defer timer.Stop()
+			expired = timer.C()
+		}
+
+		select {
+		case ret := <-waiter:
+			return n.unmarshalAck([]byte(ret), v)
+		case <-expired:
+			return ErrAckTimeout
+		case <-interrupted:
+			return ErrConnectionLost
+		case <-acks.Done():
+			// the client is closed right after interrupting the acks if it doesn't reconnect
+			select {
+			case <-interrupted:
+				return ErrConnectionLost
+			default:
+				return ErrClientClosed
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for retry := 0; ; retry++ {
+		if err := n.sendAttempt(ctx, frame); err != nil {
+			return err
+		}
+
+		if err = wait(opts.Timeout); err != ErrAckTimeout || retry == opts.Retries {
+			return err
+		}
+
+		// an ack for the previous attempt might still arrive while backing off
+		if backoff := opts.Backoff << uint(retry); backoff > 0 {
+			if err = wait(backoff); err != ErrAckTimeout {
+				return err
+			}
+		}
 	}
 }
 
-func (n *Namespace) send(msg *protocol.Message, args ...interface{}) (err error) {
+// sendAttempt of an ack, unless the previous attempt is still queued in the send buffer,
+// so the server doesn't receive it again for each retry once the namespace is connected.
+func (n *Namespace) sendAttempt(ctx context.Context, frame websocket.Frame) error {
+	n.locker.RLock()
+	queued := n.buffer.contains(frame)
+	n.locker.RUnlock()
+
+	if queued {
+		return nil
+	}
+
+	return n.sendCommand(ctx, frame)
+}
+
+// unmarshalAck stores the first argument of an ack in v.
+func (n *Namespace) unmarshalAck(data []byte, v interface{}) error {
+	args, err := n.codec.Split(data)
+
+	if err != nil || len(args) == 0 {
+		return err
+	}
+
+	return n.codec.Unmarshal(args[0], v)
+}
+
+// send a message, or queue it in the send buffer while the namespace isn't connected.
+func (n *Namespace) send(ctx context.Context, msg *protocol.Message, args ...interface{}) error {
+	frame, err := n.encode(msg, args...)
+
+	if err != nil {
+		return err
+	}
+
+	return n.sendCommand(ctx, frame)
+}
+
+func (n *Namespace) encode(msg *protocol.Message, args ...interface{}) (websocket.Frame, error) {
 	msg.Namespace = n.name
-	command, err := protocol.Encode(msg, args...)
+	return n.encodeFrame(msg, args...)
+}
+
+// sendCommand writes an encoded message, or queues it in the send buffer while the namespace isn't connected.
+func (n *Namespace) sendCommand(ctx context.Context, frame websocket.Frame) (err error) {
+	if n.isClosing() {
+		return ErrClientClosed
+	}
+
+	n.locker.Lock()
+
+	if n.buffer != nil && n.state != StateConnected {
+		err = n.buffer.push(frame)
+		n.locker.Unlock()
+		return err
+	}
+
+	// messages left in the buffer by a failed flush are written first, so they stay in order
+	flushed := n.flushBuffer()
+	n.locker.Unlock()
+
+	if len(flushed) != 0 {
+		go n.finishFlush(flushed)
+	}
+
+	return n.writeMessage(ctx, frame)
+}
+
+// sendNow sends a control packet regardless of the namespace state.
+func (n *Namespace) sendNow(msg *protocol.Message, args ...interface{}) (err error) {
+	frame, err := n.encode(msg, args...)
 
 	if err != nil {
 		return err
 	}
 
-	return n.writeMessage(command)
+	return n.writeControl(frame)
 }
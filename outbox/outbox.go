@@ -0,0 +1,337 @@
+// Package outbox is a durable queue of messages waiting to be acknowledged.
+// It is stored as an append-only log in a local directory, so messages survive crashes and restarts.
+package outbox
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	// LogFile is the name of the log inside the outbox directory.
+	LogFile = "outbox.log"
+
+	// compactThreshold is the number of removed entries that triggers rewriting the log.
+	compactThreshold = 1024
+)
+
+// ErrClosed is returned when using a closed outbox.
+var ErrClosed = errors.New("outbox is closed")
+
+// Entry waiting to be acknowledged.
+type Entry struct {
+	// Key is used by the server to recognize messages sent more than once.
+	Key string `json:"key"`
+
+	Namespace string          `json:"namespace"`
+	Method    string          `json:"method"`
+	Args      json.RawMessage `json:"args"`
+}
+
+type record struct {
+	Op string `json:"op"`
+	Entry
+}
+
+const (
+	opAppend = "append"
+	opRemove = "remove"
+)
+
+// logFile is the part of *os.File used to write the log.
+type logFile interface {
+	Write(b []byte) (int, error)
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+// Outbox of messages waiting to be acknowledged.
+type Outbox struct {
+	dir  string
+	file logFile
+
+	// size of the log up to the last record written in full
+	size int64
+
+	entries map[string]Entry
+	order   []string
+	removed int
+
+	lock sync.Mutex
+}
+
+// Open the outbox stored in the given directory, creating it if necessary.
+// Entries appended and not removed before are loaded back.
+func Open(dir string) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	o := &Outbox{
+		dir:     dir,
+		entries: map[string]Entry{},
+	}
+
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+
+	// rewriting on open also discards the damaged records
+	if err := o.compact(); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+func (o *Outbox) path() string {
+	return filepath.Join(o.dir, LogFile)
+}
+
+func (o *Outbox) load() error {
+	f, err := os.Open(o.path())
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+
+	for scanner.Scan() {
+		var r record
+
+		// a record might be incomplete if the process crashed while writing it,
+		// and the records after it are still loaded
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+
+		switch r.Op {
+		case opAppend:
+			o.add(r.Entry)
+		case opRemove:
+			o.delete(r.Key)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (o *Outbox) add(e Entry) {
+	if _, ok := o.entries[e.Key]; !ok {
+		o.order = append(o.order, e.Key)
+	}
+
+	o.entries[e.Key] = e
+}
+
+func (o *Outbox) delete(key string) bool {
+	if _, ok := o.entries[key]; !ok {
+		return false
+	}
+
+	delete(o.entries, key)
+
+	for i, k := range o.order {
+		if k == key {
+			o.order = append(o.order[:i], o.order[i+1:]...)
+			break
+		}
+	}
+
+	return true
+}
+
+// compact rewrites the log with the pending entries only.
+func (o *Outbox) compact() error {
+	var buf bytes.Buffer
+
+	for _, key := range o.order {
+		if err := encode(&buf, record{opAppend, o.entries[key]}); err != nil {
+			return err
+		}
+	}
+
+	tmp := o.path() + ".tmp"
+
+	if err := writeFileSync(tmp, buf.Bytes()); err != nil {
+		return err
+	}
+
+	if o.file != nil {
+		_ = o.file.Close()
+	}
+
+	if err := os.Rename(tmp, o.path()); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(o.path(), os.O_WRONLY|os.O_APPEND, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	o.file = f
+	o.size = int64(buf.Len())
+	o.removed = 0
+	return nil
+}
+
+func writeFileSync(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func encode(buf *bytes.Buffer, r record) error {
+	b, err := json.Marshal(r)
+
+	if err != nil {
+		return err
+	}
+
+	buf.Write(b)
+	buf.WriteByte('\n')
+	return nil
+}
+
+// write a record to the log and flush it to the disk.
+func (o *Outbox) write(r record) error {
+	if o.file == nil {
+		return ErrClosed
+	}
+
+	var buf bytes.Buffer
+
+	if err := encode(&buf, r); err != nil {
+		return err
+	}
+
+	_, err := o.file.Write(buf.Bytes())
+
+	if err == nil {
+		err = o.file.Sync()
+	}
+
+	// a torn record would be glued to the next one, so the log is cut back to the last record written in full
+	if err != nil {
+		_ = o.file.Truncate(o.size)
+		return err
+	}
+
+	o.size += int64(buf.Len())
+	return nil
+}
+
+// NewKey creates a random idempotency key.
+func NewKey() (string, error) {
+	var b [16]byte
+
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b[:]), nil
+}
+
+// Append an entry. It is only returned after it is stored on the disk.
+func (o *Outbox) Append(e Entry) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if err := o.write(record{opAppend, e}); err != nil {
+		return err
+	}
+
+	o.add(e)
+	return nil
+}
+
+// Remove the entry with the given key, once it is acknowledged.
+func (o *Outbox) Remove(key string) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if _, ok := o.entries[key]; !ok {
+		return nil
+	}
+
+	if err := o.write(record{Op: opRemove, Entry: Entry{Key: key}}); err != nil {
+		return err
+	}
+
+	o.delete(key)
+	o.removed++
+
+	if o.removed >= compactThreshold && o.removed > len(o.entries) {
+		return o.compact()
+	}
+
+	return nil
+}
+
+// Pending entries, in the order they were appended.
+func (o *Outbox) Pending() []Entry {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	var pending = make([]Entry, 0, len(o.order))
+
+	for _, key := range o.order {
+		pending = append(pending, o.entries[key])
+	}
+
+	return pending
+}
+
+// Len is the number of pending entries.
+func (o *Outbox) Len() int {
+	o.lock.Lock()
+	l := len(o.entries)
+	o.lock.Unlock()
+	return l
+}
+
+// Close the outbox.
+func (o *Outbox) Close() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.file == nil {
+		return nil
+	}
+
+	err := o.file.Close()
+	o.file = nil
+	return err
+}
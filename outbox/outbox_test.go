@@ -0,0 +1,186 @@
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutbox(t *testing.T) {
+	dir := t.TempDir()
+	o, err := Open(dir)
+
+	if err != nil {
+		t.Fatalf("Expected no error opening outbox, got %v instead", err)
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := o.Append(Entry{Key: key, Method: "m", Args: json.RawMessage(`["` + key + `"]`)}); err != nil {
+			t.Fatalf("Expected no error appending entry, got %v instead", err)
+		}
+	}
+
+	if err := o.Remove("b"); err != nil {
+		t.Errorf("Expected no error removing entry, got %v instead", err)
+	}
+
+	if err := o.Remove("unknown"); err != nil {
+		t.Errorf("Expected no error removing unknown entry, got %v instead", err)
+	}
+
+	if err := o.Close(); err != nil {
+		t.Errorf("Expected no error closing outbox, got %v instead", err)
+	}
+
+	if err := o.Append(Entry{Key: "d"}); err != ErrClosed {
+		t.Errorf("Expected error to be %v, got %v instead", ErrClosed, err)
+	}
+
+	// a record torn by a crash is discarded
+	f, _ := os.OpenFile(filepath.Join(dir, LogFile), os.O_WRONLY|os.O_APPEND, 0600)
+	_, _ = f.WriteString(`{"op":"remove","ke`)
+	_ = f.Close()
+
+	if o, err = Open(dir); err != nil {
+		t.Fatalf("Expected no error opening outbox again, got %v instead", err)
+	}
+
+	defer o.Close()
+
+	pending := o.Pending()
+
+	if len(pending) != 2 || pending[0].Key != "a" || pending[1].Key != "c" {
+		t.Errorf("Expected pending entries to be a and c, got %+v instead", pending)
+	}
+
+	if got := string(pending[1].Args); got != `["c"]` {
+		t.Errorf("Expected arguments to be preserved, got %v instead", got)
+	}
+}
+
+func TestOutboxDamagedRecord(t *testing.T) {
+	dir := t.TempDir()
+	log := `{"op":"append","key":"a","method":"m","args":[]}` + "\n" +
+		`{"op":"append","ke` + "\n" +
+		`{"op":"append","key":"b","method":"m","args":[]}` + "\n"
+
+	if err := os.WriteFile(filepath.Join(dir, LogFile), []byte(log), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	o, err := Open(dir)
+
+	if err != nil {
+		t.Fatalf("Expected no error opening outbox, got %v instead", err)
+	}
+
+	defer o.Close()
+
+	// the records after the damaged one are kept
+	if pending := o.Pending(); len(pending) != 2 || pending[0].Key != "a" || pending[1].Key != "b" {
+		t.Errorf("Expected pending entries to be a and b, got %+v instead", pending)
+	}
+}
+
+// shortFile writes half of the next record and fails.
+type shortFile struct {
+	logFile
+	failed bool
+}
+
+var errShortWrite = errors.New("short write")
+
+func (f *shortFile) Write(b []byte) (int, error) {
+	if f.failed {
+		return f.logFile.Write(b)
+	}
+
+	f.failed = true
+	n, _ := f.logFile.Write(b[:len(b)/2])
+	return n, errShortWrite
+}
+
+func TestOutboxFailedWrite(t *testing.T) {
+	dir := t.TempDir()
+	o, err := Open(dir)
+
+	if err != nil {
+		t.Fatalf("Expected no error opening outbox, got %v instead", err)
+	}
+
+	if err := o.Append(Entry{Key: "a", Method: "m", Args: json.RawMessage(`[]`)}); err != nil {
+		t.Fatalf("Expected no error appending entry, got %v instead", err)
+	}
+
+	o.file = &shortFile{logFile: o.file}
+
+	if err := o.Append(Entry{Key: "b", Method: "m", Args: json.RawMessage(`[]`)}); err != errShortWrite {
+		t.Errorf("Expected error to be %v, got %v instead", errShortWrite, err)
+	}
+
+	// the torn record isn't glued to the next one
+	if err := o.Append(Entry{Key: "c", Method: "m", Args: json.RawMessage(`[]`)}); err != nil {
+		t.Fatalf("Expected no error appending entry, got %v instead", err)
+	}
+
+	_ = o.Close()
+
+	if o, err = Open(dir); err != nil {
+		t.Fatalf("Expected no error opening outbox again, got %v instead", err)
+	}
+
+	defer o.Close()
+
+	if pending := o.Pending(); len(pending) != 2 || pending[0].Key != "a" || pending[1].Key != "c" {
+		t.Errorf("Expected pending entries to be a and c, got %+v instead", pending)
+	}
+}
+
+func TestOutboxCompact(t *testing.T) {
+	dir := t.TempDir()
+	o, err := Open(dir)
+
+	if err != nil {
+		t.Fatalf("Expected no error opening outbox, got %v instead", err)
+	}
+
+	defer o.Close()
+
+	for i := 0; i < compactThreshold; i++ {
+		key, _ := NewKey()
+		_ = o.Append(Entry{Key: key})
+		_ = o.Remove(key)
+	}
+
+	_ = o.Append(Entry{Key: "last"})
+
+	data, err := os.ReadFile(filepath.Join(dir, LogFile))
+
+	if err != nil {
+		t.Fatalf("Expected no error reading log, got %v instead", err)
+	}
+
+	if got, want := string(data), `{"op":"append","key":"last","namespace":"","method":"","args":null}`+"\n"; got != want {
+		t.Errorf("Expected log to be compacted to %v, got %v instead", want, got)
+	}
+
+	if l := o.Len(); l != 1 {
+		t.Errorf("Expected 1 pending entry, got %v instead", l)
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	a, err := NewKey()
+
+	if err != nil {
+		t.Fatalf("Expected no error creating key, got %v instead", err)
+	}
+
+	b, _ := NewKey()
+
+	if len(a) != 32 || a == b {
+		t.Errorf("Expected unique 32 characters keys, got %v and %v instead", a, b)
+	}
+}
@@ -0,0 +1,341 @@
+// Package protocol implements the packet formats of socket.io and of Engine.IO, its transport layer.
+// It can be used on its own to build proxies, inspectors or custom transports.
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EnginePacketType of Engine.IO packets.
+type EnginePacketType int
+
+// Engine.IO packet types.
+const (
+	EngineOpen EnginePacketType = iota
+	EngineClose
+	EnginePing
+	EnginePong
+	EngineMessage
+	EngineUpgrade
+	EngineNoop
+)
+
+func (t EnginePacketType) String() string {
+	switch t {
+	case EngineOpen:
+		return "open"
+	case EngineClose:
+		return "close"
+	case EnginePing:
+		return "ping"
+	case EnginePong:
+		return "pong"
+	case EngineMessage:
+		return "message"
+	case EngineUpgrade:
+		return "upgrade"
+	case EngineNoop:
+		return "noop"
+	}
+
+	return fmt.Sprintf("EnginePacketType(%d)", int(t))
+}
+
+// PacketType of socket.io packets, carried by Engine.IO message packets.
+type PacketType int
+
+// socket.io packet types.
+const (
+	Connect PacketType = iota
+	Disconnect
+	Event
+	Ack
+	ConnectError
+	BinaryEvent
+	BinaryAck
+)
+
+func (t PacketType) String() string {
+	switch t {
+	case Connect:
+		return "CONNECT"
+	case Disconnect:
+		return "DISCONNECT"
+	case Event:
+		return "EVENT"
+	case Ack:
+		return "ACK"
+	case ConnectError:
+		return "CONNECT_ERROR"
+	case BinaryEvent:
+		return "BINARY_EVENT"
+	case BinaryAck:
+		return "BINARY_ACK"
+	}
+
+	return fmt.Sprintf("PacketType(%d)", int(t))
+}
+
+// binary tells whether packets of the type have binary attachments.
+func (t PacketType) binary() bool {
+	return t == BinaryEvent || t == BinaryAck
+}
+
+// DefaultNamespace is the namespace of packets that don't have one.
+const DefaultNamespace = "/"
+
+var (
+	// ErrUnknownPacketType is returned for packets of an unknown type.
+	ErrUnknownPacketType = errors.New("socket.io unknown packet type")
+
+	// ErrInvalidPacket is returned for packets that don't follow the packet format.
+	ErrInvalidPacket = errors.New("socket.io invalid packet")
+)
+
+// Packet of Engine.IO, or of socket.io when EngineType is EngineMessage.
+//
+// A socket.io packet is encoded in a text frame as
+//
+//	4<type>[<attachments>-][<namespace>,][<ack id>][<data>]
+//
+// followed by a binary frame for each attachment.
+type Packet struct {
+	EngineType EnginePacketType
+
+	// Type of the socket.io packet.
+	Type PacketType
+
+	// Namespace of the socket.io packet. Empty is the same as DefaultNamespace.
+	Namespace string
+
+	// AckID of events waiting for an ack, and of acks, when HasAckID is set.
+	AckID    int
+	HasAckID bool
+
+	// Attachments is the number of binary attachments of binary events and acks.
+	// Buffers holds them, and the data has placeholders such as {"_placeholder":true,"num":0} in their place.
+	Attachments int
+	Buffers     [][]byte
+
+	// Data of the packet. For socket.io packets, it is JSON: the event name and its arguments for events,
+	// the arguments for acks, and the optional payload of connections and connection errors.
+	// For other Engine.IO packets, it is raw, such as the handshake of open packets or the "probe" of pings.
+	Data []byte
+}
+
+// MarshalText encodes the packet, without its attachments, as the content of a text frame.
+func (p Packet) MarshalText() ([]byte, error) {
+	if p.EngineType < EngineOpen || p.EngineType > EngineNoop {
+		return nil, ErrUnknownPacketType
+	}
+
+	var b = []byte{byte('0' + p.EngineType)}
+
+	if p.EngineType != EngineMessage {
+		return append(b, p.Data...), nil
+	}
+
+	if p.Type < Connect || p.Type > BinaryAck {
+		return nil, ErrUnknownPacketType
+	}
+
+	b = append(b, byte('0'+p.Type))
+
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+
+	if p.Type.binary() {
+		b = strconv.AppendInt(b, int64(p.Attachments), 10)
+		b = append(b, '-')
+	}
+
+	if p.Namespace != "" && p.Namespace != DefaultNamespace {
+		b = append(b, p.Namespace...)
+
+		if p.HasAckID || len(p.Data) != 0 {
+			b = append(b, ',')
+		}
+	}
+
+	if p.HasAckID {
+		b = strconv.AppendInt(b, int64(p.AckID), 10)
+	}
+
+	return append(b, p.Data...), nil
+}
+
+// validate a socket.io packet, so it is decoded back as it is.
+func (p Packet) validate() error {
+	if p.Namespace != "" && (p.Namespace[0] != '/' || strings.Contains(p.Namespace, ",")) {
+		return ErrInvalidPacket
+	}
+
+	if p.HasAckID && (p.AckID < 0 || p.Type == Connect || p.Type == Disconnect || p.Type == ConnectError) {
+		return ErrInvalidPacket
+	}
+
+	if p.Attachments < 0 || (p.Attachments != 0 && !p.Type.binary()) {
+		return ErrInvalidPacket
+	}
+
+	return validData(p.Type, p.HasAckID, p.Data)
+}
+
+// validData tells whether the data is valid for the packet type, like socket.io does.
+func validData(t PacketType, hasAckID bool, data []byte) error {
+	switch t {
+	case Disconnect:
+		if len(data) != 0 {
+			return ErrInvalidPacket
+		}
+	case Connect, ConnectError:
+		if len(data) != 0 && !json.Valid(data) {
+			return ErrInvalidPacket
+		}
+	case Event, BinaryEvent:
+		if !validEvent(data) {
+			return ErrInvalidPacket
+		}
+	case Ack, BinaryAck:
+		if !hasAckID || !isArray(data) {
+			return ErrInvalidPacket
+		}
+	}
+
+	return nil
+}
+
+const whitespace = " \t\r\n"
+
+func isArray(data []byte) bool {
+	return strings.HasPrefix(strings.TrimLeft(string(data), whitespace), "[") && json.Valid(data)
+}
+
+// validEvent tells whether the data is an array starting with the event name, either a string or a number.
+func validEvent(data []byte) bool {
+	if !isArray(data) {
+		return false
+	}
+
+	var values []json.RawMessage
+
+	if err := json.Unmarshal(data, &values); err != nil || len(values) == 0 {
+		return false
+	}
+
+	switch values[0][0] {
+	case '"', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		return true
+	}
+
+	return false
+}
+
+// UnmarshalText decodes a text frame into the packet. Attachments are read by the Decoder.
+func (p *Packet) UnmarshalText(text []byte) error {
+	*p = Packet{}
+
+	if len(text) == 0 || text[0] < '0' || text[0] > '6' {
+		return ErrUnknownPacketType
+	}
+
+	p.EngineType = EnginePacketType(text[0] - '0')
+	data := string(text[1:])
+
+	if p.EngineType != EngineMessage {
+		if data != "" {
+			p.Data = []byte(data)
+		}
+
+		return nil
+	}
+
+	if data == "" || data[0] < '0' || data[0] > '6' {
+		return ErrUnknownPacketType
+	}
+
+	p.Type = PacketType(data[0] - '0')
+	data = data[1:]
+
+	if p.Type.binary() {
+		pos := strings.IndexByte(data, '-')
+
+		if pos == -1 {
+			return ErrInvalidPacket
+		}
+
+		n, err := strconv.Atoi(data[:pos])
+
+		if err != nil || n < 0 {
+			return ErrInvalidPacket
+		}
+
+		p.Attachments, data = n, data[pos+1:]
+	}
+
+	p.Namespace, data = splitNamespace(data)
+
+	switch p.Type {
+	case Event, Ack, BinaryEvent, BinaryAck:
+		var ok bool
+
+		if p.AckID, data, ok = splitAckID(data); ok {
+			p.HasAckID = true
+		} else if p.AckID == -1 {
+			return ErrInvalidPacket
+		}
+	}
+
+	if data != "" {
+		p.Data = []byte(data)
+	}
+
+	// socket.io v2 servers may send a payload with namespace disconnections
+	if p.Type == Disconnect {
+		p.Data = nil
+	}
+
+	return validData(p.Type, p.HasAckID, p.Data)
+}
+
+// splitNamespace from the rest of the packet. The namespace goes up to the first comma.
+func splitNamespace(data string) (namespace string, rest string) {
+	if len(data) == 0 || data[0] != '/' {
+		return DefaultNamespace, data
+	}
+
+	pos := strings.IndexByte(data, ',')
+
+	if pos == -1 {
+		return data, ""
+	}
+
+	return data[:pos], data[pos+1:]
+}
+
+// splitAckID from the rest of the packet.
+// The ack ID is -1 if the digits don't fit an int.
+func splitAckID(data string) (ackID int, rest string, ok bool) {
+	var pos int
+
+	for pos < len(data) && data[pos] >= '0' && data[pos] <= '9' {
+		pos++
+	}
+
+	if pos == 0 {
+		return 0, data, false
+	}
+
+	ackID, err := strconv.Atoi(data[:pos])
+
+	if err != nil {
+		return -1, data, false
+	}
+
+	return ackID, data[pos:], true
+}
@@ -0,0 +1,116 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPacketText(t *testing.T) {
+	var cases = []struct {
+		text   string
+		packet Packet
+	}{
+		{`0{"sid":"abc"}`, Packet{EngineType: EngineOpen, Data: []byte(`{"sid":"abc"}`)}},
+		{`2probe`, Packet{EngineType: EnginePing, Data: []byte("probe")}},
+		{`6`, Packet{EngineType: EngineNoop}},
+		{`40`, Packet{EngineType: EngineMessage, Type: Connect, Namespace: "/"}},
+		{`40/admin,{"token":"abc"}`, Packet{EngineType: EngineMessage, Type: Connect, Namespace: "/admin", Data: []byte(`{"token":"abc"}`)}},
+		{`41/admin`, Packet{EngineType: EngineMessage, Type: Disconnect, Namespace: "/admin"}},
+		{`42["message","hi"]`, Packet{EngineType: EngineMessage, Type: Event, Namespace: "/", Data: []byte(`["message","hi"]`)}},
+		{`42/chat,12["book","JFK"]`, Packet{EngineType: EngineMessage, Type: Event, Namespace: "/chat", AckID: 12, HasAckID: true, Data: []byte(`["book","JFK"]`)}},
+		{`430[]`, Packet{EngineType: EngineMessage, Type: Ack, Namespace: "/", HasAckID: true, Data: []byte(`[]`)}},
+		{`44{"message":"Not authorized"}`, Packet{EngineType: EngineMessage, Type: ConnectError, Namespace: "/", Data: []byte(`{"message":"Not authorized"}`)}},
+		{
+			`452-/chat,3["upload",{"_placeholder":true,"num":0},{"_placeholder":true,"num":1}]`,
+			Packet{
+				EngineType:  EngineMessage,
+				Type:        BinaryEvent,
+				Namespace:   "/chat",
+				AckID:       3,
+				HasAckID:    true,
+				Attachments: 2,
+				Data:        []byte(`["upload",{"_placeholder":true,"num":0},{"_placeholder":true,"num":1}]`),
+			},
+		},
+		{`461-1[{"_placeholder":true,"num":0}]`, Packet{EngineType: EngineMessage, Type: BinaryAck, Namespace: "/", AckID: 1, HasAckID: true, Attachments: 1, Data: []byte(`[{"_placeholder":true,"num":0}]`)}},
+	}
+
+	for _, c := range cases {
+		var p Packet
+
+		if err := p.UnmarshalText([]byte(c.text)); err != nil {
+			t.Errorf("Expected no error decoding %v, got %v instead", c.text, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(p, c.packet) {
+			t.Errorf("Expected %v to be decoded as %+v, got %+v instead", c.text, c.packet, p)
+		}
+
+		text, err := c.packet.MarshalText()
+
+		if err != nil || string(text) != c.text {
+			t.Errorf("Expected %+v to be encoded as %v, got %s (%v) instead", c.packet, c.text, text, err)
+		}
+	}
+}
+
+func TestPacketTextErrors(t *testing.T) {
+	var cases = []struct {
+		text string
+		want error
+	}{
+		{``, ErrUnknownPacketType},
+		{`7`, ErrUnknownPacketType},
+		{`4`, ErrUnknownPacketType},
+		{`47[]`, ErrUnknownPacketType},
+		{`42`, ErrInvalidPacket},
+		{`42[]`, ErrInvalidPacket},
+		{`42[null]`, ErrInvalidPacket},
+		{`43["no id"]`, ErrInvalidPacket},
+		{`45["no attachments"]`, ErrInvalidPacket},
+		{`45x-["a"]`, ErrInvalidPacket},
+		{`4299999999999999999999["a"]`, ErrInvalidPacket},
+		{`40{`, ErrInvalidPacket},
+	}
+
+	for _, c := range cases {
+		var p Packet
+
+		if err := p.UnmarshalText([]byte(c.text)); err != c.want {
+			t.Errorf("Expected error decoding %q to be %v, got %v instead", c.text, c.want, err)
+		}
+	}
+
+	var invalid = []Packet{
+		{EngineType: EngineNoop + 1},
+		{EngineType: EngineMessage, Type: BinaryAck + 1},
+		{EngineType: EngineMessage, Type: Event, Namespace: "chat", Data: []byte(`["a"]`)},
+		{EngineType: EngineMessage, Type: Event, Namespace: "/a,b", Data: []byte(`["a"]`)},
+		{EngineType: EngineMessage, Type: Event, HasAckID: true, AckID: -1, Data: []byte(`["a"]`)},
+		{EngineType: EngineMessage, Type: Connect, HasAckID: true},
+		{EngineType: EngineMessage, Type: Event, Attachments: 1, Data: []byte(`["a"]`)},
+		{EngineType: EngineMessage, Type: Disconnect, Data: []byte(`{}`)},
+		{EngineType: EngineMessage, Type: Ack, Data: []byte(`[]`)},
+	}
+
+	for _, p := range invalid {
+		if text, err := p.MarshalText(); err == nil {
+			t.Errorf("Expected error encoding %+v, got %s instead", p, text)
+		}
+	}
+}
+
+func TestPacketTypeString(t *testing.T) {
+	if got := BinaryEvent.String(); got != "BINARY_EVENT" {
+		t.Errorf("Expected packet type to be BINARY_EVENT, got %v instead", got)
+	}
+
+	if got := EngineNoop.String(); got != "noop" {
+		t.Errorf("Expected Engine.IO packet type to be noop, got %v instead", got)
+	}
+
+	if got := PacketType(9).String(); got != "PacketType(9)" {
+		t.Errorf("Expected unknown packet type to be PacketType(9), got %v instead", got)
+	}
+}
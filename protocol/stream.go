@@ -0,0 +1,137 @@
+package protocol
+
+import (
+	"errors"
+)
+
+// ErrUnexpectedAttachment is returned when a binary frame arrives while no packet is waiting for attachments.
+var ErrUnexpectedAttachment = errors.New("socket.io unexpected binary attachment")
+
+// Frame of a transport, either text or binary.
+type Frame struct {
+	Data   []byte
+	Binary bool
+}
+
+// FrameReader reads frames from a transport, such as a WebSocket connection.
+type FrameReader interface {
+	ReadFrame() (Frame, error)
+}
+
+// FrameWriter writes frames to a transport, such as a WebSocket connection.
+type FrameWriter interface {
+	WriteFrame(Frame) error
+}
+
+// binaryMessage prefixes binary frames with Engine.IO v3; later versions send them as they are.
+const binaryMessage = byte(EngineMessage)
+
+// Decoder reads packets from a stream of frames.
+type Decoder struct {
+	r        FrameReader
+	engineIO int
+}
+
+// NewDecoder for the frames of the given Engine.IO protocol revision, such as 3 for socket.io v2 servers.
+func NewDecoder(r FrameReader, engineIO int) *Decoder {
+	return &Decoder{
+		r:        r,
+		engineIO: engineIO,
+	}
+}
+
+// Decode the next packet, reading the frames of its attachments, if any.
+func (d *Decoder) Decode() (*Packet, error) {
+	f, err := d.r.ReadFrame()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Binary {
+		return nil, ErrUnexpectedAttachment
+	}
+
+	var p Packet
+
+	if err := p.UnmarshalText(f.Data); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < p.Attachments; i++ {
+		b, err := d.readAttachment()
+
+		if err != nil {
+			return nil, err
+		}
+
+		p.Buffers = append(p.Buffers, b)
+	}
+
+	return &p, nil
+}
+
+func (d *Decoder) readAttachment() ([]byte, error) {
+	f, err := d.r.ReadFrame()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !f.Binary {
+		return nil, ErrInvalidPacket
+	}
+
+	if d.engineIO >= 4 {
+		return f.Data, nil
+	}
+
+	if len(f.Data) == 0 || f.Data[0] != binaryMessage {
+		return nil, ErrInvalidPacket
+	}
+
+	return f.Data[1:], nil
+}
+
+// Encoder writes packets to a stream of frames.
+type Encoder struct {
+	w        FrameWriter
+	engineIO int
+}
+
+// NewEncoder for the frames of the given Engine.IO protocol revision, such as 3 for socket.io v2 servers.
+func NewEncoder(w FrameWriter, engineIO int) *Encoder {
+	return &Encoder{
+		w:        w,
+		engineIO: engineIO,
+	}
+}
+
+// Encode the packet, writing its attachments after it, if any.
+func (e *Encoder) Encode(p *Packet) error {
+	if len(p.Buffers) != p.Attachments {
+		return ErrInvalidPacket
+	}
+
+	text, err := p.MarshalText()
+
+	if err != nil {
+		return err
+	}
+
+	if err := e.w.WriteFrame(Frame{Data: text}); err != nil {
+		return err
+	}
+
+	for _, b := range p.Buffers {
+		if e.engineIO < 4 {
+			b = append([]byte{binaryMessage}, b...)
+		}
+
+		if err := e.w.WriteFrame(Frame{Data: b, Binary: true}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package protocol
+
+import (
+	"io"
+	"reflect"
+	"testing"
+)
+
+// frames is an in-memory stream of frames.
+type frames []Frame
+
+func (f *frames) ReadFrame() (Frame, error) {
+	if len(*f) == 0 {
+		return Frame{}, io.EOF
+	}
+
+	next := (*f)[0]
+	*f = (*f)[1:]
+	return next, nil
+}
+
+func (f *frames) WriteFrame(frame Frame) error {
+	*f = append(*f, frame)
+	return nil
+}
+
+func TestEncoderDecoder(t *testing.T) {
+	var packets = []*Packet{
+		{EngineType: EngineMessage, Type: Connect, Namespace: "/"},
+		{EngineType: EngineMessage, Type: Event, Namespace: "/chat", Data: []byte(`["message","hi"]`)},
+		{
+			EngineType:  EngineMessage,
+			Type:        BinaryEvent,
+			Namespace:   "/",
+			AckID:       4,
+			HasAckID:    true,
+			Attachments: 2,
+			Buffers:     [][]byte{{1, 2, 3}, {4}},
+			Data:        []byte(`["upload",{"_placeholder":true,"num":0},{"_placeholder":true,"num":1}]`),
+		},
+		{EngineType: EnginePong},
+	}
+
+	for _, engineIO := range []int{3, 4} {
+		var stream frames
+
+		enc := NewEncoder(&stream, engineIO)
+
+		for _, p := range packets {
+			if err := enc.Encode(p); err != nil {
+				t.Fatalf("Expected no error encoding %+v, got %v instead", p, err)
+			}
+		}
+
+		if len(stream) != 6 {
+			t.Errorf("Expected 6 frames, got %d instead", len(stream))
+		}
+
+		if got := stream[3].Data[0]; engineIO == 3 && got != 4 {
+			t.Errorf("Expected Engine.IO v3 attachment to be prefixed with 4, got %v instead", got)
+		}
+
+		dec := NewDecoder(&stream, engineIO)
+
+		for _, want := range packets {
+			got, err := dec.Decode()
+
+			if err != nil {
+				t.Fatalf("Expected no error decoding, got %v instead", err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Expected packet to be %+v, got %+v instead", want, got)
+			}
+		}
+
+		if _, err := dec.Decode(); err != io.EOF {
+			t.Errorf("Expected error to be %v, got %v instead", io.EOF, err)
+		}
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	var cases = []struct {
+		stream frames
+		want   error
+	}{
+		{frames{{Data: []byte{1}, Binary: true}}, ErrUnexpectedAttachment},
+		{frames{{Data: []byte(`451-["a",{"_placeholder":true,"num":0}]`)}, {Data: []byte(`42["b"]`)}}, ErrInvalidPacket},
+		{frames{{Data: []byte(`451-["a",{"_placeholder":true,"num":0}]`)}}, io.EOF},
+	}
+
+	for _, c := range cases {
+		if _, err := NewDecoder(&c.stream, 4).Decode(); err != c.want {
+			t.Errorf("Expected error to be %v, got %v instead", c.want, err)
+		}
+	}
+
+	var stream frames
+
+	err := NewEncoder(&stream, 4).Encode(&Packet{
+		EngineType:  EngineMessage,
+		Type:        BinaryEvent,
+		Attachments: 1,
+		Data:        []byte(`["a"]`),
+	})
+
+	if err != ErrInvalidPacket {
+		t.Errorf("Expected error encoding packet without its attachment to be %v, got %v instead", ErrInvalidPacket, err)
+	}
+}
@@ -0,0 +1,311 @@
+package gosocketio
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/wedeploy/gosocketio/clock"
+	"github.com/wedeploy/gosocketio/websocket"
+)
+
+const (
+	// WriteQueueSize is the default number of messages waiting to be written.
+	WriteQueueSize = 1024
+
+	// writeBatchSize is the maximum number of messages coalesced into a single write.
+	writeBatchSize = 64
+
+	// writeBatchBytes bounds the messages of a batch, so heartbeats aren't kept waiting behind a large write.
+	// A single message larger than that is still written on its own.
+	writeBatchBytes = 64 << 10
+)
+
+// ErrWriteQueueFull is returned when a message can't be queued because the write queue is full.
+var ErrWriteQueueFull = errors.New("socket.io write queue is full")
+
+// QueueFull policy for when the write queue is full.
+type QueueFull int
+
+const (
+	// QueueBlock waits for room in the queue, up to Options.WriteQueueTimeout, if set.
+	QueueBlock QueueFull = iota
+
+	// QueueReject refuses new messages with ErrWriteQueueFull.
+	QueueReject
+)
+
+type msgWriter struct {
+	frame websocket.Frame
+	err   error
+	done  chan struct{}
+}
+
+func newMsgWriter(frame websocket.Frame) *msgWriter {
+	return &msgWriter{
+		frame: frame,
+		done:  make(chan struct{}),
+	}
+}
+
+// textFrame for the given packet.
+func textFrame(packet string) websocket.Frame {
+	return websocket.Frame{
+		Data: []byte(packet),
+	}
+}
+
+func (m *msgWriter) finish(err error) {
+	m.err = err
+	close(m.done)
+}
+
+// writeQueue holds the messages waiting for the out loop.
+// Control packets have a lane of their own, which is written first and isn't bounded.
+type writeQueue struct {
+	size    int
+	full    QueueFull
+	timeout time.Duration
+	clock   clock.Clock
+
+	control  []*msgWriter
+	data     []*msgWriter
+	buffered int
+	err      error
+
+	// held while the connection is lost, so messages aren't written before their namespace is joined again
+	held bool
+
+	// ready signals the out loop, and space is closed when room is made in the data lane
+	ready chan struct{}
+	space chan struct{}
+
+	locker sync.Mutex
+}
+
+func newWriteQueue(size int, full QueueFull, timeout time.Duration, clk clock.Clock) *writeQueue {
+	return &writeQueue{
+		size:    size,
+		full:    full,
+		timeout: timeout,
+		clock:   clk,
+		ready:   make(chan struct{}, 1),
+		space:   make(chan struct{}),
+	}
+}
+
+// push a message, waiting for room in the queue according to the policy.
+func (q *writeQueue) push(ctx context.Context, mw *msgWriter, control bool) error {
+	var expired <-chan time.Time
+
+	for {
+		q.locker.Lock()
+
+		if q.err != nil {
+			q.locker.Unlock()
+			return q.err
+		}
+
+		if control || len(q.data) < q.size {
+			q.add(mw, control)
+			q.locker.Unlock()
+			return nil
+		}
+
+		if q.full == QueueReject {
+			q.locker.Unlock()
+			return ErrWriteQueueFull
+		}
+
+		space := q.space
+		q.locker.Unlock()
+
+		if expired == nil && q.timeout > 0 {
+			timer := q.clock.NewTimer(q.timeout)
+			defer timer.Stop()
+			expired = timer.C()
+		}
+
+		select {
+		case <-space:
+		case <-expired:
+			return ErrWriteQueueFull
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// enqueue a message without waiting for room in the queue, for messages that must not block, such as flushed ones.
+func (q *writeQueue) enqueue(mw *msgWriter) error {
+	q.locker.Lock()
+	defer q.locker.Unlock()
+
+	if q.err != nil {
+		return q.err
+	}
+
+	q.add(mw, false)
+	return nil
+}
+
+// tryPush queues a message only if it doesn't have to wait behind other messages.
+func (q *writeQueue) tryPush(mw *msgWriter) bool {
+	q.locker.Lock()
+	defer q.locker.Unlock()
+
+	if q.err != nil || q.held || len(q.data) != 0 {
+		return false
+	}
+
+	q.add(mw, false)
+	return true
+}
+
+// add must be called with the lock held.
+func (q *writeQueue) add(mw *msgWriter, control bool) {
+	if control {
+		q.control = append(q.control, mw)
+	} else {
+		q.data = append(q.data, mw)
+	}
+
+	q.buffered += len(mw.frame.Data)
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop the next batch of messages to write, control packets first.
+func (q *writeQueue) pop() []*msgWriter {
+	q.locker.Lock()
+	defer q.locker.Unlock()
+
+	var batch = q.control
+	q.control = nil
+
+	if room := writeBatchSize - len(batch); room > 0 && len(q.data) != 0 && !q.held {
+		var n, size int
+
+		for n < room && n < len(q.data) && (n == 0 || size+len(q.data[n].frame.Data) <= writeBatchBytes) {
+			size += len(q.data[n].frame.Data)
+			n++
+		}
+
+		batch = append(batch, q.data[:n]...)
+		q.data = q.data[n:]
+
+		close(q.space)
+		q.space = make(chan struct{})
+	}
+
+	if len(q.data) != 0 && !q.held {
+		select {
+		case q.ready <- struct{}{}:
+		default:
+		}
+	}
+
+	return batch
+}
+
+// written releases the buffered amount of a batch.
+func (q *writeQueue) written(batch []*msgWriter) {
+	q.locker.Lock()
+
+	// closing the queue already released everything
+	if q.err == nil {
+		for _, mw := range batch {
+			q.buffered -= len(mw.frame.Data)
+		}
+	}
+
+	q.locker.Unlock()
+}
+
+// bufferedAmount is the number of bytes queued or being written.
+func (q *writeQueue) bufferedAmount() int {
+	q.locker.Lock()
+	b := q.buffered
+	q.locker.Unlock()
+	return b
+}
+
+// interrupt fails the queued messages, which were meant for the connection that was lost,
+// and holds the data lane until resume is called, once the namespaces are joined again.
+// Control packets, such as the connection packets of the namespaces, are still written meanwhile.
+func (q *writeQueue) interrupt(err error) {
+	q.locker.Lock()
+	queued := append(q.control, q.data...)
+	q.control, q.data = nil, nil
+	q.held = true
+
+	for _, mw := range queued {
+		q.buffered -= len(mw.frame.Data)
+	}
+
+	close(q.space)
+	q.space = make(chan struct{})
+	q.locker.Unlock()
+
+	for _, mw := range queued {
+		mw.finish(err)
+	}
+}
+
+// resume writing the data lane after an interruption.
+func (q *writeQueue) resume() {
+	q.locker.Lock()
+	q.held = false
+
+	if len(q.data) != 0 {
+		select {
+		case q.ready <- struct{}{}:
+		default:
+		}
+	}
+
+	q.locker.Unlock()
+}
+
+// remove a message that wasn't taken by the out loop yet.
+func (q *writeQueue) remove(mw *msgWriter) bool {
+	q.locker.Lock()
+	defer q.locker.Unlock()
+
+	for _, lane := range []*[]*msgWriter{&q.control, &q.data} {
+		for i, queued := range *lane {
+			if queued == mw {
+				*lane = append((*lane)[:i:i], (*lane)[i+1:]...)
+				q.buffered -= len(mw.frame.Data)
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// close fails the queued messages and the new ones with err.
+func (q *writeQueue) close(err error) {
+	q.locker.Lock()
+
+	if q.err != nil {
+		q.locker.Unlock()
+		return
+	}
+
+	q.err = err
+	queued := append(q.control, q.data...)
+	q.control, q.data = nil, nil
+	q.buffered = 0
+	close(q.space)
+	q.locker.Unlock()
+
+	for _, mw := range queued {
+		mw.finish(err)
+	}
+}
@@ -0,0 +1,192 @@
+package gosocketio
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wedeploy/gosocketio/clock"
+	"github.com/wedeploy/gosocketio/websocket"
+)
+
+func TestWriteQueuePriority(t *testing.T) {
+	q := newWriteQueue(10, QueueBlock, 0, clock.Real)
+
+	for _, m := range []string{`42["a"]`, `42["b"]`} {
+		if err := q.push(context.Background(), newMsgWriter(textFrame(m)), false); err != nil {
+			t.Errorf("Expected no error pushing message, got %v instead", err)
+		}
+	}
+
+	if err := q.push(context.Background(), newMsgWriter(textFrame("3")), true); err != nil {
+		t.Errorf("Expected no error pushing control packet, got %v instead", err)
+	}
+
+	if b := q.bufferedAmount(); b != 15 {
+		t.Errorf("Expected buffered amount to be 15, got %v instead", b)
+	}
+
+	batch := q.pop()
+
+	var want = []string{"3", `42["a"]`, `42["b"]`}
+
+	if len(batch) != len(want) {
+		t.Fatalf("Expected batch to have %d messages, got %d instead", len(want), len(batch))
+	}
+
+	for i, mw := range batch {
+		if got := string(mw.frame.Data); got != want[i] {
+			t.Errorf("Expected message %d to be %v, got %v instead", i, want[i], got)
+		}
+	}
+
+	q.written(batch)
+
+	if b := q.bufferedAmount(); b != 0 {
+		t.Errorf("Expected buffered amount to be 0, got %v instead", b)
+	}
+}
+
+func TestWriteQueueBatchSize(t *testing.T) {
+	q := newWriteQueue(writeBatchSize*2, QueueBlock, 0, clock.Real)
+
+	for i := 0; i < writeBatchSize+1; i++ {
+		_ = q.push(context.Background(), newMsgWriter(textFrame("m")), false)
+	}
+
+	if l := len(q.pop()); l != writeBatchSize {
+		t.Errorf("Expected batch to have %d messages, got %d instead", writeBatchSize, l)
+	}
+
+	select {
+	case <-q.ready:
+	default:
+		t.Error("Expected queue to signal the remaining message")
+	}
+
+	if l := len(q.pop()); l != 1 {
+		t.Errorf("Expected batch to have 1 message, got %d instead", l)
+	}
+}
+
+func TestWriteQueueBatchBytes(t *testing.T) {
+	q := newWriteQueue(10, QueueBlock, 0, clock.Real)
+	large := make([]byte, writeBatchBytes/2)
+
+	for i := 0; i < 3; i++ {
+		_ = q.push(context.Background(), newMsgWriter(websocket.Frame{Data: large}), false)
+	}
+
+	if l := len(q.pop()); l != 2 {
+		t.Errorf("Expected batch to have 2 messages, got %d instead", l)
+	}
+
+	_ = q.push(context.Background(), newMsgWriter(websocket.Frame{Data: make([]byte, writeBatchBytes*2)}), false)
+
+	// a message larger than the limit is still written, but on its own
+	for _, want := range []int{1, 1} {
+		if l := len(q.pop()); l != want {
+			t.Errorf("Expected batch to have %d messages, got %d instead", want, l)
+		}
+	}
+}
+
+func TestWriteQueueFull(t *testing.T) {
+	q := newWriteQueue(1, QueueReject, 0, clock.Real)
+	_ = q.push(context.Background(), newMsgWriter(textFrame("a")), false)
+
+	if err := q.push(context.Background(), newMsgWriter(textFrame("b")), false); err != ErrWriteQueueFull {
+		t.Errorf("Expected error to be %v, got %v instead", ErrWriteQueueFull, err)
+	}
+
+	if err := q.push(context.Background(), newMsgWriter(textFrame("c")), true); err != nil {
+		t.Errorf("Expected control packets not to be limited, got %v instead", err)
+	}
+
+	q = newWriteQueue(1, QueueBlock, 10*time.Millisecond, clock.Real)
+	_ = q.push(context.Background(), newMsgWriter(textFrame("a")), false)
+
+	if err := q.push(context.Background(), newMsgWriter(textFrame("b")), false); err != ErrWriteQueueFull {
+		t.Errorf("Expected error to be %v, got %v instead", ErrWriteQueueFull, err)
+	}
+}
+
+func TestWriteQueueBlock(t *testing.T) {
+	q := newWriteQueue(1, QueueBlock, 0, clock.Real)
+	_ = q.push(context.Background(), newMsgWriter(textFrame("a")), false)
+
+	pushed := make(chan error, 1)
+
+	go func() {
+		pushed <- q.push(context.Background(), newMsgWriter(textFrame("b")), false)
+	}()
+
+	select {
+	case <-pushed:
+		t.Fatal("Expected push to wait for room in the queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	q.pop()
+
+	if err := <-pushed; err != nil {
+		t.Errorf("Expected no error pushing message, got %v instead", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := q.push(ctx, newMsgWriter(textFrame("c")), false); err != context.Canceled {
+		t.Errorf("Expected error to be %v, got %v instead", context.Canceled, err)
+	}
+}
+
+func TestWriteQueueClose(t *testing.T) {
+	q := newWriteQueue(1, QueueBlock, 0, clock.Real)
+	queued := newMsgWriter(textFrame("a"))
+	_ = q.push(context.Background(), queued, false)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		if err := q.push(context.Background(), newMsgWriter(textFrame("b")), false); err != ErrClientClosed {
+			t.Errorf("Expected error to be %v, got %v instead", ErrClientClosed, err)
+		}
+	}()
+
+	q.close(ErrClientClosed)
+	q.close(ErrClientClosed)
+	<-queued.done
+
+	if queued.err != ErrClientClosed {
+		t.Errorf("Expected queued message to fail with %v, got %v instead", ErrClientClosed, queued.err)
+	}
+
+	wg.Wait()
+}
+
+func TestClientBufferedAmount(t *testing.T) {
+	c := New(url.URL{}, nil)
+	defer c.Close()
+
+	// there is no out loop, so messages stay in the queue
+	c.setState(StateConnected, nil)
+	c.def().setReady()
+
+	go func() {
+		_ = c.Emit("progress", 1)
+	}()
+
+	for i := 0; i < 100 && c.BufferedAmount() == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	if b, want := c.BufferedAmount(), len(`2["progress",1]`); b != want {
+		t.Errorf("Expected buffered amount to be %v, got %v instead", want, b)
+	}
+}
@@ -0,0 +1,123 @@
+package gosocketio
+
+import (
+	"time"
+)
+
+const (
+	// ReconnectDelay is the default delay before the first reconnection attempt.
+	ReconnectDelay = time.Second
+
+	// ReconnectDelayMax is the default maximum delay between reconnection attempts.
+	ReconnectDelayMax = 5 * time.Second
+)
+
+// lost handles a connection that was lost, reconnecting if enabled.
+func (c *Client) lost(cause error) {
+	if c.isClosing() {
+		c.close(cause)
+		return
+	}
+
+	if !c.opts.Reconnect {
+		// pending acks fail with ErrConnectionLost, like when reconnecting, rather than ErrClientClosed
+		c.getAck().Interrupt()
+		c.close(cause)
+		return
+	}
+
+	c.setState(StateReconnecting, cause)
+
+	c.connLocker.Lock()
+	c.connCancel()
+	c.conn.Close()
+	c.connLocker.Unlock()
+
+	// acks for messages written to the lost connection are never going to arrive
+	c.getAck().Interrupt()
+	c.queue.interrupt(ErrConnectionLost)
+
+	c.namespacesLocker.RLock()
+	var interrupted []string
+
+	for name, n := range c.namespaces {
+		// on socket.io v2, the default namespace is joined by the open packet
+		deferJoin := name != defaultNamespace || c.opts.EngineIO >= 4
+
+		if n.interrupt(cause, deferJoin) && name != defaultNamespace {
+			interrupted = append(interrupted, name)
+		}
+	}
+
+	c.namespacesLocker.RUnlock()
+
+	for _, name := range interrupted {
+		c.callLoopEvent(name, OnDisconnect)
+	}
+
+	c.callLoopEvent(defaultNamespace, OnDisconnect)
+
+	go c.reconnect(cause)
+}
+
+func (c *Client) reconnect(cause error) {
+	for {
+		attempt := c.nextReconnect()
+
+		if max := c.opts.ReconnectAttempts; max != 0 && attempt > max {
+			c.close(cause)
+			return
+		}
+
+		timer := c.opts.Clock.NewTimer(c.reconnectDelay(attempt))
+
+		select {
+		case <-timer.C():
+		case <-c.ctx.Done():
+			timer.Stop()
+			return
+		}
+
+		err := c.connect(c.ctx)
+
+		if err == nil {
+			return
+		}
+
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		cause = err
+		c.setState(StateReconnecting, cause)
+	}
+}
+
+// reconnectDelay doubles for each attempt, up to ReconnectDelayMax.
+func (c *Client) reconnectDelay(attempt int) time.Duration {
+	delay := c.opts.ReconnectDelay
+
+	for i := 1; i < attempt && delay < c.opts.ReconnectDelayMax; i++ {
+		delay *= 2
+	}
+
+	if delay > c.opts.ReconnectDelayMax {
+		delay = c.opts.ReconnectDelayMax
+	}
+
+	return delay
+}
+
+func (c *Client) nextReconnect() int {
+	c.stateLocker.Lock()
+	c.reconnects++
+	attempt := c.reconnects
+	c.stateLocker.Unlock()
+	return attempt
+}
+
+func (c *Client) resetReconnects() {
+	c.stateLocker.Lock()
+	c.reconnects = 0
+	c.stateLocker.Unlock()
+}
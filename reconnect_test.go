@@ -0,0 +1,401 @@
+package gosocketio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/wedeploy/gosocketio/clock"
+	"github.com/wedeploy/gosocketio/codec"
+	"github.com/wedeploy/gosocketio/engineio"
+	"github.com/wedeploy/gosocketio/transport"
+	"github.com/wedeploy/gosocketio/transport/fault"
+)
+
+func TestReconnectDelay(t *testing.T) {
+	c := New(url.URL{}, &Options{
+		ReconnectDelay:    100 * time.Millisecond,
+		ReconnectDelayMax: time.Second,
+	})
+
+	var want = []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+
+	for i, w := range want {
+		if got := c.reconnectDelay(i + 1); got != w {
+			t.Errorf("Expected delay for attempt %d to be %v, got %v instead", i+1, w, got)
+		}
+	}
+}
+
+func TestReconnectRecoversSession(t *testing.T) {
+	var connections = make(chan int, 2)
+	var received = make(chan string, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var upgrader ws.Upgrader
+		conn, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			t.Errorf("Expected no error upgrading connection, got %v instead", err)
+			return
+		}
+
+		defer conn.Close()
+
+		if r.URL.Query().Get("EIO") != "4" {
+			t.Errorf("Expected EIO=4, got %v instead", r.URL.RawQuery)
+		}
+
+		_ = conn.WriteMessage(ws.TextMessage, []byte(`0{"sid":"abc","upgrades":[],"pingInterval":25000,"pingTimeout":60000}`))
+
+		_, data, err := conn.ReadMessage()
+
+		if err != nil {
+			return
+		}
+
+		received <- string(data)
+
+		switch len(connections) {
+		case 0:
+			connections <- 1
+			_ = conn.WriteMessage(ws.TextMessage, []byte(`40{"sid":"s1","pid":"p1"}`))
+			_ = conn.WriteMessage(ws.TextMessage, []byte(`42["log","line 1","offset-1"]`))
+			// drop the connection without a close packet
+		default:
+			connections <- 2
+			_ = conn.WriteMessage(ws.TextMessage, []byte(`40{"sid":"s2","pid":"p1"}`))
+			_ = conn.WriteMessage(ws.TextMessage, []byte(`42["log","line 2","offset-2"]`))
+
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}
+	}))
+
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	u.Scheme = "ws"
+
+	c := New(*u, &Options{
+		EngineIO:       4,
+		Reconnect:      true,
+		ReconnectDelay: 10 * time.Millisecond,
+	})
+
+	defer c.Close()
+
+	lines := make(chan string, 10)
+
+	if err := c.On("log", func(line string) {
+		lines <- line
+	}); err != nil {
+		t.Fatalf("Expected no error registering listener, got %v instead", err)
+	}
+
+	transitions := make(chan Transition, 20)
+	c.NotifyState(transitions)
+
+	if err := c.Open(context.Background()); err != nil {
+		t.Fatalf("Expected no error opening connection, got %v instead", err)
+	}
+
+	if got := <-received; got != "40" {
+		t.Errorf("Expected connection packet to be 40, got %v instead", got)
+	}
+
+	for _, want := range []string{"line 1", "line 2"} {
+		select {
+		case got := <-lines:
+			if got != want {
+				t.Errorf("Expected line to be %v, got %v instead", want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected line %v to be received", want)
+		}
+	}
+
+	if got, want := <-received, `40{"offset":"offset-1","pid":"p1"}`; got != want {
+		t.Errorf("Expected connection packet to be %v, got %v instead", want, got)
+	}
+
+	if !c.Recovered() {
+		t.Error("Expected session to be recovered")
+	}
+
+	if id := c.def().ID(); id != "s2" {
+		t.Errorf("Expected namespace ID to be s2, got %v instead", id)
+	}
+
+	var reconnecting bool
+
+	for len(transitions) != 0 {
+		if tr := <-transitions; tr.Namespace == nil && tr.To == StateReconnecting {
+			reconnecting = true
+		}
+	}
+
+	if !reconnecting {
+		t.Error("Expected client to go through the reconnecting state")
+	}
+}
+
+func TestRecoverAuthCodec(t *testing.T) {
+	// the auth payload is merged with the session to recover using the codec of the client, such as MessagePack
+	n := &Namespace{
+		codec: codec.MsgPack,
+		opts: &NamespaceOptions{
+			Auth: struct {
+				Token string `msgpack:"token" json:"-"`
+			}{"abc"},
+		},
+		pid:    "p1",
+		offset: "o1",
+	}
+
+	auth, err := n.auth()
+
+	if err != nil {
+		t.Fatalf("Expected no error building auth payload, got %v instead", err)
+	}
+
+	want := map[string]interface{}{"token": "abc", "pid": "p1", "offset": "o1"}
+
+	if !reflect.DeepEqual(auth, want) {
+		t.Errorf("Expected auth payload to be %v, got %v instead", want, auth)
+	}
+}
+
+func TestReconnectGivesUp(t *testing.T) {
+	m := newMockServer(t)
+
+	c := New(m.URL(), &Options{
+		Reconnect:         true,
+		ReconnectAttempts: 2,
+		ReconnectDelay:    time.Millisecond,
+	})
+
+	if err := c.Open(context.Background()); err != nil {
+		t.Fatalf("Expected no error opening connection, got %v instead", err)
+	}
+
+	transitions := make(chan Transition, 20)
+	c.NotifyState(transitions)
+
+	// drop the current connection and stop accepting new ones
+	conn := <-m.conns
+	_ = conn.Close()
+	m.Close()
+
+	for {
+		select {
+		case got := <-transitions:
+			if got.Namespace == nil && got.To == StateClosed {
+				if got.Cause == nil {
+					t.Error("Expected cause of closing to be the last reconnection error")
+				}
+
+				return
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected client to give up reconnecting")
+		}
+	}
+}
+
+func TestReconnectClock(t *testing.T) {
+	epoch := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := clock.NewFake(epoch)
+	p := transport.NewPipe()
+	defer p.Close()
+
+	handshake := `0{"sid":"abc","upgrades":[],"pingInterval":25000,"pingTimeout":20000}`
+
+	c, _ := openPipe(t, p, &Options{
+		Transport:      p,
+		Clock:          f,
+		Reconnect:      true,
+		ReconnectDelay: time.Second,
+	}, handshake)
+
+	defer c.Close()
+
+	transitions := make(chan Transition, 20)
+	c.NotifyState(transitions)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// the server stops pinging, so the session times out, and then the client waits to reconnect
+	f.Advance(45 * time.Second)
+
+	got := <-transitions
+
+	if got.To != StateReconnecting || got.Cause != engineio.ErrPingTimeout || !got.At.Equal(epoch.Add(45*time.Second)) {
+		t.Errorf("Expected to be reconnecting after a ping timeout at %v, got %+v instead", epoch.Add(45*time.Second), got)
+	}
+
+	// nothing is dialed until the delay elapses, which doubles after each failed attempt
+	for _, delay := range []time.Duration{time.Second, 2 * time.Second} {
+		if err := f.WaitTimers(ctx, 1); err != nil {
+			t.Fatalf("Expected reconnection delay to start, got %v instead", err)
+		}
+
+		f.Advance(delay - time.Millisecond)
+
+		early, cancelEarly := context.WithTimeout(context.Background(), 10*time.Millisecond)
+
+		if _, err := p.Accept(early); err != context.DeadlineExceeded {
+			t.Errorf("Expected no reconnection before %v, got %v instead", delay, err)
+		}
+
+		cancelEarly()
+		f.Advance(time.Millisecond)
+
+		peer, err := p.Accept(ctx)
+
+		if err != nil {
+			t.Fatalf("Expected reconnection after %v, got %v instead", delay, err)
+		}
+
+		if delay == time.Second {
+			peer.Close()
+			continue
+		}
+
+		_ = peer.WriteFrame(transport.Frame{Data: []byte(handshake)})
+		expectFrame(t, peer, "40")
+		_ = peer.WriteFrame(transport.Frame{Data: []byte(`40{"sid":"def"}`)})
+	}
+
+	for {
+		select {
+		case got := <-transitions:
+			if got.Namespace == nil && got.To == StateConnected {
+				return
+			}
+		case <-ctx.Done():
+			t.Fatal("Expected client to reconnect")
+		}
+	}
+}
+
+func TestReconnectHoldsMessages(t *testing.T) {
+	const handshake = `0{"sid":"abc","upgrades":[],"pingInterval":25000,"pingTimeout":20000}`
+
+	p := transport.NewPipe()
+	defer p.Close()
+
+	ft := fault.New(p)
+	c, peer := openPipe(t, p, &Options{
+		Transport:      ft,
+		Reconnect:      true,
+		ReconnectDelay: time.Millisecond,
+	}, handshake)
+
+	defer c.Close()
+
+	ns, err := c.Of("/shell")
+
+	if err != nil {
+		t.Fatalf("Expected no error joining namespace, got %v instead", err)
+	}
+
+	expectFrame(t, peer, "40/shell")
+	_ = peer.WriteFrame(transport.Frame{Data: []byte(`40/shell`)})
+	<-ns.Ready()
+
+	// a message waits in the queue while another one is being written when the connection is cut
+	ft.Outgoing(fault.Event("slow")).Delay(time.Second)
+
+	go func() {
+		_ = ns.Emit("slow")
+	}()
+
+	slow, queued := len(`42/shell,["slow"]`), len(`42/shell,["queued"]`)
+
+	for i := 0; i < 100 && c.BufferedAmount() != slow; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	queuedErr := make(chan error, 1)
+
+	go func() {
+		queuedErr <- ns.Emit("queued")
+	}()
+
+	for i := 0; i < 100 && c.BufferedAmount() != slow+queued; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	transitions := make(chan Transition, 10)
+	ns.NotifyState(transitions)
+	ft.Cut()
+
+	// it fails either with the write error or with ErrConnectionLost, but is never written to the new connection
+	select {
+	case err := <-queuedErr:
+		if err == nil {
+			t.Error("Expected queued message to fail when the connection is lost")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected queued message to fail when the connection is lost")
+	}
+
+	for tr := range transitions {
+		if tr.To == StateConnecting {
+			break
+		}
+	}
+
+	// sent while reconnecting, it must wait for the namespace to be joined again
+	heldErr := make(chan error, 1)
+
+	go func() {
+		heldErr <- ns.Emit("held", "x")
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if peer, err = p.Accept(ctx); err != nil {
+		t.Fatalf("Expected reconnection, got %v instead", err)
+	}
+
+	_ = peer.WriteFrame(transport.Frame{Data: []byte(handshake)})
+
+	var got []string
+
+	for i := 0; i < 3; i++ {
+		f, err := peer.ReadFrame()
+
+		if err != nil {
+			t.Fatalf("Expected peer to receive frames, got %v instead", err)
+		}
+
+		got = append(got, string(f.Data))
+	}
+
+	if got[2] != `42/shell,["held","x"]` {
+		t.Errorf("Expected held message to be written after the connection packets, got %q instead", got)
+	}
+
+	if err := <-heldErr; err != nil {
+		t.Errorf("Expected no error emitting held message, got %v instead", err)
+	}
+}
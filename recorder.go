@@ -0,0 +1,243 @@
+package gosocketio
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/wedeploy/gosocketio/codec"
+	"github.com/wedeploy/gosocketio/internal/protocol"
+)
+
+// Emitted event recorded by a Recorder.
+type Emitted struct {
+	// Namespace of the event, empty for the default namespace.
+	Namespace string
+	Method    string
+	Args      []interface{}
+
+	// Ack is set for events emitted with Ack or AckWithOptions.
+	Ack bool
+}
+
+// Recorder is a fake Socket and Namespaces for testing code that uses a client, without a server.
+// It records the events emitted, answers acks with the values set with AckWith,
+// and calls the handlers registered with On when an event is triggered,
+// converting its arguments with the codec like the client does.
+type Recorder struct {
+	namespace string
+	rec       *recording
+}
+
+// recording shared by the Recorders of all the namespaces.
+type recording struct {
+	codec    codec.Codec
+	emitted  []Emitted
+	handlers map[location]*Handler
+	acks     map[location][]interface{}
+
+	locker sync.Mutex
+}
+
+// NewRecorder creates a Recorder for the default namespace, with the given codec. If nil, codec.JSON is used.
+func NewRecorder(c codec.Codec) *Recorder {
+	if c == nil {
+		c = codec.JSON
+	}
+
+	return &Recorder{
+		namespace: defaultNamespace,
+		rec: &recording{
+			codec:    c,
+			handlers: map[location]*Handler{},
+			acks:     map[location][]interface{}{},
+		},
+	}
+}
+
+// Socket of a namespace, sharing the recording.
+func (r *Recorder) Socket(namespace string) (Socket, error) {
+	return r.Of(namespace), nil
+}
+
+// Of returns the Recorder of a namespace, sharing the recording.
+func (r *Recorder) Of(namespace string) *Recorder {
+	if namespace == "/" {
+		namespace = defaultNamespace
+	}
+
+	return &Recorder{
+		namespace: namespace,
+		rec:       r.rec,
+	}
+}
+
+// Emit records the event.
+func (r *Recorder) Emit(method string, args ...interface{}) error {
+	return r.EmitContext(context.Background(), method, args...)
+}
+
+// EmitContext records the event, unless the context is done.
+func (r *Recorder) EmitContext(ctx context.Context, method string, args ...interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.record(method, args, false)
+	return nil
+}
+
+// Ack records the event and answers it with the values set with AckWith.
+func (r *Recorder) Ack(ctx context.Context, method string, args interface{}, ret interface{}) error {
+	return r.AckWithOptions(ctx, method, args, ret, nil)
+}
+
+// AckWithOptions is like Ack. It returns ErrAckTimeout right away if there is no answer for the event.
+func (r *Recorder) AckWithOptions(ctx context.Context, method string, args interface{}, ret interface{}, opts *AckOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.record(method, []interface{}{args}, true)
+
+	r.rec.locker.Lock()
+	answer, ok := r.rec.acks[location{r.namespace, method}]
+	r.rec.locker.Unlock()
+
+	if !ok {
+		return ErrAckTimeout
+	}
+
+	if ret == nil || len(answer) == 0 {
+		return nil
+	}
+
+	// round trip through the codec, so the answer is converted as if it came from the server
+	data, err := r.rec.codec.Marshal(answer[0])
+
+	if err != nil {
+		return err
+	}
+
+	return r.rec.codec.Unmarshal(data, ret)
+}
+
+// AckWith sets the values the server answers to acks of the event with.
+func (r *Recorder) AckWith(method string, values ...interface{}) {
+	r.rec.locker.Lock()
+	r.rec.acks[location{r.namespace, method}] = values
+	r.rec.locker.Unlock()
+}
+
+func (r *Recorder) record(method string, args []interface{}, ack bool) {
+	r.rec.locker.Lock()
+	r.rec.emitted = append(r.rec.emitted, Emitted{
+		Namespace: r.namespace,
+		Method:    method,
+		Args:      args,
+		Ack:       ack,
+	})
+	r.rec.locker.Unlock()
+}
+
+// Emitted events on the namespace, in order.
+func (r *Recorder) Emitted() []Emitted {
+	r.rec.locker.Lock()
+	defer r.rec.locker.Unlock()
+
+	var list []Emitted
+
+	for _, e := range r.rec.emitted {
+		if e.Namespace == r.namespace {
+			list = append(list, e)
+		}
+	}
+
+	return list
+}
+
+// On registers a handler, checking it with NewHandler.
+func (r *Recorder) On(method string, f interface{}) error {
+	h, err := NewHandler(f)
+
+	if err != nil {
+		return err
+	}
+
+	r.rec.locker.Lock()
+	r.rec.handlers[location{r.namespace, method}] = h
+	r.rec.locker.Unlock()
+	return nil
+}
+
+// Off unregisters a handler.
+func (r *Recorder) Off(method string) {
+	r.rec.locker.Lock()
+	delete(r.rec.handlers, location{r.namespace, method})
+	r.rec.locker.Unlock()
+}
+
+// Listeners on the namespace, sorted.
+func (r *Recorder) Listeners() (list []string) {
+	r.rec.locker.Lock()
+
+	for l := range r.rec.handlers {
+		if l.namespace == r.namespace {
+			list = append(list, l.method)
+		}
+	}
+
+	r.rec.locker.Unlock()
+
+	sort.Strings(list)
+	return list
+}
+
+// Trigger the handler of the event as if the server sent it with the given arguments.
+// The arguments are encoded with the codec and converted to the parameters of the handler like the client does.
+// It returns the values returned by the handler, which the client would send back as the ack.
+func (r *Recorder) Trigger(method string, args ...interface{}) ([]interface{}, error) {
+	if args == nil {
+		args = []interface{}{}
+	}
+
+	data, err := r.rec.codec.Marshal(args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r.TriggerData(method, data)
+}
+
+// TriggerData triggers the handler of the event with its arguments already encoded with the codec, as an array.
+func (r *Recorder) TriggerData(method string, data []byte) ([]interface{}, error) {
+	r.rec.locker.Lock()
+	h, ok := r.rec.handlers[location{r.namespace, method}]
+	r.rec.locker.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf(`socket.io no handler for "%s"`, method)
+	}
+
+	msg := &protocol.Message{
+		Type:      protocol.MessageTypeEmit,
+		Namespace: r.namespace,
+		Method:    method,
+		Data:      data,
+	}
+
+	args, err := h.getFunctionCallArgs(msg, r.rec.codec)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return values(h.Call(args...)), nil
+}
+
+var (
+	_ Socket     = (*Recorder)(nil)
+	_ Namespaces = (*Recorder)(nil)
+)
@@ -0,0 +1,118 @@
+package gosocketio
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/wedeploy/gosocketio/codec"
+)
+
+type booking struct {
+	City   string `json:"city"`
+	Nights int    `json:"nights"`
+}
+
+// book is application code depending on the interfaces only.
+func book(ns Namespaces, city string) (string, error) {
+	s, err := ns.Socket("/hotels")
+
+	if err != nil {
+		return "", err
+	}
+
+	var hotel string
+
+	if err := s.Ack(context.Background(), "book", booking{city, 2}, &hotel); err != nil {
+		return "", err
+	}
+
+	return hotel, s.Emit("booked", hotel)
+}
+
+func TestRecorderEmit(t *testing.T) {
+	r := NewRecorder(nil)
+	r.Of("/hotels").AckWith("book", "Hilton")
+
+	hotel, err := book(r, "Lisbon")
+
+	if err != nil || hotel != "Hilton" {
+		t.Fatalf("Expected hotel to be Hilton, got %v (%v) instead", hotel, err)
+	}
+
+	want := []Emitted{
+		{Namespace: "/hotels", Method: "book", Args: []interface{}{booking{"Lisbon", 2}}, Ack: true},
+		{Namespace: "/hotels", Method: "booked", Args: []interface{}{"Hilton"}},
+	}
+
+	if got := r.Of("/hotels").Emitted(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected emitted %+v, got %+v instead", want, got)
+	}
+
+	if got := r.Emitted(); len(got) != 0 {
+		t.Errorf("Expected nothing emitted on the default namespace, got %+v instead", got)
+	}
+
+	if err := r.Ack(context.Background(), "unknown", nil, nil); err != ErrAckTimeout {
+		t.Errorf("Expected error to be %v, got %v instead", ErrAckTimeout, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := r.EmitContext(ctx, "late"); err != context.Canceled {
+		t.Errorf("Expected error to be %v, got %v instead", context.Canceled, err)
+	}
+}
+
+func TestRecorderTrigger(t *testing.T) {
+	r := NewRecorder(codec.JSON)
+	var got []booking
+
+	if err := r.On("bookings", func(b booking, guest booking) int {
+		got = append(got, b, guest)
+		return b.Nights + guest.Nights
+	}); err != nil {
+		t.Fatalf("Expected no error adding listener, got %v instead", err)
+	}
+
+	if err := r.On("invalid", 1); err == nil {
+		t.Errorf("Expected error adding a listener that isn't a function")
+	}
+
+	ret, err := r.Trigger("bookings", booking{"Lisbon", 2}, map[string]interface{}{"city": "Porto", "nights": 1})
+
+	if err != nil {
+		t.Fatalf("Expected no error triggering event, got %v instead", err)
+	}
+
+	if want := []booking{{"Lisbon", 2}, {"Porto", 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected bookings %v, got %v instead", want, got)
+	}
+
+	if !reflect.DeepEqual(ret, []interface{}{3}) {
+		t.Errorf("Expected handler to return 3, got %v instead", ret)
+	}
+
+	if _, err := r.TriggerData("bookings", []byte(`[{"city":1},{}]`)); err == nil {
+		t.Errorf("Expected error converting invalid arguments")
+	}
+
+	if _, err := r.Trigger("bookings"); err == nil {
+		t.Errorf("Expected error triggering without the required arguments")
+	}
+
+	if err := r.Of("/").On("ping", func() {}); err != nil {
+		t.Errorf("Expected no error adding listener, got %v instead", err)
+	}
+
+	if list := r.Listeners(); !reflect.DeepEqual(list, []string{"bookings", "ping"}) {
+		t.Errorf("Expected listeners bookings and ping, got %v instead", list)
+	}
+
+	r.Off("bookings")
+
+	if _, err := r.Trigger("bookings", booking{}); err == nil || err.Error() != `socket.io no handler for "bookings"` {
+		t.Errorf("Expected error for missing handler, got %v instead", err)
+	}
+}
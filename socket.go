@@ -0,0 +1,41 @@
+package gosocketio
+
+import "context"
+
+// Emitter emits events. *Client and *Namespace implement it.
+type Emitter interface {
+	Emit(method string, args ...interface{}) error
+	EmitContext(ctx context.Context, method string, args ...interface{}) error
+}
+
+// Acker emits events and waits for the server to acknowledge them. *Client and *Namespace implement it.
+type Acker interface {
+	Ack(ctx context.Context, method string, args interface{}, ret interface{}) error
+	AckWithOptions(ctx context.Context, method string, args interface{}, ret interface{}, opts *AckOptions) error
+}
+
+// Listener registers the handlers of events. *Client and *Namespace implement it.
+type Listener interface {
+	On(method string, f interface{}) error
+	Off(method string)
+	Listeners() []string
+}
+
+// Socket emits, acks, and listens on a namespace. *Client, for the default namespace, and *Namespace implement it,
+// so code depending on a Socket can be tested with a Recorder.
+type Socket interface {
+	Emitter
+	Acker
+	Listener
+}
+
+// Namespaces gives access to the namespaces of a connection. *Client implements it.
+type Namespaces interface {
+	Socket(namespace string) (Socket, error)
+}
+
+var (
+	_ Socket     = (*Client)(nil)
+	_ Socket     = (*Namespace)(nil)
+	_ Namespaces = (*Client)(nil)
+)
@@ -0,0 +1,576 @@
+package socketiotest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wedeploy/gosocketio/clock"
+	"github.com/wedeploy/gosocketio/protocol"
+	"github.com/wedeploy/gosocketio/transport"
+)
+
+// Disconnections of scenario steps.
+const (
+	// DisconnectServer sends the close packet and closes the connection, like a server shutting down.
+	DisconnectServer = "server"
+
+	// DisconnectDrop closes the connection without telling the client, like a network failure.
+	DisconnectDrop = "drop"
+)
+
+// Scenario of a conversation between a client and the server, played from the server side.
+//
+// A scenario is usually written in JSON:
+//
+//	{
+//		"name": "book a hotel",
+//		"engineIO": 4,
+//		"steps": [
+//			{"expect": {"type": "EVENT", "data": ["book_hotel", "Lisbon"]}},
+//			{"send": {"type": "ACK", "data": ["Hilton"]}},
+//			{"delay": "50ms"},
+//			{"send": {"type": "EVENT", "namespace": "/news", "data": ["headline", "Go 2 released"]}},
+//			{"disconnect": "drop"}
+//		]
+//	}
+//
+// The Engine.IO handshake, pings and the connection to the default namespace are handled by the player.
+// Connections to other namespaces are steps: expect the CONNECT packet, and send CONNECT or CONNECT_ERROR back.
+// After a disconnection, the next step waits for the client to connect again.
+type Scenario struct {
+	Name string `json:"name"`
+
+	// EngineIO protocol revision of the client: 3 or 4. If zero, 4 is used.
+	EngineIO int `json:"engineIO"`
+
+	Steps []Step `json:"steps"`
+
+	// Clock for the delays. If nil, clock.Real is used.
+	Clock clock.Clock `json:"-"`
+}
+
+// Step of a scenario. Only one of its fields is set.
+type Step struct {
+	// Expect the client to send the packet.
+	Expect *Packet `json:"expect,omitempty"`
+
+	// Send the packet to the client.
+	Send *Packet `json:"send,omitempty"`
+
+	// Delay before the next step, such as "100ms".
+	Delay string `json:"delay,omitempty"`
+
+	// Disconnect the client with DisconnectServer or DisconnectDrop.
+	Disconnect string `json:"disconnect,omitempty"`
+
+	delay time.Duration
+}
+
+// Packet of socket.io in a scenario.
+type Packet struct {
+	// Type of the packet, such as "EVENT" or "ACK".
+	Type string `json:"type"`
+
+	// Namespace of the packet. If empty, it is the default namespace.
+	Namespace string `json:"namespace,omitempty"`
+
+	// AckID of the packet. If nil, any ack id is expected, and acks sent answer the last packet expected with one.
+	AckID *int `json:"ackId,omitempty"`
+
+	// Data of the packet, such as the event name and arguments. If empty, any data is expected.
+	// Connections sent with EngineIO 4 get a session id if it is empty.
+	Data json.RawMessage `json:"data,omitempty"`
+
+	packetType protocol.PacketType
+}
+
+// ParseScenario from JSON.
+func ParseScenario(data []byte) (*Scenario, error) {
+	var sc Scenario
+
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return nil, fmt.Errorf("socketiotest invalid scenario: %v", err)
+	}
+
+	if sc.EngineIO == 0 {
+		sc.EngineIO = 4
+	}
+
+	for i := range sc.Steps {
+		if err := sc.Steps[i].parse(); err != nil {
+			return nil, fmt.Errorf("socketiotest invalid scenario step %d: %v", i+1, err)
+		}
+	}
+
+	return &sc, nil
+}
+
+// LoadScenario from a JSON file.
+func LoadScenario(name string) (*Scenario, error) {
+	data, err := os.ReadFile(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseScenario(data)
+}
+
+func (s *Step) parse() (err error) {
+	var set int
+
+	for _, ok := range []bool{s.Expect != nil, s.Send != nil, s.Delay != "", s.Disconnect != ""} {
+		if ok {
+			set++
+		}
+	}
+
+	if set != 1 {
+		return fmt.Errorf("expected one of expect, send, delay or disconnect, got %d", set)
+	}
+
+	switch {
+	case s.Expect != nil:
+		return s.Expect.parse()
+	case s.Send != nil:
+		return s.Send.parse()
+	case s.Delay != "":
+		s.delay, err = time.ParseDuration(s.Delay)
+		return err
+	case s.Disconnect != DisconnectServer && s.Disconnect != DisconnectDrop:
+		return fmt.Errorf("unknown disconnection %q", s.Disconnect)
+	}
+
+	return nil
+}
+
+func (p *Packet) parse() error {
+	for t := protocol.Connect; t <= protocol.BinaryAck; t++ {
+		if t.String() == p.Type {
+			p.packetType = t
+			break
+		}
+
+		if t == protocol.BinaryAck {
+			return fmt.Errorf("unknown packet type %q", p.Type)
+		}
+	}
+
+	if len(p.Data) != 0 && !json.Valid(p.Data) {
+		return fmt.Errorf("invalid data %s", p.Data)
+	}
+
+	if p.Namespace == "" {
+		p.Namespace = protocol.DefaultNamespace
+	}
+
+	return nil
+}
+
+func (p *Packet) packet() *protocol.Packet {
+	pp := &protocol.Packet{
+		EngineType: protocol.EngineMessage,
+		Type:       p.packetType,
+		Namespace:  p.Namespace,
+		Data:       p.Data,
+	}
+
+	if p.AckID != nil {
+		pp.AckID, pp.HasAckID = *p.AckID, true
+	}
+
+	return pp
+}
+
+// Mismatch between a packet expected by a scenario and the packet sent by the client.
+type Mismatch struct {
+	// Step of the scenario, starting at 1.
+	Step int
+
+	Expected *protocol.Packet
+
+	// Got is nil if the client sent no packet.
+	Got *protocol.Packet
+
+	// Diffs between the packets, such as `data[1]: expected "Lisbon", got "Porto"`.
+	Diffs []string
+
+	// Err is the reason no packet was received, if any.
+	Err error
+}
+
+func (m *Mismatch) Error() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "socketiotest scenario step %d: packet mismatch\n", m.Step)
+	fmt.Fprintf(&b, "\texpected: %s\n", marshal(m.Expected))
+
+	if m.Got == nil {
+		fmt.Fprintf(&b, "\tgot nothing: %v", m.Err)
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "\t     got: %s", marshal(m.Got))
+
+	for _, d := range m.Diffs {
+		fmt.Fprintf(&b, "\n\t%s", d)
+	}
+
+	return b.String()
+}
+
+func marshal(p *protocol.Packet) string {
+	text, err := p.MarshalText()
+
+	if err != nil {
+		return fmt.Sprintf("%+v", *p)
+	}
+
+	return string(text)
+}
+
+// diff the packet sent by the client from the expected one.
+func diff(expected *Packet, got *protocol.Packet) []string {
+	var diffs []string
+
+	if got.EngineType != protocol.EngineMessage {
+		return []string{fmt.Sprintf("engine type: expected %v, got %v", protocol.EngineMessage, got.EngineType)}
+	}
+
+	if got.Type != expected.packetType {
+		diffs = append(diffs, fmt.Sprintf("type: expected %v, got %v", expected.packetType, got.Type))
+	}
+
+	if namespace := got.Namespace; namespace != expected.Namespace {
+		diffs = append(diffs, fmt.Sprintf("namespace: expected %v, got %v", expected.Namespace, namespace))
+	}
+
+	switch {
+	case expected.AckID == nil:
+	case !got.HasAckID:
+		diffs = append(diffs, fmt.Sprintf("ack id: expected %d, got none", *expected.AckID))
+	case got.AckID != *expected.AckID:
+		diffs = append(diffs, fmt.Sprintf("ack id: expected %d, got %d", *expected.AckID, got.AckID))
+	}
+
+	if len(expected.Data) == 0 {
+		return diffs
+	}
+
+	var want, have interface{}
+	_ = json.Unmarshal(expected.Data, &want)
+
+	if err := json.Unmarshal(got.Data, &have); err != nil {
+		return append(diffs, fmt.Sprintf("data: expected %s, got %s", expected.Data, got.Data))
+	}
+
+	return diffJSON(diffs, "data", want, have)
+}
+
+// diffJSON appends the differences between two decoded JSON values, down to the array elements and object fields.
+func diffJSON(diffs []string, path string, want, have interface{}) []string {
+	switch w := want.(type) {
+	case []interface{}:
+		h, ok := have.([]interface{})
+
+		if !ok {
+			break
+		}
+
+		for i := 0; i < len(w) || i < len(h); i++ {
+			p := fmt.Sprintf("%s[%d]", path, i)
+
+			switch {
+			case i >= len(h):
+				diffs = append(diffs, fmt.Sprintf("%s: expected %s, got nothing", p, encode(w[i])))
+			case i >= len(w):
+				diffs = append(diffs, fmt.Sprintf("%s: expected nothing, got %s", p, encode(h[i])))
+			default:
+				diffs = diffJSON(diffs, p, w[i], h[i])
+			}
+		}
+
+		return diffs
+	case map[string]interface{}:
+		h, ok := have.(map[string]interface{})
+
+		if !ok {
+			break
+		}
+
+		var keys []string
+
+		for k := range w {
+			keys = append(keys, k)
+		}
+
+		for k := range h {
+			if _, ok := w[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			p := path + "." + k
+			wv, wok := w[k]
+			hv, hok := h[k]
+
+			switch {
+			case !hok:
+				diffs = append(diffs, fmt.Sprintf("%s: expected %s, got nothing", p, encode(wv)))
+			case !wok:
+				diffs = append(diffs, fmt.Sprintf("%s: expected nothing, got %s", p, encode(hv)))
+			default:
+				diffs = diffJSON(diffs, p, wv, hv)
+			}
+		}
+
+		return diffs
+	}
+
+	if !reflect.DeepEqual(want, have) {
+		diffs = append(diffs, fmt.Sprintf("%s: expected %s, got %s", path, encode(want), encode(have)))
+	}
+
+	return diffs
+}
+
+func encode(v interface{}) []byte {
+	data, _ := json.Marshal(v)
+	return data
+}
+
+// Play the scenario as the server of the connections dialed by the client through the pipe.
+// It returns a *Mismatch when the client doesn't send the expected packet.
+// The last connection is left open, so the client can be closed when it is done.
+func (sc *Scenario) Play(ctx context.Context, p *transport.Pipe) error {
+	pl := &player{
+		sc:   sc,
+		pipe: p,
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	// reading doesn't take a context, so the connection is closed to stop it
+	go func() {
+		defer close(stopped)
+
+		select {
+		case <-ctx.Done():
+			pl.close()
+		case <-stop:
+		}
+	}()
+
+	for i, s := range sc.Steps {
+		if err := pl.step(ctx, i+1, s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// player of a scenario.
+type player struct {
+	sc   *Scenario
+	pipe *transport.Pipe
+
+	conn     *transport.PipeConn
+	dec      *protocol.Decoder
+	enc      *protocol.Encoder
+	pending  []*protocol.Packet
+	sessions int
+
+	// ack id of the last packet expected with one
+	ackID int
+
+	connLocker sync.Mutex
+}
+
+func (pl *player) step(ctx context.Context, n int, s Step) error {
+	if s.Delay != "" {
+		timer := clock.Or(pl.sc.Clock).NewTimer(s.delay)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C():
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if err := pl.accept(ctx); err != nil {
+		return fmt.Errorf("socketiotest scenario step %d: %v", n, err)
+	}
+
+	switch {
+	case s.Expect != nil:
+		return pl.expect(ctx, n, s.Expect)
+	case s.Send != nil:
+		if err := pl.send(s.Send); err != nil {
+			return fmt.Errorf("socketiotest scenario step %d: %v", n, err)
+		}
+
+		return nil
+	}
+
+	if s.Disconnect == DisconnectServer {
+		_ = pl.enc.Encode(&protocol.Packet{EngineType: protocol.EngineClose})
+	}
+
+	pl.close()
+	pl.connLocker.Lock()
+	pl.conn = nil
+	pl.connLocker.Unlock()
+	return nil
+}
+
+// accept a connection from the client, if there is none, and handle its handshake.
+func (pl *player) accept(ctx context.Context) error {
+	if pl.conn != nil {
+		return nil
+	}
+
+	conn, err := pl.pipe.Accept(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	pl.connLocker.Lock()
+	pl.conn = conn
+	pl.connLocker.Unlock()
+
+	pl.sessions++
+	pl.dec = protocol.NewDecoder(conn, pl.sc.EngineIO)
+	pl.enc = protocol.NewEncoder(conn, pl.sc.EngineIO)
+	pl.pending = nil
+
+	handshake, _ := json.Marshal(map[string]interface{}{
+		"sid":          pl.sid(),
+		"upgrades":     []string{},
+		"pingInterval": int(PingInterval / time.Millisecond),
+		"pingTimeout":  int(PingTimeout / time.Millisecond),
+	})
+
+	if err := pl.enc.Encode(&protocol.Packet{EngineType: protocol.EngineOpen, Data: handshake}); err != nil {
+		return err
+	}
+
+	connect := &protocol.Packet{EngineType: protocol.EngineMessage, Type: protocol.Connect}
+
+	if pl.sc.EngineIO < 4 {
+		return pl.enc.Encode(connect)
+	}
+
+	// the client joins the default namespace first, but may join others before the server answers
+	for {
+		p, err := pl.read()
+
+		if err != nil {
+			return err
+		}
+
+		if p.Type == protocol.Connect && p.Namespace == protocol.DefaultNamespace {
+			connect.Data, _ = json.Marshal(map[string]string{"sid": pl.sid()})
+			return pl.enc.Encode(connect)
+		}
+
+		pl.pending = append(pl.pending, p)
+	}
+}
+
+func (pl *player) sid() string {
+	return fmt.Sprintf("scenario%d", pl.sessions)
+}
+
+// read the next socket.io packet, answering pings.
+func (pl *player) read() (*protocol.Packet, error) {
+	for {
+		p, err := pl.dec.Decode()
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch p.EngineType {
+		case protocol.EngineMessage:
+			return p, nil
+		case protocol.EnginePing:
+			if err := pl.enc.Encode(&protocol.Packet{EngineType: protocol.EnginePong, Data: p.Data}); err != nil {
+				return nil, err
+			}
+		case protocol.EngineClose:
+			return nil, ErrSessionClosed
+		}
+	}
+}
+
+func (pl *player) expect(ctx context.Context, n int, expected *Packet) error {
+	var got *protocol.Packet
+	var err error
+
+	if len(pl.pending) != 0 {
+		got, pl.pending = pl.pending[0], pl.pending[1:]
+	} else {
+		got, err = pl.read()
+	}
+
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+
+		return &Mismatch{Step: n, Expected: expected.packet(), Err: err}
+	}
+
+	if diffs := diff(expected, got); len(diffs) != 0 {
+		return &Mismatch{Step: n, Expected: expected.packet(), Got: got, Diffs: diffs}
+	}
+
+	if got.HasAckID {
+		pl.ackID = got.AckID
+	}
+
+	return nil
+}
+
+func (pl *player) send(s *Packet) error {
+	p := s.packet()
+
+	if p.Type == protocol.Ack && !p.HasAckID {
+		p.AckID, p.HasAckID = pl.ackID, true
+	}
+
+	if p.Type == protocol.Connect && len(p.Data) == 0 && pl.sc.EngineIO >= 4 {
+		p.Data, _ = json.Marshal(map[string]string{"sid": pl.sid()})
+	}
+
+	return pl.enc.Encode(p)
+}
+
+func (pl *player) close() {
+	pl.connLocker.Lock()
+
+	if pl.conn != nil {
+		pl.conn.Close()
+	}
+
+	pl.connLocker.Unlock()
+}
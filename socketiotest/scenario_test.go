@@ -0,0 +1,281 @@
+package socketiotest
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	gosocketio "github.com/wedeploy/gosocketio"
+	"github.com/wedeploy/gosocketio/clock"
+	"github.com/wedeploy/gosocketio/transport"
+)
+
+func play(t *testing.T, scenario string, opts *gosocketio.Options) (*gosocketio.Client, chan error) {
+	sc, err := ParseScenario([]byte(scenario))
+
+	if err != nil {
+		t.Fatalf("Expected no error parsing scenario, got %v instead", err)
+	}
+
+	p := transport.NewPipe()
+	opts.Transport = p
+	opts.EngineIO = sc.EngineIO
+
+	done := make(chan error, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+
+	go func() {
+		defer cancel()
+		done <- sc.Play(ctx, p)
+	}()
+
+	c := gosocketio.New(url.URL{Scheme: "ws", Host: "scenario"}, opts)
+
+	if err := c.Open(ctx); err != nil {
+		t.Fatalf("Expected no error opening client, got %v instead", err)
+	}
+
+	return c, done
+}
+
+func TestScenario(t *testing.T) {
+	for _, engineIO := range []string{"3", "4"} {
+		received := make(chan string, 1)
+
+		c, done := play(t, `{
+			"engineIO": `+engineIO+`,
+			"steps": [
+				{"expect": {"type": "EVENT", "data": ["book_hotel", "Lisbon"]}},
+				{"send": {"type": "ACK", "data": ["Hilton"]}},
+				{"delay": "10ms"},
+				{"send": {"type": "EVENT", "data": ["news", "Go 2 released"]}},
+				{"expect": {"type": "EVENT", "ackId": 2, "data": ["rate", {"stars": 5}]}}
+			]
+		}`, &gosocketio.Options{})
+
+		if err := c.On("news", func(headline string) {
+			received <- headline
+		}); err != nil {
+			t.Fatalf("Expected no error adding listener, got %v instead", err)
+		}
+
+		var hotel string
+
+		if err := c.Ack(context.Background(), "book_hotel", "Lisbon", &hotel); err != nil || hotel != "Hilton" {
+			t.Errorf("Expected hotel to be Hilton, got %v (%v) instead", hotel, err)
+		}
+
+		if headline := <-received; headline != "Go 2 released" {
+			t.Errorf("Expected headline to be Go 2 released, got %v instead", headline)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_ = c.Ack(ctx, "rate", map[string]int{"stars": 5}, nil)
+		cancel()
+
+		if err := <-done; err != nil {
+			t.Errorf("Expected scenario to play, got %v instead", err)
+		}
+
+		c.Close()
+	}
+}
+
+func TestScenarioClock(t *testing.T) {
+	sc, err := ParseScenario([]byte(`{
+		"steps": [
+			{"delay": "1m"},
+			{"send": {"type": "EVENT", "data": ["news", "Go 2 released"]}}
+		]
+	}`))
+
+	if err != nil {
+		t.Fatalf("Expected no error parsing scenario, got %v instead", err)
+	}
+
+	f := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	sc.Clock = f
+
+	p := transport.NewPipe()
+	done := make(chan error, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	go func() {
+		done <- sc.Play(ctx, p)
+	}()
+
+	// the delay starts before the connection is accepted, so the client is opened meanwhile
+	if err := f.WaitTimers(ctx, 1); err != nil {
+		t.Fatalf("Expected delay to start, got %v instead", err)
+	}
+
+	c := gosocketio.New(url.URL{Scheme: "ws", Host: "scenario"}, &gosocketio.Options{
+		Transport: p,
+		EngineIO:  sc.EngineIO,
+	})
+	defer c.Close()
+
+	received := make(chan string, 1)
+
+	if err := c.On("news", func(headline string) {
+		received <- headline
+	}); err != nil {
+		t.Fatalf("Expected no error adding listener, got %v instead", err)
+	}
+
+	opened := make(chan error, 1)
+
+	go func() {
+		opened <- c.Open(ctx)
+	}()
+
+	select {
+	case err := <-opened:
+		t.Fatalf("Expected the scenario to wait for the clock, got %v instead", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	f.Advance(time.Minute)
+
+	if err := <-opened; err != nil {
+		t.Fatalf("Expected no error opening client, got %v instead", err)
+	}
+
+	if headline := <-received; headline != "Go 2 released" {
+		t.Errorf("Expected headline to be Go 2 released, got %v instead", headline)
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("Expected scenario to play, got %v instead", err)
+	}
+}
+
+func TestScenarioMismatch(t *testing.T) {
+	c, done := play(t, `{
+		"steps": [
+			{"expect": {"type": "EVENT", "namespace": "/", "data": ["book_hotel", "Porto", {"nights": 2}]}}
+		]
+	}`, &gosocketio.Options{})
+
+	defer c.Close()
+
+	if err := c.Emit("book_hotel", "Lisbon", map[string]int{"nights": 3, "guests": 2}); err != nil {
+		t.Errorf("Expected no error emitting, got %v instead", err)
+	}
+
+	err := <-done
+	m, ok := err.(*Mismatch)
+
+	if !ok {
+		t.Fatalf("Expected error to be a mismatch, got %v instead", err)
+	}
+
+	want := []string{
+		`data[1]: expected "Porto", got "Lisbon"`,
+		`data[2].guests: expected nothing, got 2`,
+		`data[2].nights: expected 2, got 3`,
+	}
+
+	if m.Step != 1 || !reflect.DeepEqual(m.Diffs, want) {
+		t.Errorf("Expected diffs %q on step 1, got %q on step %d instead", want, m.Diffs, m.Step)
+	}
+
+	if !strings.Contains(err.Error(), `got: 42["book_hotel","Lisbon",{"guests":2,"nights":3}]`) {
+		t.Errorf("Expected error to show the packet, got %v instead", err)
+	}
+}
+
+func TestScenarioTimeout(t *testing.T) {
+	c, done := play(t, `{"steps": [{"expect": {"type": "EVENT"}}]}`, &gosocketio.Options{})
+	defer c.Close()
+
+	err := <-done
+	m, ok := err.(*Mismatch)
+
+	if !ok || m.Got != nil || m.Err != context.DeadlineExceeded {
+		t.Errorf("Expected mismatch with no packet, got %v instead", err)
+	}
+}
+
+func TestScenarioReconnect(t *testing.T) {
+	c, done := play(t, `{
+		"steps": [
+			{"expect": {"type": "CONNECT", "namespace": "/admin"}},
+			{"send": {"type": "CONNECT_ERROR", "namespace": "/admin", "data": {"message": "Not authorized"}}},
+			{"disconnect": "drop"},
+			{"expect": {"type": "EVENT", "data": ["hello"]}},
+			{"disconnect": "server"}
+		]
+	}`, &gosocketio.Options{
+		Reconnect:      true,
+		ReconnectDelay: time.Millisecond,
+	})
+
+	defer c.Close()
+
+	ch := make(chan gosocketio.Transition, 10)
+	c.NotifyState(ch)
+
+	if _, err := c.OfContext(context.Background(), "/admin", nil); err == nil || err.Error() != `connection to namespace "/admin" refused: Not authorized` {
+		t.Errorf("Expected connection to /admin to be refused, got %v instead", err)
+	}
+
+	waitState(t, ch, gosocketio.StateReconnecting)
+	waitState(t, ch, gosocketio.StateConnected)
+
+	if err := c.Emit("hello"); err != nil {
+		t.Errorf("Expected no error emitting, got %v instead", err)
+	}
+
+	if tr := waitState(t, ch, gosocketio.StateClosed); tr.Cause != gosocketio.ErrServerDisconnect {
+		t.Errorf("Expected cause to be %v, got %v instead", gosocketio.ErrServerDisconnect, tr.Cause)
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("Expected scenario to play, got %v instead", err)
+	}
+}
+
+func TestParseScenario(t *testing.T) {
+	var cases = []struct {
+		scenario string
+		err      string
+	}{
+		{`{"steps": [{}]}`, "socketiotest invalid scenario step 1: expected one of expect, send, delay or disconnect, got 0"},
+		{`{"steps": [{"delay": "1s", "disconnect": "drop"}]}`, "socketiotest invalid scenario step 1: expected one of expect, send, delay or disconnect, got 2"},
+		{`{"steps": [{"send": {"type": "PING"}}]}`, `socketiotest invalid scenario step 1: unknown packet type "PING"`},
+		{`{"steps": [{"delay": "soon"}]}`, `socketiotest invalid scenario step 1: time: invalid duration "soon"`},
+		{`{"steps": [{"disconnect": "later"}]}`, `socketiotest invalid scenario step 1: unknown disconnection "later"`},
+		{`{"steps": 1}`, "socketiotest invalid scenario: json: cannot unmarshal number into Go struct field Scenario.steps of type []socketiotest.Step"},
+	}
+
+	for _, c := range cases {
+		if _, err := ParseScenario([]byte(c.scenario)); err == nil || err.Error() != c.err {
+			t.Errorf("Expected error to be %v, got %v instead", c.err, err)
+		}
+	}
+}
+
+func TestLoadScenario(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "scenario.json")
+
+	if err := os.WriteFile(name, []byte(`{"name": "hello", "steps": [{"expect": {"type": "EVENT", "data": ["hello"]}}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := LoadScenario(name)
+
+	if err != nil {
+		t.Fatalf("Expected no error loading scenario, got %v instead", err)
+	}
+
+	if sc.Name != "hello" || sc.EngineIO != 4 || len(sc.Steps) != 1 || sc.Steps[0].Expect.Namespace != "/" {
+		t.Errorf("Expected scenario hello with one step, got %+v instead", sc)
+	}
+}
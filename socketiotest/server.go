@@ -0,0 +1,718 @@
+// Package socketiotest provides an in-process socket.io server for testing socket.io clients.
+//
+// The server speaks Engine.IO v3 and v4, over WebSocket, with the text packet format.
+// Tests script how it answers events, push events to the client, wait for the events the client emits,
+// and simulate namespace refusals, server disconnects and lost connections.
+//
+//	s := socketiotest.NewServer(nil)
+//	defer s.Close()
+//
+//	s.OnEvent("book_hotel", func(args socketiotest.Args) []interface{} {
+//		return []interface{}{"Hilton"}
+//	})
+//
+//	c, err := gosocketio.Connect(s.URL(), websocket.NewTransport())
+//
+// Conversations can also be described as a Scenario, and played over an in-memory pipe.
+package socketiotest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/wedeploy/gosocketio/clock"
+	"github.com/wedeploy/gosocketio/protocol"
+	"github.com/wedeploy/gosocketio/transport"
+)
+
+const (
+	// PingInterval of the server.
+	PingInterval = 25 * time.Second
+
+	// PingTimeout of the server.
+	PingTimeout = 20 * time.Second
+)
+
+var (
+	// ErrNoSession is returned when emitting to a namespace no client joined.
+	ErrNoSession = errors.New("socketiotest no session joined the namespace")
+
+	// ErrSessionClosed is returned when the session is closed while waiting for an ack.
+	ErrSessionClosed = errors.New("socketiotest session closed")
+)
+
+// Options for the server.
+type Options struct {
+	// PingInterval and PingTimeout sent with the handshake. If zero, PingInterval and PingTimeout are used.
+	// On Engine.IO v4, the server pings the client every PingInterval.
+	PingInterval time.Duration
+	PingTimeout  time.Duration
+
+	// Clock for the pings. If nil, clock.Real is used.
+	Clock clock.Clock
+}
+
+// Args of an event or ack, each one encoded as JSON.
+type Args []json.RawMessage
+
+// Unmarshal the argument at index i into v.
+func (a Args) Unmarshal(i int, v interface{}) error {
+	if i >= len(a) {
+		return fmt.Errorf("socketiotest argument %d not found, there are %d", i, len(a))
+	}
+
+	return json.Unmarshal(a[i], v)
+}
+
+// Handler of an event sent by the client. It returns the arguments of the ack, if the client waits for one.
+type Handler func(args Args) []interface{}
+
+// Event emitted by the client.
+type Event struct {
+	Namespace string
+	Name      string
+	Args      Args
+
+	// AckID of the event when the client waits for an ack.
+	AckID    int
+	HasAckID bool
+}
+
+// Server of socket.io for tests.
+type Server struct {
+	*httptest.Server
+
+	opts Options
+
+	namespaces map[string]*Namespace
+	sessions   map[*session]struct{}
+	accepted   int
+
+	events  []Event
+	cursors map[string]int
+	changed chan struct{}
+
+	locker sync.Mutex
+}
+
+// NewServer starts a server. Close it when done.
+func NewServer(opts *Options) *Server {
+	s := &Server{
+		namespaces: map[string]*Namespace{},
+		sessions:   map[*session]struct{}{},
+		cursors:    map[string]int{},
+		changed:    make(chan struct{}),
+	}
+
+	if opts != nil {
+		s.opts = *opts
+	}
+
+	if s.opts.PingInterval == 0 {
+		s.opts.PingInterval = PingInterval
+	}
+
+	if s.opts.PingTimeout == 0 {
+		s.opts.PingTimeout = PingTimeout
+	}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// URL of the server for the client, with the ws scheme.
+func (s *Server) URL() url.URL {
+	u, _ := url.Parse(s.Server.URL)
+	u.Scheme = "ws"
+	return *u
+}
+
+// Close the connections and shut down the server.
+func (s *Server) Close() {
+	s.Drop()
+	s.Server.Close()
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("transport") != "websocket" {
+		http.Error(w, "only the websocket transport is supported", http.StatusBadRequest)
+		return
+	}
+
+	var upgrader ws.Upgrader
+	conn, err := upgrader.Upgrade(w, r, nil)
+
+	if err != nil {
+		return
+	}
+
+	s.serve(wsConn{conn}, r.URL.Query())
+}
+
+// wsConn reads and writes the frames of a WebSocket connection.
+type wsConn struct {
+	*ws.Conn
+}
+
+func (c wsConn) ReadFrame() (transport.Frame, error) {
+	msgType, data, err := c.ReadMessage()
+	return transport.Frame{Data: data, Binary: msgType == ws.BinaryMessage}, err
+}
+
+func (c wsConn) WriteFrame(f transport.Frame) error {
+	var msgType = ws.TextMessage
+
+	if f.Binary {
+		msgType = ws.BinaryMessage
+	}
+
+	return c.WriteMessage(msgType, f.Data)
+}
+
+func (c wsConn) Close() {
+	c.Conn.Close()
+}
+
+// frameConn carries the frames of a session.
+type frameConn interface {
+	ReadFrame() (transport.Frame, error)
+	WriteFrame(transport.Frame) error
+	Close()
+}
+
+// serve a session on the connection until it is closed.
+func (s *Server) serve(conn frameConn, query url.Values) {
+	s.locker.Lock()
+	s.accepted++
+
+	ss := &session{
+		s:          s,
+		conn:       conn,
+		sid:        fmt.Sprintf("sid%d", s.accepted),
+		engineIO:   4,
+		namespaces: map[string]bool{},
+		acks:       map[int]chan Args{},
+		done:       make(chan struct{}),
+	}
+
+	s.sessions[ss] = struct{}{}
+	s.locker.Unlock()
+
+	if query.Get("EIO") == "3" {
+		ss.engineIO = 3
+	}
+
+	ss.run()
+
+	s.locker.Lock()
+	delete(s.sessions, ss)
+	s.locker.Unlock()
+}
+
+// Connections accepted by the server since it started, including the ones already closed.
+func (s *Server) Connections() int {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.accepted
+}
+
+// Of returns the namespace with the given name, such as "/chat". The default namespace is "/".
+func (s *Server) Of(name string) *Namespace {
+	if name == "" {
+		name = protocol.DefaultNamespace
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	n, ok := s.namespaces[name]
+
+	if !ok {
+		n = &Namespace{
+			s:        s,
+			name:     name,
+			handlers: map[string]Handler{},
+		}
+
+		s.namespaces[name] = n
+	}
+
+	return n
+}
+
+// OnEvent sets the handler of an event on the default namespace.
+func (s *Server) OnEvent(name string, h Handler) {
+	s.Of(protocol.DefaultNamespace).OnEvent(name, h)
+}
+
+// Emit an event to the clients on the default namespace.
+func (s *Server) Emit(name string, args ...interface{}) error {
+	return s.Of(protocol.DefaultNamespace).Emit(name, args...)
+}
+
+// Events emitted by the clients so far, in the order they were received.
+func (s *Server) Events() []Event {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+// WaitEvent waits for the next event with the given name, on any namespace.
+// Each call returns a different event, in the order they were received.
+func (s *Server) WaitEvent(ctx context.Context, name string) (Event, error) {
+	for {
+		s.locker.Lock()
+		changed := s.changed
+
+		for i := s.cursors[name]; i < len(s.events); i++ {
+			if e := s.events[i]; e.Name == name {
+				s.cursors[name] = i + 1
+				s.locker.Unlock()
+				return e, nil
+			}
+		}
+
+		s.cursors[name] = len(s.events)
+		s.locker.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return Event{}, ctx.Err()
+		}
+	}
+}
+
+func (s *Server) record(e Event) {
+	s.locker.Lock()
+	s.events = append(s.events, e)
+	s.notify()
+	s.locker.Unlock()
+}
+
+// notify the goroutines waiting for a change. It must be called with the lock held.
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Disconnect the clients, sending them the close packet.
+func (s *Server) Disconnect() {
+	for _, ss := range s.getSessions("") {
+		_ = ss.write(protocol.Packet{EngineType: protocol.EngineClose})
+		ss.close()
+	}
+}
+
+// Drop the connections without telling the clients, as if the network failed.
+func (s *Server) Drop() {
+	for _, ss := range s.getSessions("") {
+		ss.close()
+	}
+}
+
+// getSessions joined to the namespace, or all of them if it is empty.
+func (s *Server) getSessions(namespace string) []*session {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	var list []*session
+
+	for ss := range s.sessions {
+		if namespace == "" || ss.joined(namespace) {
+			list = append(list, ss)
+		}
+	}
+
+	return list
+}
+
+// Namespace of the server.
+type Namespace struct {
+	s    *Server
+	name string
+
+	handlers map[string]Handler
+	refusal  interface{}
+	refused  bool
+}
+
+// OnEvent sets the handler of an event.
+func (n *Namespace) OnEvent(name string, h Handler) {
+	n.s.locker.Lock()
+	n.handlers[name] = h
+	n.s.locker.Unlock()
+}
+
+// Refuse the connections to the namespace from now on, sending data with the connection error,
+// such as map[string]string{"message": "not authorized"}.
+func (n *Namespace) Refuse(data interface{}) {
+	n.s.locker.Lock()
+	n.refused, n.refusal = true, data
+	n.s.locker.Unlock()
+}
+
+// Accept the connections to the namespace again.
+func (n *Namespace) Accept() {
+	n.s.locker.Lock()
+	n.refused, n.refusal = false, nil
+	n.s.locker.Unlock()
+}
+
+// WaitJoin waits until a client joins the namespace.
+func (n *Namespace) WaitJoin(ctx context.Context) error {
+	for {
+		n.s.locker.Lock()
+		changed := n.s.changed
+		n.s.locker.Unlock()
+
+		if len(n.s.getSessions(n.name)) != 0 {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Emit an event to the clients joined to the namespace.
+func (n *Namespace) Emit(name string, args ...interface{}) error {
+	p, err := n.event(name, args)
+
+	if err != nil {
+		return err
+	}
+
+	sessions := n.s.getSessions(n.name)
+
+	if len(sessions) == 0 {
+		return ErrNoSession
+	}
+
+	for _, ss := range sessions {
+		if err := ss.write(p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EmitWithAck emits an event to the first client joined to the namespace, and waits for its ack.
+func (n *Namespace) EmitWithAck(ctx context.Context, name string, args ...interface{}) (Args, error) {
+	p, err := n.event(name, args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := n.s.getSessions(n.name)
+
+	if len(sessions) == 0 {
+		return nil, ErrNoSession
+	}
+
+	return sessions[0].ack(ctx, p)
+}
+
+// Disconnect the clients from the namespace, like the server does when it kicks them out.
+func (n *Namespace) Disconnect() {
+	for _, ss := range n.s.getSessions(n.name) {
+		ss.leave(n.name)
+		_ = ss.write(protocol.Packet{
+			EngineType: protocol.EngineMessage,
+			Type:       protocol.Disconnect,
+			Namespace:  n.name,
+		})
+	}
+}
+
+func (n *Namespace) event(name string, args []interface{}) (protocol.Packet, error) {
+	data, err := json.Marshal(append([]interface{}{name}, args...))
+
+	return protocol.Packet{
+		EngineType: protocol.EngineMessage,
+		Type:       protocol.Event,
+		Namespace:  n.name,
+		Data:       data,
+	}, err
+}
+
+func (n *Namespace) handler(event string) (Handler, bool) {
+	n.s.locker.Lock()
+	defer n.s.locker.Unlock()
+	h, ok := n.handlers[event]
+	return h, ok
+}
+
+func (n *Namespace) refuses() (interface{}, bool) {
+	n.s.locker.Lock()
+	defer n.s.locker.Unlock()
+	return n.refusal, n.refused
+}
+
+// session of a client connection.
+type session struct {
+	s        *Server
+	conn     frameConn
+	sid      string
+	engineIO int
+
+	namespaces map[string]bool
+
+	acks  map[int]chan Args
+	ackID int
+
+	done      chan struct{}
+	closeOnce sync.Once
+
+	writeLocker sync.Mutex
+}
+
+func (ss *session) run() {
+	handshake, _ := json.Marshal(map[string]interface{}{
+		"sid":          ss.sid,
+		"upgrades":     []string{},
+		"pingInterval": int(ss.s.opts.PingInterval / time.Millisecond),
+		"pingTimeout":  int(ss.s.opts.PingTimeout / time.Millisecond),
+	})
+
+	if err := ss.write(protocol.Packet{EngineType: protocol.EngineOpen, Data: handshake}); err != nil {
+		ss.close()
+		return
+	}
+
+	// socket.io v2 servers join the default namespace right away
+	if ss.engineIO < 4 {
+		ss.join(protocol.DefaultNamespace)
+		_ = ss.write(protocol.Packet{EngineType: protocol.EngineMessage, Type: protocol.Connect})
+	} else {
+		go ss.ping()
+	}
+
+	defer ss.close()
+
+	for {
+		f, err := ss.conn.ReadFrame()
+
+		if err != nil {
+			return
+		}
+
+		var p protocol.Packet
+
+		if f.Binary || p.UnmarshalText(f.Data) != nil {
+			continue
+		}
+
+		switch p.EngineType {
+		case protocol.EnginePing:
+			_ = ss.write(protocol.Packet{EngineType: protocol.EnginePong, Data: p.Data})
+		case protocol.EngineClose:
+			return
+		case protocol.EngineMessage:
+			ss.handle(p)
+		}
+	}
+}
+
+func (ss *session) ping() {
+	ticker := clock.Or(ss.s.opts.Clock).NewTicker(ss.s.opts.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ss.done:
+			return
+		case <-ticker.C():
+			_ = ss.write(protocol.Packet{EngineType: protocol.EnginePing})
+		}
+	}
+}
+
+func (ss *session) handle(p protocol.Packet) {
+	switch p.Type {
+	case protocol.Connect:
+		ss.connect(p.Namespace)
+	case protocol.Disconnect:
+		ss.leave(p.Namespace)
+	case protocol.Event:
+		ss.event(p)
+	case protocol.Ack:
+		var args Args
+
+		if json.Unmarshal(p.Data, &args) == nil {
+			ss.acknowledge(p.AckID, args)
+		}
+	}
+}
+
+func (ss *session) connect(namespace string) {
+	n := ss.s.Of(namespace)
+
+	if data, refused := n.refuses(); refused {
+		payload, _ := json.Marshal(data)
+
+		_ = ss.write(protocol.Packet{
+			EngineType: protocol.EngineMessage,
+			Type:       protocol.ConnectError,
+			Namespace:  namespace,
+			Data:       payload,
+		})
+
+		return
+	}
+
+	var payload []byte
+
+	if ss.engineIO >= 4 {
+		payload, _ = json.Marshal(map[string]string{"sid": ss.sid + namespace})
+	}
+
+	ss.join(namespace)
+
+	_ = ss.write(protocol.Packet{
+		EngineType: protocol.EngineMessage,
+		Type:       protocol.Connect,
+		Namespace:  namespace,
+		Data:       payload,
+	})
+}
+
+func (ss *session) event(p protocol.Packet) {
+	var values Args
+	var name string
+
+	if json.Unmarshal(p.Data, &values) != nil || len(values) == 0 || json.Unmarshal(values[0], &name) != nil {
+		return
+	}
+
+	e := Event{
+		Namespace: p.Namespace,
+		Name:      name,
+		Args:      values[1:],
+		AckID:     p.AckID,
+		HasAckID:  p.HasAckID,
+	}
+
+	ss.s.record(e)
+	h, ok := ss.s.Of(p.Namespace).handler(name)
+
+	if !ok {
+		return
+	}
+
+	ret := h(e.Args)
+
+	if !e.HasAckID {
+		return
+	}
+
+	if ret == nil {
+		ret = []interface{}{}
+	}
+
+	data, err := json.Marshal(ret)
+
+	if err != nil {
+		return
+	}
+
+	_ = ss.write(protocol.Packet{
+		EngineType: protocol.EngineMessage,
+		Type:       protocol.Ack,
+		Namespace:  p.Namespace,
+		AckID:      e.AckID,
+		HasAckID:   true,
+		Data:       data,
+	})
+}
+
+func (ss *session) ack(ctx context.Context, p protocol.Packet) (Args, error) {
+	ch := make(chan Args, 1)
+
+	ss.s.locker.Lock()
+	ss.ackID++
+	p.AckID, p.HasAckID = ss.ackID, true
+	ss.acks[p.AckID] = ch
+	ss.s.locker.Unlock()
+
+	defer func() {
+		ss.s.locker.Lock()
+		delete(ss.acks, p.AckID)
+		ss.s.locker.Unlock()
+	}()
+
+	if err := ss.write(p); err != nil {
+		return nil, err
+	}
+
+	select {
+	case args := <-ch:
+		return args, nil
+	case <-ss.done:
+		return nil, ErrSessionClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// acknowledge the ack waiting for id. The waiter is taken by the first ack, so repeated ones are ignored.
+func (ss *session) acknowledge(id int, args Args) {
+	ss.s.locker.Lock()
+	ch, ok := ss.acks[id]
+	delete(ss.acks, id)
+	ss.s.locker.Unlock()
+
+	if ok {
+		ch <- args
+	}
+}
+
+func (ss *session) join(namespace string) {
+	ss.s.locker.Lock()
+	ss.namespaces[namespace] = true
+	ss.s.notify()
+	ss.s.locker.Unlock()
+}
+
+func (ss *session) leave(namespace string) {
+	ss.s.locker.Lock()
+	delete(ss.namespaces, namespace)
+	ss.s.locker.Unlock()
+}
+
+func (ss *session) joined(namespace string) bool {
+	// called by getSessions, with the lock held
+	return ss.namespaces[namespace]
+}
+
+func (ss *session) write(p protocol.Packet) error {
+	text, err := p.MarshalText()
+
+	if err != nil {
+		return err
+	}
+
+	ss.writeLocker.Lock()
+	defer ss.writeLocker.Unlock()
+	return ss.conn.WriteFrame(transport.Frame{Data: text})
+}
+
+func (ss *session) close() {
+	ss.closeOnce.Do(func() {
+		close(ss.done)
+		ss.conn.Close()
+	})
+}
@@ -0,0 +1,334 @@
+package socketiotest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	gosocketio "github.com/wedeploy/gosocketio"
+	"github.com/wedeploy/gosocketio/clock"
+	"github.com/wedeploy/gosocketio/websocket"
+)
+
+func open(t *testing.T, s *Server, engineIO int) *gosocketio.Client {
+	c := gosocketio.New(s.URL(), &gosocketio.Options{
+		Transport: websocket.NewTransport(),
+		EngineIO:  engineIO,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := c.Open(ctx); err != nil {
+		t.Fatalf("Expected no error opening client, got %v instead", err)
+	}
+
+	return c
+}
+
+func waitState(t *testing.T, ch chan gosocketio.Transition, want gosocketio.State) gosocketio.Transition {
+	for {
+		select {
+		case tr := <-ch:
+			if tr.Namespace == nil && tr.To == want {
+				return tr
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected client to be %v", want)
+		}
+	}
+}
+
+func TestServerOnEvent(t *testing.T) {
+	for _, engineIO := range []int{3, 4} {
+		s := NewServer(nil)
+
+		s.OnEvent("book_hotel", func(args Args) []interface{} {
+			var city string
+
+			if err := args.Unmarshal(0, &city); err != nil {
+				t.Errorf("Expected no error decoding argument, got %v instead", err)
+			}
+
+			return []interface{}{"Hilton " + city}
+		})
+
+		c := open(t, s, engineIO)
+
+		var hotel string
+
+		if err := c.Ack(context.Background(), "book_hotel", "Lisbon", &hotel); err != nil {
+			t.Errorf("Expected no error on ack, got %v instead", err)
+		}
+
+		if hotel != "Hilton Lisbon" {
+			t.Errorf("Expected hotel to be Hilton Lisbon, got %v instead", hotel)
+		}
+
+		if err := c.Emit("rate", 5); err != nil {
+			t.Errorf("Expected no error emitting, got %v instead", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		e, err := s.WaitEvent(ctx, "rate")
+		cancel()
+
+		if err != nil {
+			t.Fatalf("Expected no error waiting for event, got %v instead", err)
+		}
+
+		if e.Namespace != "/" || len(e.Args) != 1 || string(e.Args[0]) != "5" || e.HasAckID {
+			t.Errorf("Expected rate event with argument 5, got %+v instead", e)
+		}
+
+		if events := s.Events(); len(events) != 2 || events[0].Name != "book_hotel" || !events[0].HasAckID {
+			t.Errorf("Expected book_hotel and rate events, got %+v instead", events)
+		}
+
+		c.Close()
+		s.Close()
+	}
+}
+
+func TestServerEmit(t *testing.T) {
+	s := NewServer(nil)
+	defer s.Close()
+
+	received := make(chan string, 1)
+	c := gosocketio.New(s.URL(), &gosocketio.Options{EngineIO: 4})
+	defer c.Close()
+
+	if err := c.On("news", func(headline string) {
+		received <- headline
+	}); err != nil {
+		t.Fatalf("Expected no error adding listener, got %v instead", err)
+	}
+
+	if err := c.On("question", func(q string) string {
+		return q + "? 42"
+	}); err != nil {
+		t.Fatalf("Expected no error adding listener, got %v instead", err)
+	}
+
+	if err := s.Emit("news", "early"); err != ErrNoSession {
+		t.Errorf("Expected error to be %v, got %v instead", ErrNoSession, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := c.Open(ctx); err != nil {
+		t.Fatalf("Expected no error opening client, got %v instead", err)
+	}
+
+	if err := s.Of("/").WaitJoin(ctx); err != nil {
+		t.Fatalf("Expected no error waiting for client, got %v instead", err)
+	}
+
+	if err := s.Emit("news", "Go 2 released"); err != nil {
+		t.Errorf("Expected no error emitting, got %v instead", err)
+	}
+
+	if headline := <-received; headline != "Go 2 released" {
+		t.Errorf("Expected headline to be Go 2 released, got %v instead", headline)
+	}
+
+	args, err := s.Of("/").EmitWithAck(ctx, "question", "meaning of life")
+
+	if err != nil {
+		t.Fatalf("Expected no error waiting for ack, got %v instead", err)
+	}
+
+	var answer string
+
+	if err := args.Unmarshal(0, &answer); err != nil || answer != "meaning of life? 42" {
+		t.Errorf("Expected answer to be meaning of life? 42, got %v (%v) instead", answer, err)
+	}
+}
+
+func TestServerRepeatedAck(t *testing.T) {
+	s := NewServer(nil)
+	defer s.Close()
+
+	u := s.URL()
+	u.RawQuery = "EIO=4&transport=websocket"
+	conn, _, err := ws.DefaultDialer.Dial(u.String(), nil)
+
+	if err != nil {
+		t.Fatalf("Expected no error dialing, got %v instead", err)
+	}
+
+	defer conn.Close()
+
+	// the handshake
+	_, _, _ = conn.ReadMessage()
+	_ = conn.WriteMessage(ws.TextMessage, []byte("40"))
+	_, _, _ = conn.ReadMessage()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	acked := make(chan error, 1)
+
+	go func() {
+		_, err := s.Of("/").EmitWithAck(ctx, "question")
+		acked <- err
+	}()
+
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != `421["question"]` {
+		t.Fatalf("Expected question with an ack, got %q (%v) instead", data, err)
+	}
+
+	// the same ack repeated mustn't keep the server from reading what comes next
+	for _, packet := range []string{`431["a"]`, `431["a"]`, `431["a"]`, `42["after"]`} {
+		_ = conn.WriteMessage(ws.TextMessage, []byte(packet))
+	}
+
+	if err := <-acked; err != nil {
+		t.Errorf("Expected no error waiting for ack, got %v instead", err)
+	}
+
+	if _, err := s.WaitEvent(ctx, "after"); err != nil {
+		t.Errorf("Expected event after the repeated ack, got %v instead", err)
+	}
+}
+
+func TestServerRefuse(t *testing.T) {
+	s := NewServer(nil)
+	defer s.Close()
+
+	s.Of("/admin").Refuse(map[string]string{"message": "Not authorized"})
+
+	c := open(t, s, 4)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_, err := c.OfContext(ctx, "/admin", nil)
+	ce, ok := err.(gosocketio.ConnectError)
+
+	if !ok || ce.Message() != "Not authorized" {
+		t.Fatalf("Expected connection to be refused with Not authorized, got %v instead", err)
+	}
+
+	s.Of("/admin").Accept()
+
+	if _, err := c.OfContext(ctx, "/admin", nil); err != nil {
+		t.Errorf("Expected no error joining namespace, got %v instead", err)
+	}
+}
+
+func TestServerNamespaceDisconnect(t *testing.T) {
+	s := NewServer(nil)
+	defer s.Close()
+
+	c := open(t, s, 4)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	n, err := c.OfContext(ctx, "/chat", nil)
+
+	if err != nil {
+		t.Fatalf("Expected no error joining namespace, got %v instead", err)
+	}
+
+	ch := make(chan gosocketio.Transition, 10)
+	n.NotifyState(ch)
+	s.Of("/chat").Disconnect()
+
+	select {
+	case tr := <-ch:
+		if tr.To != gosocketio.StateDisconnected || tr.Cause != gosocketio.ErrServerDisconnect {
+			t.Errorf("Expected namespace to be disconnected by the server, got %+v instead", tr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected namespace to be disconnected")
+	}
+
+	if err := s.Of("/chat").Emit("message", "hi"); err != ErrNoSession {
+		t.Errorf("Expected error to be %v, got %v instead", ErrNoSession, err)
+	}
+}
+
+func TestServerDisconnect(t *testing.T) {
+	s := NewServer(nil)
+	defer s.Close()
+
+	c := open(t, s, 4)
+	defer c.Close()
+
+	ch := make(chan gosocketio.Transition, 10)
+	c.NotifyState(ch)
+	s.Disconnect()
+
+	if tr := waitState(t, ch, gosocketio.StateClosed); tr.Cause != gosocketio.ErrServerDisconnect {
+		t.Errorf("Expected cause to be %v, got %v instead", gosocketio.ErrServerDisconnect, tr.Cause)
+	}
+}
+
+func TestServerDrop(t *testing.T) {
+	s := NewServer(nil)
+	defer s.Close()
+
+	c := gosocketio.New(s.URL(), &gosocketio.Options{
+		EngineIO:       4,
+		Reconnect:      true,
+		ReconnectDelay: time.Millisecond,
+	})
+
+	defer c.Close()
+
+	ch := make(chan gosocketio.Transition, 10)
+	c.NotifyState(ch)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := c.Open(ctx); err != nil {
+		t.Fatalf("Expected no error opening client, got %v instead", err)
+	}
+
+	waitState(t, ch, gosocketio.StateConnected)
+	s.Drop()
+
+	if tr := waitState(t, ch, gosocketio.StateReconnecting); tr.Cause == nil {
+		t.Errorf("Expected a cause for reconnecting")
+	}
+
+	waitState(t, ch, gosocketio.StateConnected)
+
+	if n := s.Connections(); n != 2 {
+		t.Errorf("Expected 2 connections, got %v instead", n)
+	}
+}
+
+func TestServerClock(t *testing.T) {
+	f := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := NewServer(&Options{Clock: f})
+	defer s.Close()
+
+	c := open(t, s, 4)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// the pings of the server run on its clock
+	if err := f.WaitTimers(ctx, 1); err != nil {
+		t.Fatalf("Expected ping ticker to start, got %v instead", err)
+	}
+
+	f.Advance(PingInterval)
+
+	if err := f.WaitTimers(ctx, 1); err != nil {
+		t.Errorf("Expected pings to keep going, got %v instead", err)
+	}
+
+	if got := c.State(); got != gosocketio.StateConnected {
+		t.Errorf("Expected client to be connected, got %v instead", got)
+	}
+}
@@ -0,0 +1,89 @@
+package gosocketio
+
+import "time"
+
+// State of the connection or of a namespace.
+type State int
+
+const (
+	// StateDisconnected is used when a namespace isn't joined, or before opening the connection.
+	StateDisconnected State = iota
+
+	// StateDialing is used while dialing the server.
+	StateDialing
+
+	// StateConnecting is used while waiting for the server to accept the connection:
+	// the engine.io handshake for the connection, or the socket.io connect packet for a namespace.
+	StateConnecting
+
+	// StateConnected is used when the connection or namespace is ready.
+	StateConnected
+
+	// StateReconnecting is used while waiting to reconnect after the connection is lost.
+	StateReconnecting
+
+	// StateClosing is used while the connection is shutting down.
+	StateClosing
+
+	// StateClosed is used when the connection is closed.
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateDialing:
+		return "dialing"
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosing:
+		return "closing"
+	case StateClosed:
+		return "closed"
+	}
+
+	return "unknown"
+}
+
+// Transition from one state to another.
+type Transition struct {
+	// Namespace that changed state, or nil if it was the connection.
+	Namespace *Namespace
+
+	From State
+	To   State
+	At   time.Time
+
+	// Cause of the transition, if any.
+	Cause error
+}
+
+type stateWatchers []chan<- Transition
+
+func (sw *stateWatchers) add(c chan<- Transition) {
+	*sw = append(*sw, c)
+}
+
+func (sw *stateWatchers) remove(c chan<- Transition) {
+	for i, w := range *sw {
+		if w == c {
+			*sw = append((*sw)[:i], (*sw)[i+1:]...)
+			return
+		}
+	}
+}
+
+// notify doesn't block sending to the watchers.
+func (sw stateWatchers) notify(t Transition) {
+	for _, w := range sw {
+		select {
+		case w <- t:
+		default:
+		}
+	}
+}
@@ -0,0 +1,537 @@
+// Package fault wraps a transport to inject faults into its connections, such as dropped or delayed frames,
+// to test how the client copes with unreliable networks.
+//
+// Faults are injected on command, with rules such as "drop the next pong" or "cut the connection after 3 events":
+//
+//	ft := fault.New(transport.NewPipe())
+//	ft.Outgoing(fault.Engine(protocol.EnginePong)).Drop()
+//	ft.Outgoing(fault.Packet(protocol.Event)).After(3).Cut()
+//
+// or at random, from a seeded schedule, with Chaos.
+package fault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/wedeploy/gosocketio/clock"
+	"github.com/wedeploy/gosocketio/protocol"
+	"github.com/wedeploy/gosocketio/transport"
+)
+
+var (
+	// ErrCut is returned when reading from or writing to a connection that was cut.
+	ErrCut = errors.New("transport connection cut by fault injection")
+
+	// ErrNegativeDelay is returned by Chaos when MaxDelay is negative.
+	ErrNegativeDelay = errors.New("transport fault delay can't be negative")
+)
+
+// Direction of frames.
+type Direction int
+
+const (
+	// Incoming frames are read from the connection.
+	Incoming Direction = iota
+
+	// Outgoing frames are written to the connection.
+	Outgoing
+)
+
+func (d Direction) String() string {
+	if d == Incoming {
+		return "incoming"
+	}
+
+	return "outgoing"
+}
+
+// Matcher tells whether a rule applies to a frame.
+type Matcher func(f transport.Frame) bool
+
+// Any frame.
+func Any(f transport.Frame) bool {
+	return true
+}
+
+// Binary frames.
+func Binary(f transport.Frame) bool {
+	return f.Binary
+}
+
+// Engine matches the text frames of Engine.IO packets of the given type, such as protocol.EnginePong.
+func Engine(t protocol.EnginePacketType) Matcher {
+	return func(f transport.Frame) bool {
+		return !f.Binary && len(f.Data) != 0 && f.Data[0] == '0'+byte(t)
+	}
+}
+
+// Packet matches the text frames of socket.io packets of the given type, such as protocol.Event.
+func Packet(t protocol.PacketType) Matcher {
+	return func(f transport.Frame) bool {
+		return !f.Binary && len(f.Data) > 1 && f.Data[0] == '0'+byte(protocol.EngineMessage) && f.Data[1] == '0'+byte(t)
+	}
+}
+
+// Event matches the text frames of socket.io events with the given name.
+func Event(name string) Matcher {
+	return func(f transport.Frame) bool {
+		var p protocol.Packet
+
+		if f.Binary || p.UnmarshalText(f.Data) != nil || p.EngineType != protocol.EngineMessage {
+			return false
+		}
+
+		if p.Type != protocol.Event && p.Type != protocol.BinaryEvent {
+			return false
+		}
+
+		var args []json.RawMessage
+		var event string
+
+		if json.Unmarshal(p.Data, &args) != nil || len(args) == 0 || json.Unmarshal(args[0], &event) != nil {
+			return false
+		}
+
+		return event == name
+	}
+}
+
+type action int
+
+const (
+	actionDrop action = iota
+	actionDelay
+	actionReorder
+	actionModify
+	actionCut
+)
+
+// Rule injects a fault into the frames it matches. It applies to the first matching frame,
+// unless changed with After and Times, and is added to the transport by its fault, such as Drop.
+type Rule struct {
+	t     *Transport
+	dir   Direction
+	match Matcher
+
+	after int
+	times int
+	seen  int
+
+	action action
+	delay  time.Duration
+	modify func(transport.Frame) transport.Frame
+}
+
+// After lets n matching frames through before the rule applies.
+func (r *Rule) After(n int) *Rule {
+	r.after = n
+	return r
+}
+
+// Times the rule applies. If negative, it applies to every matching frame.
+func (r *Rule) Times(n int) *Rule {
+	r.times = n
+	return r
+}
+
+// Drop the frame.
+func (r *Rule) Drop() {
+	r.add(actionDrop)
+}
+
+// Delay the frame by d. The frames after it wait, so they are kept in order.
+func (r *Rule) Delay(d time.Duration) {
+	r.delay = d
+	r.add(actionDelay)
+}
+
+// Reorder the frame with the next one, which is delivered first.
+func (r *Rule) Reorder() {
+	r.add(actionReorder)
+}
+
+// Corrupt the frame, cutting it in half, so it can't be decoded.
+func (r *Rule) Corrupt() {
+	r.Modify(corrupt)
+}
+
+// Modify the frame with f.
+func (r *Rule) Modify(f func(transport.Frame) transport.Frame) {
+	r.modify = f
+	r.add(actionModify)
+}
+
+// Cut the connection instead of delivering the frame.
+func (r *Rule) Cut() {
+	r.add(actionCut)
+}
+
+func (r *Rule) add(a action) {
+	r.action = a
+	r.t.locker.Lock()
+	r.t.rules = append(r.t.rules, r)
+	r.t.locker.Unlock()
+}
+
+func corrupt(f transport.Frame) transport.Frame {
+	f.Data = f.Data[:len(f.Data)/2]
+	return f
+}
+
+// Chaos injects faults at random, each with the given probability for every frame, from 0 to 1.
+type Chaos struct {
+	Drop    float64
+	Delay   float64
+	Reorder float64
+	Corrupt float64
+	Cut     float64
+
+	// MaxDelay of delayed frames. If zero, MaxDelay is used.
+	MaxDelay time.Duration
+}
+
+// MaxDelay of frames delayed by Chaos.
+const MaxDelay = 100 * time.Millisecond
+
+// Transport injecting faults into the connections dialed by the wrapped transport.
+// Rules are shared by all the connections, so they apply across reconnections.
+type Transport struct {
+	// Clock for the delays. If nil, clock.Real is used.
+	Clock clock.Clock
+
+	tr transport.Transport
+
+	rules []*Rule
+	chaos [2]*schedule
+	conns map[*Conn]struct{}
+
+	locker sync.Mutex
+}
+
+// New wraps the transport.
+func New(tr transport.Transport) *Transport {
+	return &Transport{
+		tr:    tr,
+		conns: map[*Conn]struct{}{},
+	}
+}
+
+// Incoming creates a rule for the frames read that match m.
+func (t *Transport) Incoming(m Matcher) *Rule {
+	return t.rule(Incoming, m)
+}
+
+// Outgoing creates a rule for the frames written that match m.
+func (t *Transport) Outgoing(m Matcher) *Rule {
+	return t.rule(Outgoing, m)
+}
+
+func (t *Transport) rule(dir Direction, m Matcher) *Rule {
+	return &Rule{
+		t:     t,
+		dir:   dir,
+		match: m,
+		times: 1,
+	}
+}
+
+// Chaos injects faults at random into the frames going in the given direction, with a schedule from the seed.
+// The same seed and frames get the same faults. Frames matching a rule are left to it.
+func (t *Transport) Chaos(dir Direction, seed int64, c Chaos) error {
+	if c.MaxDelay < 0 {
+		return ErrNegativeDelay
+	}
+
+	if c.MaxDelay == 0 {
+		c.MaxDelay = MaxDelay
+	}
+
+	t.locker.Lock()
+	t.chaos[dir] = &schedule{c, rand.New(rand.NewSource(seed))}
+	t.locker.Unlock()
+	return nil
+}
+
+// Reset removes the rules and stops the chaos.
+func (t *Transport) Reset() {
+	t.locker.Lock()
+	t.rules = nil
+	t.chaos = [2]*schedule{}
+	t.locker.Unlock()
+}
+
+// Cut the open connections now.
+func (t *Transport) Cut() {
+	t.locker.Lock()
+	var conns []*Conn
+
+	for c := range t.conns {
+		conns = append(conns, c)
+	}
+
+	t.locker.Unlock()
+
+	for _, c := range conns {
+		c.cut()
+	}
+}
+
+// Dial with the wrapped transport.
+func (t *Transport) Dial(ctx context.Context, url string) (transport.Conn, error) {
+	conn, err := t.tr.Dial(ctx, url)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conn{
+		Conn: conn,
+		t:    t,
+		done: make(chan struct{}),
+	}
+
+	t.locker.Lock()
+	t.conns[c] = struct{}{}
+	t.locker.Unlock()
+
+	return c, nil
+}
+
+// fault for the frame, if any: either from a rule or from the chaos.
+func (t *Transport) fault(dir Direction, f transport.Frame) *Rule {
+	t.locker.Lock()
+	defer t.locker.Unlock()
+
+	var fault *Rule
+
+	for _, r := range t.rules {
+		if r.dir != dir || r.times == 0 || !r.match(f) {
+			continue
+		}
+
+		r.seen++
+
+		if fault == nil && r.seen > r.after {
+			fault = r
+
+			if r.times > 0 {
+				r.times--
+			}
+		}
+	}
+
+	if fault != nil || t.chaos[dir] == nil {
+		return fault
+	}
+
+	return t.chaos[dir].next(dir)
+}
+
+// schedule of the faults injected at random.
+type schedule struct {
+	Chaos
+	rand *rand.Rand
+}
+
+// next fault, if any. The same numbers are drawn for every frame, so the schedule only depends on the frames.
+func (c *schedule) next(dir Direction) *Rule {
+	n := c.rand.Float64()
+	delay := time.Duration(c.rand.Int63n(int64(c.MaxDelay)) + 1)
+
+	for _, p := range []struct {
+		probability float64
+		rule        Rule
+	}{
+		{c.Drop, Rule{action: actionDrop}},
+		{c.Delay, Rule{action: actionDelay, delay: delay}},
+		{c.Reorder, Rule{action: actionReorder}},
+		{c.Corrupt, Rule{action: actionModify, modify: corrupt}},
+		{c.Cut, Rule{action: actionCut}},
+	} {
+		if n < p.probability {
+			r := p.rule
+			r.dir = dir
+			return &r
+		}
+
+		n -= p.probability
+	}
+
+	return nil
+}
+
+func (t *Transport) remove(c *Conn) {
+	t.locker.Lock()
+	delete(t.conns, c)
+	t.locker.Unlock()
+}
+
+// Conn injecting faults into the frames of the wrapped connection.
+type Conn struct {
+	transport.Conn
+
+	t *Transport
+
+	// frames held to be delivered after the next one
+	readHeld  *transport.Frame
+	readNext  *transport.Frame
+	writeHeld *transport.Frame
+
+	cutOff    bool
+	cutLocker sync.RWMutex
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// ReadFrame from the wrapped connection, injecting the faults for incoming frames.
+func (c *Conn) ReadFrame() (transport.Frame, error) {
+	if c.readNext != nil {
+		f := *c.readNext
+		c.readNext = nil
+		return f, nil
+	}
+
+	for {
+		if c.isCut() {
+			return transport.Frame{}, ErrCut
+		}
+
+		f, err := c.Conn.ReadFrame()
+
+		if err != nil {
+			if c.isCut() {
+				return f, ErrCut
+			}
+
+			return f, err
+		}
+
+		r := c.t.fault(Incoming, f)
+
+		if r == nil {
+			return c.read(f), nil
+		}
+
+		switch r.action {
+		case actionDrop:
+			continue
+		case actionDelay:
+			if !c.sleep(r.delay) {
+				return transport.Frame{}, ErrCut
+			}
+
+			return c.read(f), nil
+		case actionReorder:
+			if c.readHeld == nil {
+				c.readHeld = &f
+				continue
+			}
+
+			return c.read(f), nil
+		case actionModify:
+			return c.read(r.modify(f)), nil
+		default:
+			c.cut()
+			return transport.Frame{}, ErrCut
+		}
+	}
+}
+
+// read the frame, delivering the held one, if any, right after it.
+func (c *Conn) read(f transport.Frame) transport.Frame {
+	c.readNext, c.readHeld = c.readHeld, nil
+	return f
+}
+
+// WriteFrame to the wrapped connection, injecting the faults for outgoing frames.
+func (c *Conn) WriteFrame(f transport.Frame) error {
+	if c.isCut() {
+		return ErrCut
+	}
+
+	r := c.t.fault(Outgoing, f)
+
+	if r == nil {
+		return c.write(f)
+	}
+
+	switch r.action {
+	case actionDrop:
+		return nil
+	case actionDelay:
+		if !c.sleep(r.delay) {
+			return ErrCut
+		}
+
+		return c.write(f)
+	case actionReorder:
+		if c.writeHeld == nil {
+			c.writeHeld = &f
+			return nil
+		}
+
+		return c.write(f)
+	case actionModify:
+		return c.write(r.modify(f))
+	default:
+		c.cut()
+		return ErrCut
+	}
+}
+
+// write the frame, and then the held one, if any.
+func (c *Conn) write(f transport.Frame) error {
+	if err := c.Conn.WriteFrame(f); err != nil {
+		return err
+	}
+
+	if c.writeHeld == nil {
+		return nil
+	}
+
+	held := *c.writeHeld
+	c.writeHeld = nil
+	return c.Conn.WriteFrame(held)
+}
+
+func (c *Conn) sleep(d time.Duration) bool {
+	timer := clock.Or(c.t.Clock).NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+func (c *Conn) isCut() bool {
+	c.cutLocker.RLock()
+	cut := c.cutOff
+	c.cutLocker.RUnlock()
+	return cut
+}
+
+// cut the connection, like a network failure would.
+func (c *Conn) cut() {
+	c.cutLocker.Lock()
+	c.cutOff = true
+	c.cutLocker.Unlock()
+	c.Close()
+}
+
+// Close the wrapped connection.
+func (c *Conn) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.t.remove(c)
+		c.Conn.Close()
+	})
+}
@@ -0,0 +1,253 @@
+package fault
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/wedeploy/gosocketio/clock"
+	"github.com/wedeploy/gosocketio/protocol"
+	"github.com/wedeploy/gosocketio/transport"
+)
+
+// dial a connection through the fault transport, returning it with the end of the peer.
+func dial(t *testing.T, ft *Transport, p *transport.Pipe) (transport.Conn, *transport.PipeConn) {
+	t.Helper()
+	accepted := make(chan *transport.PipeConn, 1)
+
+	go func() {
+		peer, _ := p.Accept(context.Background())
+		accepted <- peer
+	}()
+
+	c, err := ft.Dial(context.Background(), "pipe")
+
+	if err != nil {
+		t.Fatalf("Expected no error dialing, got %v instead", err)
+	}
+
+	return c, <-accepted
+}
+
+func write(c transport.Conn, frames ...string) {
+	for _, f := range frames {
+		_ = c.WriteFrame(transport.Frame{Data: []byte(f)})
+	}
+}
+
+// read n frames.
+func read(t *testing.T, c transport.Conn, n int) (got []string) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		f, err := c.ReadFrame()
+
+		if err != nil {
+			t.Fatalf("Expected no error reading frame, got %v instead", err)
+		}
+
+		got = append(got, string(f.Data))
+	}
+
+	return got
+}
+
+func TestDrop(t *testing.T) {
+	p := transport.NewPipe()
+	ft := New(p)
+	ft.Outgoing(Engine(protocol.EnginePong)).Drop()
+
+	c, peer := dial(t, ft, p)
+	write(c, "3", "4x", "3")
+
+	if got, want := read(t, peer, 2), []string{"4x", "3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected peer to read %v, got %v instead", want, got)
+	}
+}
+
+func TestCutAfter(t *testing.T) {
+	p := transport.NewPipe()
+	ft := New(p)
+	ft.Outgoing(Packet(protocol.Event)).After(3).Cut()
+
+	c, peer := dial(t, ft, p)
+	write(c, `42["a"]`, `3`, `42["b"]`, `42["c"]`)
+
+	if err := c.WriteFrame(transport.Frame{Data: []byte(`42["d"]`)}); err != ErrCut {
+		t.Errorf("Expected error to be %v, got %v instead", ErrCut, err)
+	}
+
+	if _, err := c.ReadFrame(); err != ErrCut {
+		t.Errorf("Expected error to be %v, got %v instead", ErrCut, err)
+	}
+
+	if got, want := read(t, peer, 4), []string{`42["a"]`, `3`, `42["b"]`, `42["c"]`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected peer to read %v, got %v instead", want, got)
+	}
+
+	if _, err := peer.ReadFrame(); err != io.EOF {
+		t.Errorf("Expected connection to be closed, got %v instead", err)
+	}
+}
+
+func TestTimes(t *testing.T) {
+	p := transport.NewPipe()
+	ft := New(p)
+	ft.Incoming(Event("tick")).Times(-1).Drop()
+	ft.Incoming(Engine(protocol.EnginePing)).After(1).Times(2).Drop()
+
+	c, peer := dial(t, ft, p)
+	write(peer, `42["tick"]`, `2`, `42["tock"]`, `2`, `42["tick",1]`, `2`, `2`)
+
+	if got, want := read(t, c, 3), []string{`2`, `42["tock"]`, `2`}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected to read %v, got %v instead", want, got)
+	}
+}
+
+func TestReorder(t *testing.T) {
+	p := transport.NewPipe()
+	ft := New(p)
+	ft.Incoming(Any).Reorder()
+	ft.Outgoing(Any).After(1).Reorder()
+
+	c, peer := dial(t, ft, p)
+	write(peer, "a", "b", "c")
+
+	if got, want := read(t, c, 3), []string{"b", "a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected to read %v, got %v instead", want, got)
+	}
+
+	write(c, "a", "b", "c")
+
+	if got, want := read(t, peer, 3), []string{"a", "c", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected peer to read %v, got %v instead", want, got)
+	}
+}
+
+func TestDelayAndCorrupt(t *testing.T) {
+	p := transport.NewPipe()
+	ft := New(p)
+	ft.Outgoing(Any).Delay(30 * time.Millisecond)
+	ft.Incoming(Any).Corrupt()
+
+	c, peer := dial(t, ft, p)
+	start := time.Now()
+	write(c, "a")
+
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("Expected frame to be delayed, got written in %v instead", elapsed)
+	}
+
+	write(peer, `42["hello"]`)
+
+	if got := read(t, c, 1); got[0] != `42["h` {
+		t.Errorf("Expected frame to be corrupted, got %v instead", got[0])
+	}
+}
+
+func TestDelayClock(t *testing.T) {
+	p := transport.NewPipe()
+	f := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ft := New(p)
+	ft.Clock = f
+	ft.Outgoing(Any).Delay(time.Minute)
+
+	c, peer := dial(t, ft, p)
+	written := make(chan struct{})
+
+	go func() {
+		write(c, "a")
+		close(written)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := f.WaitTimers(ctx, 1); err != nil {
+		t.Fatalf("Expected delay to start, got %v instead", err)
+	}
+
+	select {
+	case <-written:
+		t.Fatal("Expected frame to be delayed until the clock is advanced")
+	default:
+	}
+
+	f.Advance(time.Minute)
+	<-written
+
+	if got := read(t, peer, 1); got[0] != "a" {
+		t.Errorf("Expected frame to be a, got %v instead", got[0])
+	}
+}
+
+func TestTransportCut(t *testing.T) {
+	p := transport.NewPipe()
+	ft := New(p)
+	c, _ := dial(t, ft, p)
+	read := make(chan error, 1)
+
+	go func() {
+		_, err := c.ReadFrame()
+		read <- err
+	}()
+
+	ft.Cut()
+
+	if err := <-read; err != ErrCut {
+		t.Errorf("Expected error to be %v, got %v instead", ErrCut, err)
+	}
+}
+
+func TestChaos(t *testing.T) {
+	var results [2][]string
+
+	for i := range results {
+		p := transport.NewPipe()
+		ft := New(p)
+		if err := ft.Chaos(Outgoing, 42, Chaos{
+			Drop:    0.2,
+			Reorder: 0.2,
+			Corrupt: 0.2,
+		}); err != nil {
+			t.Fatalf("Expected no error starting chaos, got %v instead", err)
+		}
+
+		c, peer := dial(t, ft, p)
+
+		for j := 0; j < 50; j++ {
+			write(c, `42["tick",`+strconv.Itoa(j)+`]`)
+		}
+
+		c.Close()
+
+		for {
+			f, err := peer.ReadFrame()
+
+			if err != nil {
+				break
+			}
+
+			results[i] = append(results[i], string(f.Data))
+		}
+	}
+
+	if !reflect.DeepEqual(results[0], results[1]) {
+		t.Errorf("Expected the same seed to inject the same faults, got %v and %v instead", results[0], results[1])
+	}
+
+	if n := len(results[0]); n == 0 || n >= 50 {
+		t.Errorf("Expected some frames to be dropped, got %v frames instead", n)
+	}
+}
+
+func TestChaosNegativeDelay(t *testing.T) {
+	ft := New(transport.NewPipe())
+
+	if err := ft.Chaos(Outgoing, 42, Chaos{Delay: 1, MaxDelay: -time.Second}); err != ErrNegativeDelay {
+		t.Errorf("Expected error to be %v, got %v instead", ErrNegativeDelay, err)
+	}
+}
@@ -0,0 +1,185 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrPipeClosed is returned when dialing or accepting on a closed Pipe.
+var ErrPipeClosed = errors.New("transport pipe is closed")
+
+// Pipe is an in-memory Transport. Each dial creates a pair of connected PipeConns,
+// and the end for the peer, such as a test server, is received with Accept.
+// Frames are delivered in order, without going through the network.
+type Pipe struct {
+	// PingInterval and PingTimeout returned by PingParams. If zero, the defaults of the caller are used.
+	PingInterval time.Duration
+	PingTimeout  time.Duration
+
+	conns     chan *PipeConn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewPipe creates an in-memory transport.
+func NewPipe() *Pipe {
+	return &Pipe{
+		conns: make(chan *PipeConn),
+		done:  make(chan struct{}),
+	}
+}
+
+// Dial creates a connection, waiting for the peer to accept it.
+func (p *Pipe) Dial(ctx context.Context, url string) (Conn, error) {
+	local, peer := p.pair(url)
+
+	select {
+	case p.conns <- peer:
+		return local, nil
+	case <-p.done:
+		return nil, ErrPipeClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Accept waits for the next dial, returning the end of the connection for the peer.
+func (p *Pipe) Accept(ctx context.Context) (*PipeConn, error) {
+	select {
+	case c := <-p.conns:
+		return c, nil
+	case <-p.done:
+		return nil, ErrPipeClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close the pipe, so no more connections are dialed. Existing connections are kept open.
+func (p *Pipe) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+}
+
+func (p *Pipe) pair(url string) (local *PipeConn, peer *PipeConn) {
+	a, b := newPipeQueue(), newPipeQueue()
+
+	local = &PipeConn{url: url, in: a, out: b, interval: p.PingInterval, timeout: p.PingTimeout}
+	peer = &PipeConn{url: url, in: b, out: a, interval: p.PingInterval, timeout: p.PingTimeout}
+	return local, peer
+}
+
+// Pair of connected PipeConns, for when there is nothing to dial.
+func Pair() (*PipeConn, *PipeConn) {
+	return NewPipe().pair("")
+}
+
+// PipeConn is one end of an in-memory connection.
+// Writes never block: frames wait for the other end to read them.
+// Once the other end is closed, the frames already written can still be read, and then reading returns io.EOF.
+type PipeConn struct {
+	url string
+	in  *pipeQueue
+	out *pipeQueue
+
+	interval time.Duration
+	timeout  time.Duration
+}
+
+// URL dialed for the connection.
+func (c *PipeConn) URL() string {
+	return c.url
+}
+
+// ReadFrame waits for the next frame written by the other end.
+func (c *PipeConn) ReadFrame() (Frame, error) {
+	return c.in.pop()
+}
+
+// WriteFrame for the other end to read.
+func (c *PipeConn) WriteFrame(f Frame) error {
+	return c.out.push(f)
+}
+
+// Close the connection.
+func (c *PipeConn) Close() {
+	c.in.abort()
+	c.out.close()
+}
+
+// PingParams of the pipe.
+func (c *PipeConn) PingParams() (interval, timeout time.Duration) {
+	return c.interval, c.timeout
+}
+
+// pipeQueue holds the frames going in one direction.
+type pipeQueue struct {
+	frames []Frame
+
+	// closed by the writer, aborted by the reader
+	closed  bool
+	aborted bool
+
+	cond   *sync.Cond
+	locker sync.Mutex
+}
+
+func newPipeQueue() *pipeQueue {
+	q := &pipeQueue{}
+	q.cond = sync.NewCond(&q.locker)
+	return q
+}
+
+func (q *pipeQueue) push(f Frame) error {
+	q.locker.Lock()
+	defer q.locker.Unlock()
+
+	if q.closed || q.aborted {
+		return io.ErrClosedPipe
+	}
+
+	// the writer may reuse its buffer
+	f.Data = append([]byte(nil), f.Data...)
+	q.frames = append(q.frames, f)
+	q.cond.Signal()
+	return nil
+}
+
+func (q *pipeQueue) pop() (Frame, error) {
+	q.locker.Lock()
+	defer q.locker.Unlock()
+
+	for len(q.frames) == 0 && !q.closed && !q.aborted {
+		q.cond.Wait()
+	}
+
+	switch {
+	case q.aborted:
+		return Frame{}, io.ErrClosedPipe
+	case len(q.frames) == 0:
+		return Frame{}, io.EOF
+	}
+
+	f := q.frames[0]
+	q.frames = q.frames[1:]
+	return f, nil
+}
+
+func (q *pipeQueue) close() {
+	q.locker.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.locker.Unlock()
+}
+
+func (q *pipeQueue) abort() {
+	q.locker.Lock()
+	q.aborted = true
+	q.frames = nil
+	q.cond.Broadcast()
+	q.locker.Unlock()
+}
@@ -0,0 +1,117 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestPair(t *testing.T) {
+	a, b := Pair()
+
+	for _, f := range []Frame{{Data: []byte("1")}, {Data: []byte{2}, Binary: true}} {
+		if err := a.WriteFrame(f); err != nil {
+			t.Errorf("Expected no error writing frame, got %v instead", err)
+		}
+	}
+
+	if f, err := b.ReadFrame(); err != nil || f.Binary || string(f.Data) != "1" {
+		t.Errorf("Expected text frame 1, got %+v (%v) instead", f, err)
+	}
+
+	// frames written before closing can still be read
+	a.Close()
+
+	if f, err := b.ReadFrame(); err != nil || !f.Binary || f.Data[0] != 2 {
+		t.Errorf("Expected binary frame, got %+v (%v) instead", f, err)
+	}
+
+	if _, err := b.ReadFrame(); err != io.EOF {
+		t.Errorf("Expected error to be %v, got %v instead", io.EOF, err)
+	}
+
+	if err := b.WriteFrame(Frame{Data: []byte("3")}); err != io.ErrClosedPipe {
+		t.Errorf("Expected error to be %v, got %v instead", io.ErrClosedPipe, err)
+	}
+
+	if _, err := a.ReadFrame(); err != io.ErrClosedPipe {
+		t.Errorf("Expected error to be %v, got %v instead", io.ErrClosedPipe, err)
+	}
+}
+
+func TestPairCloseWhileReading(t *testing.T) {
+	a, _ := Pair()
+	read := make(chan error, 1)
+
+	go func() {
+		_, err := a.ReadFrame()
+		read <- err
+	}()
+
+	a.Close()
+
+	select {
+	case err := <-read:
+		if err != io.ErrClosedPipe {
+			t.Errorf("Expected error to be %v, got %v instead", io.ErrClosedPipe, err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected read to fail once the connection is closed")
+	}
+}
+
+func TestPipe(t *testing.T) {
+	p := NewPipe()
+	p.PingInterval = time.Second
+	accepted := make(chan *PipeConn, 1)
+
+	go func() {
+		c, err := p.Accept(context.Background())
+
+		if err != nil {
+			t.Errorf("Expected no error accepting, got %v instead", err)
+		}
+
+		accepted <- c
+	}()
+
+	c, err := p.Dial(context.Background(), "ws://example.com/socket.io/")
+
+	if err != nil {
+		t.Fatalf("Expected no error dialing, got %v instead", err)
+	}
+
+	peer := <-accepted
+
+	if peer.URL() != "ws://example.com/socket.io/" {
+		t.Errorf("Expected URL to be the one dialed, got %v instead", peer.URL())
+	}
+
+	if interval, _ := c.PingParams(); interval != time.Second {
+		t.Errorf("Expected ping interval to be %v, got %v instead", time.Second, interval)
+	}
+
+	_ = c.WriteFrame(Frame{Data: []byte("hi")})
+
+	if f, err := peer.ReadFrame(); err != nil || string(f.Data) != "hi" {
+		t.Errorf("Expected peer to read hi, got %+v (%v) instead", f, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := p.Dial(ctx, ""); err != context.DeadlineExceeded {
+		t.Errorf("Expected dial to time out without a peer, got %v instead", err)
+	}
+
+	p.Close()
+
+	if _, err := p.Dial(context.Background(), ""); err != ErrPipeClosed {
+		t.Errorf("Expected error to be %v, got %v instead", ErrPipeClosed, err)
+	}
+
+	if _, err := p.Accept(context.Background()); err != ErrPipeClosed {
+		t.Errorf("Expected error to be %v, got %v instead", ErrPipeClosed, err)
+	}
+}
@@ -0,0 +1,40 @@
+// Package transport defines how socket.io traffic is carried, so it isn't tied to WebSocket.
+// The websocket package implements it over the network, and Pipe in memory.
+package transport
+
+import (
+	"context"
+	"time"
+
+	"github.com/wedeploy/gosocketio/protocol"
+)
+
+// Frame of a connection, either text or binary.
+type Frame = protocol.Frame
+
+// Transport dials connections to the server.
+type Transport interface {
+	Dial(ctx context.Context, url string) (Conn, error)
+}
+
+// Conn carries the frames of a connection.
+// Frames are read by a single goroutine, and written by a single goroutine at a time.
+type Conn interface {
+	// ReadFrame waits for the next frame.
+	ReadFrame() (Frame, error)
+
+	// WriteFrame to the peer.
+	WriteFrame(Frame) error
+
+	// Close the connection. Pending reads and writes fail.
+	Close()
+
+	// PingParams of the heartbeat, for when the server doesn't tell them.
+	PingParams() (interval, timeout time.Duration)
+}
+
+// BatchWriter is implemented by connections that can coalesce frames into as few network writes as possible.
+type BatchWriter interface {
+	// WriteFrames to the peer, returning the error for each frame.
+	WriteFrames([]Frame) []error
+}
@@ -0,0 +1,74 @@
+package gosocketio
+
+import (
+	"sync/atomic"
+
+	"github.com/wedeploy/gosocketio/internal/protocol"
+	"github.com/wedeploy/gosocketio/websocket"
+)
+
+// VolatileEmitter emits messages that are dropped rather than queued or waited on,
+// such as cursor positions or progress ticks.
+type VolatileEmitter struct {
+	n *Namespace
+}
+
+// Volatile returns an emitter for messages on the default namespace that may be lost.
+func (c *Client) Volatile() *VolatileEmitter {
+	return c.def().Volatile()
+}
+
+// Volatile returns an emitter for messages on the namespace that may be lost.
+func (n *Namespace) Volatile() *VolatileEmitter {
+	return &VolatileEmitter{n}
+}
+
+// Emit message without blocking.
+// The message is dropped if the namespace isn't connected or the connection is busy writing.
+// Dropped messages are counted by Client.Dropped.
+func (v *VolatileEmitter) Emit(method string, args ...interface{}) error {
+	msg := &protocol.Message{
+		Type:   protocol.MessageTypeEmit,
+		Method: method,
+	}
+
+	frame, err := v.n.encode(msg, args...)
+
+	if err != nil {
+		return err
+	}
+
+	if v.n.isClosing() {
+		return ErrClientClosed
+	}
+
+	if v.n.State() != StateConnected {
+		v.n.drop()
+		return nil
+	}
+
+	v.n.tryWrite(frame)
+	return nil
+}
+
+// tryWrite hands a message to the out loop, unless it would wait behind other messages or the connection isn't open.
+// It doesn't wait for the message to be written.
+func (c *Client) tryWrite(frame websocket.Frame) {
+	if !c.isOpen() {
+		c.drop()
+		return
+	}
+
+	if !c.queue.tryPush(newMsgWriter(frame)) {
+		c.drop()
+	}
+}
+
+func (c *Client) drop() {
+	atomic.AddUint64(&c.dropped, 1)
+}
+
+// Dropped is the number of volatile messages that were dropped.
+func (c *Client) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
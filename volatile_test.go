@@ -0,0 +1,73 @@
+package gosocketio
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestVolatileEmit(t *testing.T) {
+	c, m := connectMock(t)
+	defer m.Close()
+	defer c.Close()
+
+	<-m.conns
+
+	if err := c.Volatile().Emit("cursor", 1); err != nil {
+		t.Errorf("Expected no error emitting volatile message, got %v instead", err)
+	}
+
+	m.expect(t, `42["cursor",1]`)
+
+	if err := c.Emit("cursor", 2); err != nil {
+		t.Errorf("Expected no error emitting message, got %v instead", err)
+	}
+
+	m.expect(t, `42["cursor",2]`)
+
+	if d := c.Dropped(); d != 0 {
+		t.Errorf("Expected no dropped messages, got %v instead", d)
+	}
+}
+
+func TestVolatileEmitDisconnected(t *testing.T) {
+	c := New(url.URL{}, &Options{
+		SendBuffer: 10,
+	})
+
+	if err := c.Volatile().Emit("cursor", 1); err != nil {
+		t.Errorf("Expected no error emitting volatile message, got %v instead", err)
+	}
+
+	if d := c.Dropped(); d != 1 {
+		t.Errorf("Expected 1 dropped message, got %v instead", d)
+	}
+
+	if b := c.def().Buffered(); b != 0 {
+		t.Errorf("Expected volatile message not to be buffered, got %v buffered messages instead", b)
+	}
+
+	c.Close()
+
+	if err := c.Volatile().Emit("cursor", 1); err != ErrClientClosed {
+		t.Errorf("Expected error to be %v, got %v instead", ErrClientClosed, err)
+	}
+}
+
+func TestVolatileEmitBusy(t *testing.T) {
+	c := New(url.URL{}, nil)
+	defer c.Close()
+
+	// there is no out loop reading the queue, as if it was busy writing
+	c.setState(StateConnected, nil)
+	c.def().setReady()
+
+	for i := 0; i < 2; i++ {
+		if err := c.Volatile().Emit("cursor", i); err != nil {
+			t.Errorf("Expected no error emitting volatile message, got %v instead", err)
+		}
+	}
+
+	if d := c.Dropped(); d != 1 {
+		t.Errorf("Expected 1 dropped message, got %v instead", d)
+	}
+}
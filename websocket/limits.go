@@ -0,0 +1,249 @@
+package websocket
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	// MaxFrameSize for the connection, in bytes
+	MaxFrameSize = 1 << 20
+
+	// MaxJSONDepth for the connection
+	MaxJSONDepth = 64
+
+	// MaxArgs for the connection
+	MaxArgs = 64
+)
+
+// Limit of the transport exceeded by a frame.
+type Limit int
+
+const (
+	// LimitFrameSize is exceeded by frames larger than Transport.MaxFrameSize.
+	LimitFrameSize Limit = iota
+
+	// LimitJSONDepth is exceeded by frames with JSON nested deeper than Transport.MaxJSONDepth.
+	LimitJSONDepth
+
+	// LimitArgs is exceeded by packets with more arguments than Transport.MaxArgs.
+	LimitArgs
+)
+
+func (l Limit) String() string {
+	switch l {
+	case LimitFrameSize:
+		return "frame size"
+	case LimitJSONDepth:
+		return "JSON depth"
+	case LimitArgs:
+		return "arguments"
+	}
+
+	return fmt.Sprintf("Limit(%d)", int(l))
+}
+
+// LimitError is returned when reading a frame that exceeds a limit of the transport.
+// The connection is closed.
+type LimitError struct {
+	limit Limit
+	max   int64
+}
+
+func (e LimitError) Error() string {
+	return fmt.Sprintf("frame exceeds the limit of %d for %v", e.max, e.limit)
+}
+
+// Limit exceeded.
+func (e LimitError) Limit() Limit {
+	return e.limit
+}
+
+// Max value allowed by the limit.
+func (e LimitError) Max() int64 {
+	return e.max
+}
+
+// limit of the transport, or its default if it is zero. Negative limits are disabled.
+func limit(value, def int) int {
+	if value == 0 {
+		return def
+	}
+
+	return value
+}
+
+// frameLimit of the transport, or its default if it is zero. Negative limits are disabled.
+func (wst *Transport) frameLimit() int64 {
+	if wst.MaxFrameSize == 0 {
+		return MaxFrameSize
+	}
+
+	return wst.MaxFrameSize
+}
+
+// ReadLimited reads r to the end, like io.ReadAll, for frames that aren't read from a WebSocket connection,
+// such as the ones of a long-polling response. A LimitError is returned if it exceeds MaxFrameSize.
+func (wst *Transport) ReadLimited(r io.Reader) ([]byte, error) {
+	var max = wst.frameLimit()
+
+	if max < 0 {
+		return io.ReadAll(r)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, max+1))
+
+	if err == nil && int64(len(data)) > max {
+		err = LimitError{LimitFrameSize, max}
+	}
+
+	return data, err
+}
+
+// CheckFrame against the JSON limits of the transport, for frames that aren't read from a WebSocket connection.
+// A LimitError is returned if the frame exceeds one of them.
+func (wst *Transport) CheckFrame(f Frame) error {
+	if f.Binary {
+		return nil
+	}
+
+	_, err := newScanner(limit(wst.MaxJSONDepth, MaxJSONDepth), limit(wst.MaxArgs, MaxArgs)).Write(f.Data)
+	return err
+}
+
+// scanner checks text frames against the JSON limits as they are read.
+// The packet header, such as "42/chat,12", comes before the JSON payload,
+// so the scanner skips it, including the namespace, which can have any character up to a comma.
+type scanner struct {
+	maxDepth int
+	maxArgs  int
+
+	pos       int
+	message   bool
+	event     bool
+	header    bool
+	namespace bool
+
+	inString bool
+	escaped  bool
+	depth    int
+	array    bool
+	expect   bool
+	elements int
+}
+
+func newScanner(maxDepth, maxArgs int) *scanner {
+	return &scanner{
+		maxDepth: maxDepth,
+		maxArgs:  maxArgs,
+		header:   true,
+	}
+}
+
+// Write the next bytes of the frame to the scanner.
+func (s *scanner) Write(p []byte) (int, error) {
+	for i, c := range p {
+		if err := s.scan(c); err != nil {
+			return i, err
+		}
+	}
+
+	return len(p), nil
+}
+
+func (s *scanner) scan(c byte) error {
+	s.pos++
+
+	if s.header && !s.skipHeader(c) {
+		return nil
+	}
+
+	if s.inString {
+		switch {
+		case s.escaped:
+			s.escaped = false
+		case c == '\\':
+			s.escaped = true
+		case c == '"':
+			s.inString = false
+		}
+
+		return nil
+	}
+
+	switch c {
+	case ' ', '\t', '\r', '\n', ':':
+		return nil
+	case ',':
+		s.expect = s.depth == 1 && s.array
+		return nil
+	case ']', '}':
+		s.depth--
+		return nil
+	}
+
+	if s.expect {
+		s.expect = false
+		s.elements++
+
+		if err := s.checkArgs(); err != nil {
+			return err
+		}
+	}
+
+	switch c {
+	case '"':
+		s.inString = true
+	case '[', '{':
+		s.depth++
+
+		if s.depth == 1 {
+			s.array = c == '['
+			s.expect = s.array
+		}
+
+		if s.maxDepth > 0 && s.depth > s.maxDepth {
+			return LimitError{LimitJSONDepth, int64(s.maxDepth)}
+		}
+	}
+
+	return nil
+}
+
+// skipHeader tells whether the payload starts at c.
+func (s *scanner) skipHeader(c byte) bool {
+	switch {
+	case s.namespace:
+		s.namespace = c != ','
+		return false
+	case s.pos == 1:
+		s.message = c == '4'
+		return false
+	case s.pos == 2 && s.message:
+		// the event name isn't an argument
+		s.event = c == '2'
+		return false
+	case s.pos == 3 && s.message && c == '/':
+		s.namespace = true
+		return false
+	case c != '[' && c != '{' && c != '"':
+		return false
+	}
+
+	s.header = false
+	return true
+}
+
+func (s *scanner) checkArgs() error {
+	var args = s.elements
+
+	if s.event {
+		args--
+	}
+
+	if s.maxArgs > 0 && args > s.maxArgs {
+		return LimitError{LimitArgs, int64(s.maxArgs)}
+	}
+
+	return nil
+}
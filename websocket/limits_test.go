@@ -0,0 +1,69 @@
+package websocket
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScanner(t *testing.T) {
+	var cases = []struct {
+		packet   string
+		maxDepth int
+		maxArgs  int
+		want     error
+	}{
+		{`42["message","hello"]`, 2, 1, nil},
+		{`42["message","hello","world"]`, 2, 1, LimitError{LimitArgs, 1}},
+		{`42/chat,12["message","hello"]`, 2, 1, nil},
+		{`42/chat,12["message","hello","world"]`, 2, 1, LimitError{LimitArgs, 1}},
+		{`43/chat,12["a","b"]`, 2, 1, LimitError{LimitArgs, 1}},
+		{`431[]`, 2, 0, nil},
+		{`42["a",[1,2,3],{"b":[4,5]}]`, 3, 2, nil},
+		{`42["a",[1,2,3],{"b":[4,5]}]`, 2, 2, LimitError{LimitJSONDepth, 2}},
+		{`42["a",[[[[1]]]]]`, 3, 2, LimitError{LimitJSONDepth, 3}},
+		{`42["a",[[[[1]]]]]`, -1, 2, nil},
+		{`42["a,b,c","[[[[",1]`, 2, 2, nil},
+		{`42["a\"[[[,,,",1]`, 2, 1, nil},
+		{`42/[[[,,,chat,["a",1]`, 2, 1, nil},
+		{`42[  "a" , 1 , 2 ]`, 2, 1, LimitError{LimitArgs, 1}},
+		{`42["a",1,2,3]`, 2, -1, nil},
+		{`40/admin,{"sid":"abc","a":[1,2,3,4]}`, 2, 1, nil},
+		{`0{"sid":"abc","upgrades":[[[]]]}`, 2, 1, LimitError{LimitJSONDepth, 2}},
+		{`2probe`, 1, 1, nil},
+		{`42["a"]` + strings.Repeat("]", 10), 1, 0, nil},
+	}
+
+	for _, c := range cases {
+		s := newScanner(c.maxDepth, c.maxArgs)
+
+		// scan it in small writes, like when reading the frame
+		var err error
+
+		for p := c.packet; p != "" && err == nil; {
+			n := 3
+
+			if n > len(p) {
+				n = len(p)
+			}
+
+			_, err = s.Write([]byte(p[:n]))
+			p = p[n:]
+		}
+
+		if err != c.want {
+			t.Errorf("Expected error scanning %v to be %v, got %v instead", c.packet, c.want, err)
+		}
+	}
+}
+
+func TestLimitError(t *testing.T) {
+	err := LimitError{LimitFrameSize, 1024}
+
+	if err.Limit() != LimitFrameSize || err.Max() != 1024 {
+		t.Errorf("Expected limit to be frame size of 1024, got %v of %v instead", err.Limit(), err.Max())
+	}
+
+	if want := "frame exceeds the limit of 1024 for frame size"; err.Error() != want {
+		t.Errorf("Expected error message to be %v, got %v instead", want, err.Error())
+	}
+}
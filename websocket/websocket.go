@@ -1,12 +1,20 @@
 package websocket
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"errors"
-	"io/ioutil"
+	"io"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	ws "github.com/gorilla/websocket"
+	"github.com/wedeploy/gosocketio/clock"
+	"github.com/wedeploy/gosocketio/protocol"
+	"github.com/wedeploy/gosocketio/transport"
 )
 
 const (
@@ -40,54 +48,208 @@ var (
 // Connection to websocket
 type Connection struct {
 	socket    *ws.Conn
+	conn      *batchConn
 	transport *Transport
+	readLimit int64
+
+	// timers expiring the deadlines when the transport has a clock other than clock.Real
+	readTimer  clock.Timer
+	writeTimer clock.Timer
 }
 
 // GetMessage on connection
 func (c *Connection) GetMessage() (data []byte, err error) {
-	c.socket.SetReadDeadline(time.Now().Add(c.transport.ReadTimeout))
+	f, err := c.ReadFrame()
+
+	if err != nil {
+		return f.Data, err
+	}
+
+	if f.Binary {
+		return nil, ErrUnsupportedBinaryMessage
+	}
+
+	return f.Data, nil
+}
+
+// WriteMessage to the socket
+func (c *Connection) WriteMessage(message string) error {
+	return c.WriteFrame(Frame{
+		Data: []byte(message),
+	})
+}
+
+// Frame of the connection.
+type Frame = protocol.Frame
+
+// ReadFrame from the connection, either text or binary.
+// Frames are checked against the limits of the transport as they are read.
+// If a frame exceeds a limit, the connection is closed and a LimitError is returned.
+func (c *Connection) ReadFrame() (f Frame, err error) {
+	c.deadline(c.readTimer, c.socket.SetReadDeadline, c.transport.ReadTimeout)
+	defer c.stop(c.readTimer)
 
 	msgType, reader, err := c.socket.NextReader()
 
+	if err == ws.ErrReadLimit {
+		return f, c.exceeded(LimitError{LimitFrameSize, c.readLimit})
+	}
+
 	if err != nil {
-		return data, err
+		return f, err
 	}
 
-	if msgType != ws.TextMessage {
-		return data, ErrUnsupportedBinaryMessage
+	f.Binary = msgType == ws.BinaryMessage
+
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+
+	if !f.Binary {
+		w = io.MultiWriter(newScanner(
+			limit(c.transport.MaxJSONDepth, MaxJSONDepth),
+			limit(c.transport.MaxArgs, MaxArgs),
+		), &buf)
 	}
 
-	data, err = ioutil.ReadAll(reader)
+	_, err = io.Copy(w, reader)
 
-	if err != nil {
-		return data, ErrBadBuffer
+	switch e := err.(type) {
+	case nil:
+	case LimitError:
+		return f, c.exceeded(e)
+	default:
+		if err == ws.ErrReadLimit {
+			return f, c.exceeded(LimitError{LimitFrameSize, c.readLimit})
+		}
+
+		return f, ErrBadBuffer
 	}
 
-	if len(data) == 0 {
-		return data, ErrPacketType
+	f.Data = buf.Bytes()
+
+	if len(f.Data) == 0 {
+		return f, ErrPacketType
 	}
 
-	return data, nil
+	return f, nil
 }
 
-// WriteMessage to the socket
-func (c *Connection) WriteMessage(message string) error {
-	c.socket.SetWriteDeadline(time.Now().Add(c.transport.SendTimeout))
-	writer, err := c.socket.NextWriter(ws.TextMessage)
+// exceeded closes the connection because a frame exceeded a limit.
+func (c *Connection) exceeded(err LimitError) LimitError {
+	var code = ws.ClosePolicyViolation
+
+	if err.limit == LimitFrameSize {
+		code = ws.CloseMessageTooBig
+	}
+
+	// with a clock other than clock.Real, the write timer expires the close message instead of its deadline
+	var deadline time.Time
+
+	if c.writeTimer == nil {
+		deadline = time.Now().Add(time.Second)
+	} else {
+		c.writeTimer.Stop()
+		c.writeTimer.Reset(time.Second)
+		defer c.stop(c.writeTimer)
+	}
+
+	// gorilla's websocket already sent the close message when the frame is too large
+	_ = c.socket.WriteControl(ws.CloseMessage, ws.FormatCloseMessage(code, err.Error()), deadline)
+	c.socket.Close()
+	return err
+}
+
+// WriteFrame to the socket, either text or binary.
+func (c *Connection) WriteFrame(f Frame) error {
+	var msgType = ws.TextMessage
+
+	if f.Binary {
+		msgType = ws.BinaryMessage
+	}
+
+	c.deadline(c.writeTimer, c.socket.SetWriteDeadline, c.transport.SendTimeout)
+	defer c.stop(c.writeTimer)
+
+	writer, err := c.socket.NextWriter(msgType)
 
 	if err != nil {
 		return err
 	}
 
-	if _, err := writer.Write([]byte(message)); err != nil {
+	if _, err := writer.Write(f.Data); err != nil {
 		return err
 	}
 
 	return writer.Close()
 }
 
+// WriteFrames to the socket, coalescing them into as few network writes as possible.
+// It returns the error for each frame.
+func (c *Connection) WriteFrames(frames []Frame) []error {
+	var errs = make([]error, len(frames))
+
+	if len(frames) == 1 || c.conn == nil {
+		for i, f := range frames {
+			errs[i] = c.WriteFrame(f)
+		}
+
+		return errs
+	}
+
+	c.conn.batch()
+
+	for i, f := range frames {
+		errs[i] = c.WriteFrame(f)
+	}
+
+	// frames are only sent by the flush
+	c.deadline(c.writeTimer, c.socket.SetWriteDeadline, c.transport.SendTimeout)
+	defer c.stop(c.writeTimer)
+
+	if err := c.conn.flush(); err != nil {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+	}
+
+	return errs
+}
+
+// deadline sets the timeout of the next operation with set, such as SetReadDeadline,
+// or, with a clock other than clock.Real, starts the timer expiring it.
+func (c *Connection) deadline(timer clock.Timer, set func(time.Time) error, timeout time.Duration) {
+	if timer == nil {
+		set(time.Now().Add(timeout))
+		return
+	}
+
+	timer.Stop()
+	set(time.Time{})
+	timer.Reset(timeout)
+}
+
+func (c *Connection) stop(timer clock.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// expire creates a stopped timer of the clock that sets a deadline in the past, failing the pending operation.
+func expire(clk clock.Clock, set func(time.Time) error) clock.Timer {
+	timer := clk.AfterFunc(time.Hour, func() {
+		set(time.Unix(1, 0))
+	})
+
+	timer.Stop()
+	return timer
+}
+
 // Close the connection
 func (c *Connection) Close() {
+	c.stop(c.readTimer)
+	c.stop(c.writeTimer)
 	c.socket.Close()
 }
 
@@ -105,7 +267,16 @@ type Transport struct {
 
 	BufferSize int
 
+	// Limits for the frames read from the connection. Zero uses the default, and negative disables the limit.
+	// MaxArgs is the maximum number of arguments of the events and acks of text packets.
+	MaxFrameSize int64
+	MaxJSONDepth int
+	MaxArgs      int
+
 	RequestHeader http.Header
+
+	// Clock for the read and send timeouts. If nil, clock.Real is used.
+	Clock clock.Clock
 }
 
 // NewTransport creates a new WebSocket connection transport
@@ -116,6 +287,9 @@ func NewTransport() *Transport {
 		ReadTimeout:   ReadTimeout,
 		SendTimeout:   SendTimeout,
 		BufferSize:    BufferSize,
+		MaxFrameSize:  MaxFrameSize,
+		MaxJSONDepth:  MaxJSONDepth,
+		MaxArgs:       MaxArgs,
 		RequestHeader: http.Header{},
 	}
 
@@ -126,12 +300,106 @@ func NewTransport() *Transport {
 
 // Connect to web socket
 func (wst *Transport) Connect(url string) (conn *Connection, err error) {
-	dialer := ws.Dialer{}
-	socket, _, err := dialer.Dial(url, wst.RequestHeader)
+	return wst.ConnectContext(context.Background(), url)
+}
+
+// Dial the web socket, implementing transport.Transport.
+func (wst *Transport) Dial(ctx context.Context, url string) (transport.Conn, error) {
+	conn, err := wst.ConnectContext(ctx, url)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &Connection{socket, wst}, nil
+	return conn, nil
+}
+
+// ConnectContext connects to web socket using the provided context.
+func (wst *Transport) ConnectContext(ctx context.Context, url string) (conn *Connection, err error) {
+	var bc *batchConn
+
+	dialer := ws.Dialer{
+		NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var d net.Dialer
+			c, err := d.DialContext(ctx, network, addr)
+
+			if err != nil {
+				return nil, err
+			}
+
+			bc = newBatchConn(c, wst.BufferSize)
+			return bc, nil
+		},
+	}
+
+	socket, _, err := dialer.DialContext(ctx, url, wst.RequestHeader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var readLimit = wst.frameLimit()
+
+	if readLimit > 0 {
+		socket.SetReadLimit(readLimit)
+	}
+
+	conn = &Connection{
+		socket:    socket,
+		conn:      bc,
+		transport: wst,
+		readLimit: readLimit,
+	}
+
+	if clk := clock.Or(wst.Clock); clk != clock.Real {
+		conn.readTimer = expire(clk, socket.SetReadDeadline)
+		conn.writeTimer = expire(clk, socket.SetWriteDeadline)
+	}
+
+	return conn, nil
+}
+
+// batchConn holds the frames written while batching until they are flushed.
+type batchConn struct {
+	net.Conn
+
+	buf      *bufio.Writer
+	batching bool
+	locker   sync.Mutex
+}
+
+func newBatchConn(c net.Conn, size int) *batchConn {
+	if size <= 0 {
+		size = BufferSize
+	}
+
+	return &batchConn{
+		Conn: c,
+		buf:  bufio.NewWriterSize(c, size),
+	}
+}
+
+func (b *batchConn) Write(p []byte) (int, error) {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	if b.batching {
+		return b.buf.Write(p)
+	}
+
+	return b.Conn.Write(p)
+}
+
+func (b *batchConn) batch() {
+	b.locker.Lock()
+	b.batching = true
+	b.locker.Unlock()
+}
+
+func (b *batchConn) flush() error {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+
+	b.batching = false
+	return b.buf.Flush()
 }
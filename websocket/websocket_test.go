@@ -0,0 +1,79 @@
+package websocket
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/wedeploy/gosocketio/clock"
+)
+
+func TestReadTimeoutClock(t *testing.T) {
+	var upgrader ws.Upgrader
+	stop := make(chan struct{})
+	defer close(stop)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+
+		if err != nil {
+			return
+		}
+
+		// never sends anything
+		<-stop
+		conn.Close()
+	}))
+
+	defer s.Close()
+
+	f := clock.NewFake(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	tr := NewTransport()
+	tr.Clock = f
+
+	conn, err := tr.Connect("ws" + strings.TrimPrefix(s.URL, "http"))
+
+	if err != nil {
+		t.Fatalf("Expected no error connecting, got %v instead", err)
+	}
+
+	defer conn.Close()
+
+	read := make(chan error, 1)
+
+	go func() {
+		_, err := conn.ReadFrame()
+		read <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := f.WaitTimers(ctx, 1); err != nil {
+		t.Fatalf("Expected read timeout to start, got %v instead", err)
+	}
+
+	f.Advance(ReadTimeout - time.Millisecond)
+
+	select {
+	case err := <-read:
+		t.Fatalf("Expected read not to time out yet, got %v instead", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	f.Advance(time.Millisecond)
+
+	select {
+	case err := <-read:
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Errorf("Expected timeout error, got %v instead", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Expected read to time out")
+	}
+}